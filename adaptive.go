@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// sends slower than this on average make the period longer
+	slowSendLatency = time.Second
+	// sends faster than this on average make the period shorter
+	fastSendLatency = 250 * time.Millisecond
+	// how much weight each new latency gets in the moving average
+	sendLatencyWeight = 0.2
+	// how much the period changes by at a time
+	adaptiveStep = 0.25
+)
+
+// adaptivePeriod is the time between sends when conf.Librato.Adaptive is set.
+// it grows when the backend is slow and shrinks again when it's fast, staying
+// between min and max. the latency is smoothed and there is a band between
+// slow and fast where the period is left alone, so it doesn't flap.
+type adaptivePeriod struct {
+	mu       sync.Mutex
+	min, max time.Duration
+	current  time.Duration
+	latency  float64 // moving average, in seconds
+	observed bool
+}
+
+// sendPeriod is only set when the send period is adaptive
+var sendPeriod *adaptivePeriod
+
+func newAdaptivePeriod(shortest, longest time.Duration) *adaptivePeriod {
+	return &adaptivePeriod{min: shortest, max: longest, current: shortest}
+}
+
+// observe records how long a send took and adjusts the period
+func (a *adaptivePeriod) observe(latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.observed {
+		a.latency = latency.Seconds()
+		a.observed = true
+	} else {
+		a.latency = sendLatencyWeight*latency.Seconds() + (1-sendLatencyWeight)*a.latency
+	}
+	switch {
+	case a.latency > slowSendLatency.Seconds():
+		a.current = time.Duration(float64(a.current) * (1 + adaptiveStep))
+	case a.latency < fastSendLatency.Seconds():
+		a.current = time.Duration(float64(a.current) * (1 - adaptiveStep))
+	}
+	if a.current < a.min {
+		a.current = a.min
+	}
+	if a.current > a.max {
+		a.current = a.max
+	}
+}
+
+// period returns how long to wait before the next send
+func (a *adaptivePeriod) period() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.current
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOnlyLibratoPostsAdaptPeriod(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	old := sendPeriod
+	defer func() { sendPeriod = old }()
+	sendPeriod = newAdaptivePeriod(time.Second, time.Minute)
+	observed := func() bool {
+		sendPeriod.mu.Lock()
+		defer sendPeriod.mu.Unlock()
+		return sendPeriod.observed
+	}
+	payload := func() *libratoPayload {
+		p := newLibratoPayload()
+		p.addMetric(testGauge("load", 1))
+		return p
+	}
+
+	// a backend that isn't Librato, or a POST that didn't complete, says
+	// nothing about how fast Librato is
+	useConfig(t, `{"Dogstatsd": {"Addr": "`+listener.LocalAddr().String()+`"}}`)
+	flushPayload(backends[""], payload())
+	useConfig(t, `{"Librato": {"Url": "http://127.0.0.1:1/v1/metrics"}}`)
+	flushPayload(backends[""], payload())
+	if observed() {
+		t.Fatal("Expected sends that weren't completed Librato POSTs to be ignored")
+	}
+
+	useConfig(t, `{}`)
+	flushPayload(backends[""], payload())
+	if !observed() {
+		t.Error("Expected a completed Librato POST to be observed")
+	}
+}
+
+func TestPeriodGrowsThenShrinks(t *testing.T) {
+	a := newAdaptivePeriod(5*time.Second, 20*time.Second)
+	a.observe(3 * time.Second)
+	grown := a.period()
+	if grown <= 5*time.Second {
+		t.Fatalf("Expected the period to grow after a slow send, got %s", grown)
+	}
+	for i := 0; i < 20; i++ {
+		a.observe(3 * time.Second)
+	}
+	if a.period() != 20*time.Second {
+		t.Errorf("Expected slow sends to stop at the max period, got %s", a.period())
+	}
+	// the average has to come down before the period does, so one fast
+	// send isn't enough to undo it
+	a.observe(10 * time.Millisecond)
+	if a.period() != 20*time.Second {
+		t.Errorf("Expected a single fast send to leave the period alone, got %s", a.period())
+	}
+	for i := 0; i < 30; i++ {
+		a.observe(10 * time.Millisecond)
+	}
+	if a.period() != 5*time.Second {
+		t.Errorf("Expected fast sends to shrink the period back to the min, got %s", a.period())
+	}
+}
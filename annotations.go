@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// version is the version of grotto, set at build time with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
+// the annotation stream that grotto's events are posted to
+const annotationStream = "grotto"
+
+// annotation is an event posted to Librato's annotations API, which shows up
+// as a marker on dashboards
+type annotation struct {
+	Title       string `json:"title"`
+	Source      string `json:"source,omitempty"`
+	Description string `json:"description,omitempty"`
+	StartTime   int64  `json:"start_time"`
+}
+
+// annotate posts an annotation about this host when conf.Librato.Annotations
+// is set. it runs in the background, and failures are only logged so that
+// they never hold up sending metrics.
+func annotate(title string) {
+	if !conf.Librato.Annotations {
+		return
+	}
+	a := annotation{
+		Title:       title,
+		Source:      hostname,
+		Description: fmt.Sprintf("grotto %s on %s", version, hostname),
+		StartTime:   time.Now().Unix(),
+	}
+	go func() {
+		if err := postAnnotation(a); err != nil {
+			limitedf("Could not post annotation: %s\n", err)
+		}
+	}()
+}
+
+// postAnnotation sends an annotation to the grotto stream, which lives next
+// to the metrics endpoint in conf.Librato.Url
+func postAnnotation(a annotation) error {
+	base, err := url.Parse(conf.Librato.Url)
+	if err != nil {
+		return err
+	}
+	loc := base.ResolveReference(&url.URL{Path: "annotations/" + annotationStream})
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", loc.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	credentials := fmt.Sprintf("%s:%s", conf.Librato.Email, conf.Librato.Token)
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(credentials))))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Librato responded with %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStartupAnnotation(t *testing.T) {
+	posted := make(chan *http.Request, 1)
+	annotations := make(chan annotation, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a annotation
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("Could not parse annotation: %s", err)
+		}
+		posted <- r
+		annotations <- a
+	}))
+	defer server.Close()
+	c := useConfig(t, `{}`)
+	c.Librato.Url = server.URL + "/v1/metrics"
+	annotate("grotto started")
+	if len(posted) != 0 {
+		t.Fatal("Expected no annotation without conf.Librato.Annotations")
+	}
+	c.Librato.Annotations = true
+	annotate("grotto started")
+	select {
+	case r := <-posted:
+		if r.URL.Path != "/v1/annotations/grotto" {
+			t.Errorf("Expected the annotation to go to /v1/annotations/grotto, got %s", r.URL.Path)
+		}
+		if user, token, ok := r.BasicAuth(); !ok || user != "e" || token != "t" {
+			t.Errorf("Expected the Librato credentials, got %s and %s", user, token)
+		}
+		if a := <-annotations; a.Title != "grotto started" || a.Source != "test" {
+			t.Errorf("Unexpected annotation %+v", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No annotation was posted")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import "fmt"
+
+// a backend is somewhere that payloads of metrics get sent
+type backend interface {
+	// name identifies the backend in log messages
+	name() string
+	send(payload *libratoPayload) error
+}
+
+// backendConfig describes one of the named backends in conf.Backends.
+// librato backends that leave out the url or credentials use the ones in
+// conf.Librato.
+type backendConfig struct {
+	Type  string // librato or dogstatsd
+	Url   string
+	Email string
+	Token string
+	Addr  string
+	Tags  map[string]string
+}
+
+// newBackend returns the primary backend selected by the config. DogStatsD
+// is used when it has an address, then a file when conf.FileOutput has a
+// path, otherwise metrics go to Librato.
+func newBackend() backend {
+	if conf.Dogstatsd.Addr != "" {
+		return &dogstatsdBackend{addr: conf.Dogstatsd.Addr, tags: conf.Dogstatsd.Tags}
+	}
+	if conf.FileOutput.Path != "" {
+		return newFileBackend(conf.FileOutput.Path, conf.FileOutput.MaxBytes, seconds(conf.FileOutput.SyncSeconds))
+	}
+	return newLibratoBackend("librato", conf.Librato.Url, conf.Librato.Email, conf.Librato.Token)
+}
+
+// newBackends returns every backend metrics can be routed to, keyed by their
+// name in conf.Backends. the primary backend is under the empty name. librato
+// backends are wrapped in a circuitBreaker when conf.Librato.FailureThreshold
+// is set, and every backend is wrapped in a successGate when
+// conf.Librato.MinSuccessRate is.
+func newBackends() (map[string]backend, error) {
+	backends := map[string]backend{"": newBackend()}
+	for name, bc := range conf.Backends {
+		switch bc.Type {
+		case "librato":
+			b := newLibratoBackend(name, conf.Librato.Url, conf.Librato.Email, conf.Librato.Token)
+			if bc.Url != "" {
+				b.url = bc.Url
+			}
+			if bc.Email != "" {
+				b.email = bc.Email
+			}
+			if bc.Token != "" {
+				b.token = bc.Token
+			}
+			if b.url == "" || b.email == "" || b.token == "" {
+				return nil, fmt.Errorf("Backend %s is missing a Url, Email or Token for Librato", name)
+			}
+			backends[name] = b
+		case "dogstatsd":
+			if bc.Addr == "" {
+				return nil, fmt.Errorf("Backend %s is missing an Addr for DogStatsD", name)
+			}
+			backends[name] = &dogstatsdBackend{label: name, addr: bc.Addr, tags: bc.Tags}
+		default:
+			return nil, fmt.Errorf("Backend %s has unknown Type %q, expected librato or dogstatsd", name, bc.Type)
+		}
+	}
+	if conf.Librato.FailureThreshold > 0 {
+		for name, b := range backends {
+			if _, ok := b.(*libratoBackend); ok {
+				backends[name] = newCircuitBreaker(b)
+			}
+		}
+	}
+	if conf.Librato.MinSuccessRate > 0 {
+		for name, b := range backends {
+			backends[name] = newSuccessGate(b)
+		}
+	}
+	return backends, nil
+}
+
+// stopBackends gives the backends that hold on to metrics, like fileBackend,
+// a chance to write them out before grotto exits
+func stopBackends() {
+	for _, b := range backends {
+		if s, ok := b.(stopper); ok {
+			s.stop()
+		}
+	}
+}
+
+// libratoBackend sends payloads to the Librato metrics API
+type libratoBackend struct {
+	label string
+	url   string
+	email string
+	token string
+}
+
+func newLibratoBackend(label, url, email, token string) *libratoBackend {
+	return &libratoBackend{label: label, url: url, email: email, token: token}
+}
+
+func (b *libratoBackend) name() string {
+	return b.label
+}
+
+func (b *libratoBackend) send(payload *libratoPayload) error {
+	return sendPayload(b, payload)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeBackend keeps the payloads sent to it, failing each send with err when
+// it is set
+type fakeBackend struct {
+	mu       sync.Mutex
+	payloads []*libratoPayload
+	err      error
+}
+
+func (b *fakeBackend) name() string {
+	return "fake"
+}
+
+func (b *fakeBackend) send(payload *libratoPayload) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.payloads = append(b.payloads, payload)
+	return nil
+}
+
+func (b *fakeBackend) sent() []*libratoPayload {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*libratoPayload(nil), b.payloads...)
+}
+
+func TestCollectorsRouteToBackends(t *testing.T) {
+	useConfig(t, `{"Backends": {"statsd": {"Type": "dogstatsd", "Addr": "127.0.0.1:8125"}}, "Procs": {"Backend": "statsd"}}`)
+	if b, ok := backends["statsd"].(*dogstatsdBackend); !ok || b.name() != "statsd" {
+		t.Fatalf("Expected a DogStatsD backend named statsd, got %v", backends["statsd"])
+	}
+	if backend := new(procsCollector).backend(); backend != "statsd" {
+		t.Fatalf("Expected the procs collector to be routed to statsd, got %q", backend)
+	}
+	primary, statsd := new(fakeBackend), new(fakeBackend)
+	backends = map[string]backend{"": primary, "statsd": statsd}
+	flushRequests := make(chan os.Signal, 1)
+	metrics := startTestSender(t, flushRequests, nil)
+	metrics <- testGauge("cpu-total-usage", 0.5)
+	metrics <- routedMetric{backend: "statsd", metric: testGauge("procs-zombie", 2)}
+	flushRequests <- syscall.SIGUSR1
+	waitFor(t, 5*time.Second, func() bool { return len(primary.sent()) > 0 && len(statsd.sent()) > 0 })
+	if names := gaugeNames(primary.sent()...); !reflect.DeepEqual(names, []string{"cpu-total-usage"}) {
+		t.Errorf("Expected the cpu gauge to go to the primary backend, got %v", names)
+	}
+	if names := gaugeNames(statsd.sent()...); !reflect.DeepEqual(names, []string{"procs-zombie"}) {
+		t.Errorf("Expected the procs gauge to go to statsd, got %v", names)
+	}
+}
+
+func TestUnknownBackendType(t *testing.T) {
+	useConfig(t, `{}`)
+	conf.Backends = map[string]*backendConfig{"graphite": {Type: "graphite"}}
+	if _, err := newBackends(); err == nil {
+		t.Error("Expected an error for an unknown backend type")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// a baseline older than this is not trusted, since the cpu time in it could
+// cover anything that happened while grotto wasn't running
+const maxBaselineAge = 10 * time.Minute
+
+// cpuBaseline is what is written to conf.Cpu.PersistBaselineFile when grotto
+// stops, so that the first reading after it starts again has something to
+// be compared against
+type cpuBaseline struct {
+	Saved    int64 // epoch seconds
+	BootTime int64
+	Stats    []persistedCpuStat
+}
+
+type persistedCpuStat struct {
+	Name   string
+	User   int
+	Nice   int
+	System int
+	Idle   int
+	Iowait int
+	Total  int
+}
+
+// saveCpuBaseline writes the latest reading of each cpu to loc
+func saveCpuBaseline(loc string, bootTime int64, lookup map[string]cpuStat) error {
+	baseline := cpuBaseline{Saved: time.Now().Unix(), BootTime: bootTime}
+	for _, stat := range lookup {
+		baseline.Stats = append(baseline.Stats, persistedCpuStat{stat.name, stat.user, stat.nice, stat.system, stat.idle, stat.iowait, stat.total})
+	}
+	data, err := json.Marshal(baseline)
+	if err != nil {
+		return err
+	}
+	// write it next to where it goes and move it into place so that
+	// stopping halfway through doesn't leave half a file behind
+	tmp := loc + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, loc)
+}
+
+// loadCpuBaseline reads the readings saved in loc. nothing is returned when
+// there is no file, or when the readings are too old or from before the
+// host last booted.
+func loadCpuBaseline(loc string, bootTime int64) (map[string]cpuStat, error) {
+	data, err := ioutil.ReadFile(loc)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var baseline cpuBaseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, err
+	}
+	if baseline.BootTime != bootTime || time.Since(time.Unix(baseline.Saved, 0)) > maxBaselineAge {
+		return nil, nil
+	}
+	lookup := make(map[string]cpuStat)
+	for _, s := range baseline.Stats {
+		lookup[s.Name] = cpuStat{name: s.Name, user: s.User, nice: s.Nice, system: s.System, idle: s.Idle, iowait: s.Iowait, total: s.Total}
+	}
+	return lookup, nil
+}
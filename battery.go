@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// batteryCollector reports the charge of each battery, as
+// battery-<name>-capacity-percent, and whether it is charging. hosts without
+// batteries report nothing.
+type batteryCollector struct{}
+
+func (c *batteryCollector) name() string {
+	return "battery"
+}
+
+func (c *batteryCollector) period() time.Duration {
+	return seconds(conf.Battery.PeriodSeconds)
+}
+
+func (c *batteryCollector) backend() string {
+	return conf.Battery.Backend
+}
+
+func (c *batteryCollector) source() string {
+	return filepath.Join(conf.Battery.SysfsRoot, "class/power_supply")
+}
+
+func (c *batteryCollector) collect() ([]interface{}, error) {
+	supplies, err := filepath.Glob(filepath.Join(conf.Battery.SysfsRoot, "class/power_supply/*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(supplies)
+	epoch := measureTime(time.Now())
+	var metrics []interface{}
+	for _, dir := range supplies {
+		// mains adapters and the like are in here too
+		if kind, err := readFileString(filepath.Join(dir, "type")); err != nil || kind != "Battery" {
+			continue
+		}
+		prefix := "battery-" + filepath.Base(dir)
+		newGauge := func(name string, value float64) gauge {
+			return gauge{Name: prefix + "-" + name, MeasureTime: epoch, Value: value, Source: hostname}
+		}
+		if capacity, ok, err := readBatteryCapacity(dir); err != nil {
+			return nil, err
+		} else if ok {
+			metrics = append(metrics, newGauge("capacity-percent", capacity))
+		}
+		status, err := readFileString(filepath.Join(dir, "status"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		charging := 0.0
+		if status == "Charging" {
+			charging = 1
+		}
+		metrics = append(metrics, newGauge("charging", charging))
+	}
+	return metrics, nil
+}
+
+func (c *batteryCollector) describe() []string {
+	return []string{"battery-<name>-capacity-percent", "battery-<name>-charging"}
+}
+
+// readBatteryCapacity returns how charged the battery in dir is, between 0
+// and 1. the capacity file has it as a percentage, and where it is missing it
+// is worked out from energy_now and energy_full.
+func readBatteryCapacity(dir string) (float64, bool, error) {
+	capacity, err := readInt64File(filepath.Join(dir, "capacity"))
+	if err == nil {
+		return float64(capacity) / 100, true, nil
+	}
+	if !os.IsNotExist(err) {
+		return 0, false, err
+	}
+	now, err := readInt64File(filepath.Join(dir, "energy_now"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	full, err := readInt64File(filepath.Join(dir, "energy_full"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if full <= 0 {
+		return 0, false, nil
+	}
+	return float64(now) / float64(full), true, nil
+}
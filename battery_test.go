@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatteries(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"class/power_supply/AC/type":          "Mains\n",
+		"class/power_supply/AC/online":        "1\n",
+		"class/power_supply/BAT0/type":        "Battery\n",
+		"class/power_supply/BAT0/capacity":    "80\n",
+		"class/power_supply/BAT0/status":      "Charging\n",
+		"class/power_supply/BAT1/type":        "Battery\n",
+		"class/power_supply/BAT1/energy_now":  "25000000\n",
+		"class/power_supply/BAT1/energy_full": "50000000\n",
+		"class/power_supply/BAT1/status":      "Discharging\n",
+	})
+	useConfig(t, `{"Battery": {"SysfsRoot": "`+root+`"}}`)
+	metrics, err := new(batteryCollector).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{
+		"battery-BAT0-capacity-percent": .8,
+		"battery-BAT0-charging":         1,
+		"battery-BAT1-capacity-percent": .5,
+		"battery-BAT1-charging":         0,
+	}
+	if values := gaugeValues(metrics); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+}
+
+func TestNoBatteries(t *testing.T) {
+	useConfig(t, `{"Battery": {"SysfsRoot": "`+t.TempDir()+`"}}`)
+	if metrics, err := new(batteryCollector).collect(); err != nil || len(metrics) != 0 {
+		t.Errorf("Expected nothing without batteries, got %v, %v", metrics, err)
+	}
+}
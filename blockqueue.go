@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// blockQueueStat holds the busy time counters for one block device from
+// /sys/block/<device>/stat
+type blockQueueStat struct {
+	ioMs      int64 // time the device had I/O in flight
+	queueMs   int64 // time spent by all I/O, so overlapping I/O counts more than once
+	timestamp time.Time
+}
+
+// blockQueueCollector reports how busy each block device was and how deep
+// its queue was on average, worked out from the change in sysfs between
+// readings
+type blockQueueCollector struct {
+	lookup map[string]blockQueueStat
+}
+
+func newBlockQueueCollector() *blockQueueCollector {
+	return &blockQueueCollector{lookup: make(map[string]blockQueueStat)}
+}
+
+// the rates need a reading to compare against, see differencer
+func (c *blockQueueCollector) differences() bool {
+	return true
+}
+
+func (c *blockQueueCollector) name() string {
+	return "blockqueue"
+}
+
+func (c *blockQueueCollector) period() time.Duration {
+	return seconds(conf.BlockQueue.PeriodSeconds)
+}
+
+func (c *blockQueueCollector) backend() string {
+	return conf.BlockQueue.Backend
+}
+
+func (c *blockQueueCollector) source() string {
+	return filepath.Join(conf.BlockQueue.SysfsRoot, "block/<device>/stat")
+}
+
+func (c *blockQueueCollector) collect() ([]interface{}, error) {
+	stats, err := readBlockQueueStats(conf.BlockQueue.SysfsRoot)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]string, 0, len(stats))
+	for device := range stats {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	var metrics []interface{}
+	for _, device := range devices {
+		stat := stats[device]
+		previous, ok := c.lookup[device]
+		c.lookup[device] = stat
+		if !ok {
+			continue
+		}
+		elapsedMs := float64(stat.timestamp.Sub(previous.timestamp)) / float64(time.Millisecond)
+		if elapsedMs <= 0 || stat.ioMs < previous.ioMs || stat.queueMs < previous.queueMs {
+			continue
+		}
+		epoch := measureTime(stat.timestamp)
+		metrics = append(metrics,
+			gauge{Name: fmt.Sprintf("disk-%s-util-percent", device), MeasureTime: epoch, Value: float64(stat.ioMs-previous.ioMs) / elapsedMs, Source: hostname},
+			gauge{Name: fmt.Sprintf("disk-%s-avg-queue", device), MeasureTime: epoch, Value: float64(stat.queueMs-previous.queueMs) / elapsedMs, Source: hostname},
+		)
+	}
+	return metrics, nil
+}
+
+func (c *blockQueueCollector) describe() []string {
+	return []string{"disk-<device>-util-percent", "disk-<device>-avg-queue"}
+}
+
+// readBlockQueueStats reads the stat file of each device in <root>/block.
+// devices without one are left out, as are loop and ram devices like in
+// readDiskStats.
+func readBlockQueueStats(root string) (map[string]blockQueueStat, error) {
+	dirs, err := filepath.Glob(filepath.Join(root, "block/*"))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	stats := make(map[string]blockQueueStat)
+	for _, dir := range dirs {
+		device := filepath.Base(dir)
+		if strings.HasPrefix(device, "loop") || strings.HasPrefix(device, "ram") {
+			continue
+		}
+		contents, err := readFileString(filepath.Join(dir, "stat"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		// io_ticks and time_in_queue are the 10th and 11th fields
+		fields := strings.Fields(contents)
+		if len(fields) < 11 {
+			continue
+		}
+		stat := blockQueueStat{timestamp: now}
+		if stat.ioMs, err = parseInt64(fields[9]); err != nil {
+			return nil, err
+		}
+		if stat.queueMs, err = parseInt64(fields[10]); err != nil {
+			return nil, err
+		}
+		stats[device] = stat
+	}
+	return stats, nil
+}
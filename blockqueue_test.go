@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeBlockStat writes the sysfs stat of sda with the given io_ticks and
+// time_in_queue
+func writeBlockStat(t *testing.T, sysfs string, ioMs, queueMs int) {
+	writeFiles(t, filepath.Join(sysfs, "block/sda"), map[string]string{
+		"stat": fmt.Sprintf("  100 0 800 40 50 0 400 60 0 %d %d\n", ioMs, queueMs),
+	})
+}
+
+func TestBlockQueueUtilization(t *testing.T) {
+	sysfs := t.TempDir()
+	useConfig(t, `{"BlockQueue": {"SysfsRoot": "`+sysfs+`"}}`)
+	// a device without a stat file is left alone
+	writeFiles(t, filepath.Join(sysfs, "block/sr0"), map[string]string{"size": "0\n"})
+	c := newBlockQueueCollector()
+	writeBlockStat(t, sysfs, 1000, 2000)
+	if _, err := c.collect(); err != nil {
+		t.Fatal(err)
+	}
+	// pretend the first reading was ten seconds ago
+	previous := c.lookup["sda"]
+	previous.timestamp = previous.timestamp.Add(-10 * time.Second)
+	c.lookup["sda"] = previous
+	// busy for 2.5 of the 10 seconds with 3 I/Os in flight on average
+	writeBlockStat(t, sysfs, 3500, 32000)
+	metrics, err := c.collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := gaugeValues(metrics)
+	if len(values) != 2 {
+		t.Errorf("Expected gauges for sda only, got %v", values)
+	}
+	for name, expected := range map[string]float64{"disk-sda-util-percent": 0.25, "disk-sda-avg-queue": 3} {
+		if value, ok := values[name]; !ok || math.Abs(value-expected) > expected/100 {
+			t.Errorf("Expected %s to be %v, got %v", name, expected, value)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cgroupCpuCollector reports how much the cgroup grotto runs in is being
+// throttled by its cpu limit, from the cgroup v2 cpu.stat file. nothing is
+// reported outside of a cgroup or when there is no cpu limit.
+type cgroupCpuCollector struct {
+	rates counterRates
+}
+
+// the rates need a reading to compare against, see differencer
+func (c *cgroupCpuCollector) differences() bool {
+	return true
+}
+
+func (c *cgroupCpuCollector) name() string {
+	return "cgroupcpu"
+}
+
+func (c *cgroupCpuCollector) period() time.Duration {
+	return seconds(conf.CgroupCpu.PeriodSeconds)
+}
+
+func (c *cgroupCpuCollector) backend() string {
+	return conf.CgroupCpu.Backend
+}
+
+func (c *cgroupCpuCollector) source() string {
+	return filepath.Join(conf.CgroupCpu.CgroupRoot, "cpu.stat")
+}
+
+func (c *cgroupCpuCollector) collect() ([]interface{}, error) {
+	stats, err := readKeyValueFile(filepath.Join(conf.CgroupCpu.CgroupRoot, "cpu.stat"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := stats["nr_periods"]; !ok {
+		// only there when a limit is set
+		return nil, nil
+	}
+	now := time.Now()
+	rates := c.rates.update(map[string]int64{
+		"nr_periods":     stats["nr_periods"],
+		"nr_throttled":   stats["nr_throttled"],
+		"throttled_usec": stats["throttled_usec"],
+	}, now)
+	if rates == nil {
+		return nil, nil
+	}
+	newGauge := func(name string, value float64) gauge {
+		return gauge{Name: name, MeasureTime: measureTime(now), Value: value, Source: hostname}
+	}
+	metrics := []interface{}{
+		newGauge("cgroup-cpu-throttled-periods-per-sec", rates["nr_throttled"]),
+		newGauge("cgroup-cpu-throttled-seconds-per-sec", rates["throttled_usec"]/1e6),
+	}
+	if rates["nr_periods"] > 0 {
+		metrics = append(metrics, newGauge("cgroup-cpu-throttled-percent", rates["nr_throttled"]/rates["nr_periods"]))
+	}
+	return metrics, nil
+}
+
+func (c *cgroupCpuCollector) describe() []string {
+	return []string{
+		"cgroup-cpu-throttled-periods-per-sec",
+		"cgroup-cpu-throttled-seconds-per-sec",
+		"cgroup-cpu-throttled-percent",
+	}
+}
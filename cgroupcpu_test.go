@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCgroupThrottleRate(t *testing.T) {
+	root := t.TempDir()
+	useConfig(t, `{"CgroupCpu": {"CgroupRoot": "`+root+`"}}`)
+	c := new(cgroupCpuCollector)
+	metrics, err := c.collect()
+	if err != nil || len(metrics) != 0 {
+		t.Fatalf("Expected nothing outside of a cgroup, got %v and %v", metrics, err)
+	}
+	for i, stat := range []string{
+		"usage_usec 1000\nnr_periods 100\nnr_throttled 10\nthrottled_usec 50000\n",
+		"usage_usec 2000\nnr_periods 200\nnr_throttled 60\nthrottled_usec 550000\n",
+	} {
+		writeFiles(t, root, map[string]string{"cpu.stat": stat})
+		if metrics, err = c.collect(); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			// pretend the first reading was ten seconds ago
+			c.rates.previousTime = c.rates.previousTime.Add(-10 * time.Second)
+		}
+	}
+	values := gaugeValues(metrics)
+	for name, expected := range map[string]float64{
+		"cgroup-cpu-throttled-periods-per-sec": 5,
+		"cgroup-cpu-throttled-seconds-per-sec": 0.05,
+		"cgroup-cpu-throttled-percent":         0.5,
+	} {
+		if value, ok := values[name]; !ok || math.Abs(value-expected) > expected/100 {
+			t.Errorf("Expected %s to be %v, got %v", name, expected, value)
+		}
+	}
+}
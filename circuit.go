@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// the states of a circuitBreaker, which are also the values of its gauge
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+var errCircuitOpen = errors.New("circuit is open, not sending")
+
+// circuitBreaker stops sending to a backend that keeps failing. after
+// conf.Librato.FailureThreshold failures in a row the circuit opens and
+// nothing is sent for conf.Librato.CircuitCooldownSeconds. after that a
+// single payload is let through as a probe, which closes the circuit if it
+// succeeds and opens it again if it doesn't.
+type circuitBreaker struct {
+	backend
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(b backend) *circuitBreaker {
+	return &circuitBreaker{
+		backend:   b,
+		threshold: conf.Librato.FailureThreshold,
+		cooldown:  seconds(conf.Librato.CircuitCooldownSeconds),
+	}
+}
+
+func (b *circuitBreaker) send(payload *libratoPayload) error {
+	if !b.allow() {
+		return errCircuitOpen
+	}
+	err := b.backend.send(payload)
+	b.record(err)
+	return err
+}
+
+// allow reports whether a payload may be sent, moving an open circuit whose
+// cooldown has passed to half open and letting this payload be the probe
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// the probe is still out
+		return false
+	}
+	return true
+}
+
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.state != circuitClosed {
+			fmt.Printf("Closing the circuit to %s\n", b.name())
+		}
+		b.state, b.failures = circuitClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		if b.state != circuitOpen {
+			fmt.Printf("Opening the circuit to %s for %s after %d failures\n", b.name(), b.cooldown, b.failures)
+		}
+		b.state, b.openedAt = circuitOpen, time.Now()
+	}
+}
+
+// current returns the state of the circuit
+func (b *circuitBreaker) current() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
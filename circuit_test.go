@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// attemptCounter counts the sends that reach a backend
+type attemptCounter struct {
+	*fakeBackend
+	attempts int
+}
+
+func (b *attemptCounter) send(payload *libratoPayload) error {
+	b.attempts++
+	return b.fakeBackend.send(payload)
+}
+
+func TestCircuitOpensAndProbes(t *testing.T) {
+	useConfig(t, `{"Librato": {"FailureThreshold": 3, "CircuitCooldownSeconds": 60}}`)
+	fake := &attemptCounter{fakeBackend: &fakeBackend{err: errors.New("librato is down")}}
+	breaker := newCircuitBreaker(fake)
+	breaker.cooldown = 50 * time.Millisecond
+	payload := newLibratoPayload()
+	for i := 0; i < 3; i++ {
+		breaker.send(payload)
+	}
+	if breaker.current() != circuitOpen {
+		t.Fatalf("Expected the circuit to open after 3 failures, got state %d", breaker.current())
+	}
+	if err := breaker.send(payload); err != errCircuitOpen || fake.attempts != 3 {
+		t.Errorf("Expected the open circuit to skip sending, got %v after %d attempts", err, fake.attempts)
+	}
+	// a failed probe opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	breaker.send(payload)
+	if fake.attempts != 4 || breaker.current() != circuitOpen {
+		t.Errorf("Expected a single probe that reopened the circuit, got %d attempts and state %d", fake.attempts, breaker.current())
+	}
+	// and one that works closes it
+	time.Sleep(60 * time.Millisecond)
+	fake.err = nil
+	if err := breaker.send(payload); err != nil || breaker.current() != circuitClosed {
+		t.Errorf("Expected a successful probe to close the circuit, got %v and state %d", err, breaker.current())
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// clockSkew keeps track of how far the local clock is from Librato's, going
+// by the Date header of its responses. a clock that is off scatters metrics
+// across the wrong measure times, which is hard to spot from the dashboards.
+// nothing is sent to Librato just to measure it, so the skew is only known
+// once a payload has gone to a Librato backend, and never without one.
+type clockSkew struct {
+	mu     sync.Mutex
+	skew   float64 // seconds the local clock is ahead by
+	known  bool
+	warned bool
+}
+
+var libratoClock = &clockSkew{}
+
+// observe works out the skew from a response to a request sent at start.
+// the local time used is halfway through the request, and the Date header
+// only has whole seconds, so this is good to about a second. a warning is
+// logged when the skew goes past conf.TimeSkewWarnSeconds, and again when it
+// comes back.
+func (c *clockSkew) observe(resp *http.Response, start time.Time) {
+	if conf.TimeSkewWarnSeconds <= 0 {
+		return
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	end := time.Now()
+	local := start.Add(end.Sub(start) / 2)
+	skew := local.Sub(date).Seconds()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.skew, c.known = skew, true
+	beyond := math.Abs(skew) > float64(conf.TimeSkewWarnSeconds)
+	if beyond && !c.warned {
+		fmt.Printf("Warning: the local clock is %.1f seconds off from Librato's\n", skew)
+	} else if !beyond && c.warned {
+		fmt.Printf("The local clock is back within %d seconds of Librato's\n", conf.TimeSkewWarnSeconds)
+	}
+	c.warned = beyond
+}
+
+// current returns the last skew seen, if there has been one
+func (c *clockSkew) current() (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skew, c.known
+}
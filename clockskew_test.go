@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dated returns a response whose Date header is offset from now
+func dated(offset time.Duration) *http.Response {
+	resp := &http.Response{Header: make(http.Header)}
+	resp.Header.Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+	return resp
+}
+
+func TestSkewedDateHeaderWarns(t *testing.T) {
+	useConfig(t, `{"TimeSkewWarnSeconds": 30}`)
+	skew := &clockSkew{}
+	output := captureOutput(t, func() {
+		skew.observe(dated(-2*time.Minute), time.Now())
+	})
+	if !strings.Contains(output, "Warning: the local clock is") {
+		t.Errorf("Expected a warning about the skew, got %q", output)
+	}
+	seconds, ok := skew.current()
+	if !ok || math.Abs(seconds-120) > 2 {
+		t.Errorf("Expected the local clock to be about 120 seconds ahead, got %v", seconds)
+	}
+	output = captureOutput(t, func() {
+		skew.observe(dated(-2*time.Minute), time.Now())
+	})
+	if output != "" {
+		t.Errorf("Expected the warning only once, got %q", output)
+	}
+	output = captureOutput(t, func() {
+		skew.observe(dated(0), time.Now())
+	})
+	if !strings.Contains(output, "back within 30 seconds") {
+		t.Errorf("Expected a note that the clock is back, got %q", output)
+	}
+}
+
+func TestSkewIsOnlyMeasuredFromLibrato(t *testing.T) {
+	old := libratoClock
+	defer func() { libratoClock = old }()
+	libratoClock = &clockSkew{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-2*time.Minute).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	useConfig(t, fmt.Sprintf(`{"TimeSkewWarnSeconds": 30, "Dogstatsd": {"Addr": %q}}`, listener.LocalAddr().String()))
+	payload := newLibratoPayload()
+	payload.addMetric(testGauge("load", 1))
+	// sending to DogStatsD says nothing about the clock
+	if err := backends[""].send(payload); err != nil {
+		t.Fatal(err)
+	}
+	reading, err := new(selfCollector).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gaugeValues(reading)["clock-skew-seconds"]; ok {
+		t.Error("Expected no clock skew without a response from Librato")
+	}
+	captureOutput(t, func() {
+		if _, _, err := postPayload(newLibratoBackend("librato", server.URL, "e", "t"), payload); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if skew, ok := libratoClock.current(); !ok || math.Abs(skew-120) > 2 {
+		t.Errorf("Expected the local clock to be about 120 seconds ahead of Librato's, got %v", skew)
+	}
+}
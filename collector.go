@@ -0,0 +1,201 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// a collector periodically reads some piece of system state and turns it
+// into metrics
+type collector interface {
+	// name identifies the collector in log messages
+	name() string
+	// period is how long to wait between collections
+	period() time.Duration
+	// backend is the name in conf.Backends that the collector's metrics
+	// go to, or empty for the primary backend
+	backend() string
+	// source says where the collector's readings come from
+	source() string
+	// collect reads the current state and returns the metrics it produced.
+	// collectors that report differences between readings, see differencer,
+	// can't report those on their first call.
+	collect() ([]interface{}, error)
+	// describe returns the names of the metrics the collector emits, with
+	// placeholders such as <N> for the parts that depend on the host
+	describe() []string
+}
+
+// a differencer is a collector that reports differences between readings, so
+// that its first reading mostly just gives it something to compare against.
+// one-shot runs and conf.WarmupImmediate take a second reading soon after the
+// first for these. what else the first reading produces doesn't matter, since
+// a cpu collector emitting cpu-count still needs a second reading for usage.
+type differencer interface {
+	differences() bool
+}
+
+// needsSecondReading reports whether c is a differencer
+func needsSecondReading(c collector) bool {
+	d, ok := c.(differencer)
+	return ok && d.differences()
+}
+
+// a stopper is a collector or backend with something to do before grotto exits
+type stopper interface {
+	stop()
+}
+
+// enabledCollectors returns a collector for each section of the config that
+// is turned on
+func enabledCollectors() []collector {
+	var collectors []collector
+	if !conf.Cpu.Disabled {
+		collectors = append(collectors, newPlatformCpuCollector())
+	}
+	if conf.Memory.PeriodSeconds > 0 {
+		collectors = append(collectors, new(memoryCollector))
+		if conf.Memory.SwapDevices {
+			collectors = append(collectors, new(swapCollector))
+		}
+	}
+	if conf.Procs.PeriodSeconds > 0 {
+		collectors = append(collectors, new(procsCollector))
+	}
+	if conf.Inotify.PeriodSeconds > 0 {
+		collectors = append(collectors, new(inotifyCollector))
+	}
+	if conf.KernelThreads.PeriodSeconds > 0 {
+		collectors = append(collectors, new(kthreadsCollector))
+	}
+	if conf.Release.PeriodSeconds > 0 {
+		collectors = append(collectors, new(releaseCollector))
+	}
+	if conf.SchedStat.PeriodSeconds > 0 {
+		collectors = append(collectors, new(schedstatCollector))
+	}
+	if conf.SockStat.PeriodSeconds > 0 {
+		collectors = append(collectors, new(sockstatCollector))
+	}
+	if conf.Disk.PeriodSeconds > 0 {
+		collectors = append(collectors, newDiskCollector())
+	}
+	if conf.BlockQueue.PeriodSeconds > 0 {
+		collectors = append(collectors, newBlockQueueCollector())
+	}
+	if conf.DiskStats.PeriodSeconds > 0 {
+		collectors = append(collectors, newDiskstatsCollector())
+	}
+	if conf.Gpu.PeriodSeconds > 0 {
+		collectors = append(collectors, new(gpuCollector))
+	}
+	if conf.HugePages.PeriodSeconds > 0 {
+		collectors = append(collectors, new(hugepagesCollector))
+	}
+	if conf.Numa.PeriodSeconds > 0 {
+		collectors = append(collectors, new(numaCollector))
+	}
+	if conf.Softirqs.PeriodSeconds > 0 {
+		collectors = append(collectors, new(softirqsCollector))
+	}
+	if conf.Vmstat.PeriodSeconds > 0 {
+		collectors = append(collectors, new(vmstatCollector))
+	}
+	if conf.CoreTemp.PeriodSeconds > 0 {
+		collectors = append(collectors, new(coretempCollector))
+	}
+	if conf.Battery.PeriodSeconds > 0 {
+		collectors = append(collectors, new(batteryCollector))
+	}
+	if conf.Kmsg.PeriodSeconds > 0 {
+		collectors = append(collectors, new(kmsgCollector))
+	}
+	if conf.CgroupCpu.PeriodSeconds > 0 {
+		collectors = append(collectors, new(cgroupCpuCollector))
+	}
+	if conf.NetStat.PeriodSeconds > 0 {
+		collectors = append(collectors, new(netstatCollector))
+	}
+	if conf.Connections.PeriodSeconds > 0 {
+		collectors = append(collectors, new(connectionsCollector))
+	}
+	if conf.Conntrack.PeriodSeconds > 0 {
+		collectors = append(collectors, new(conntrackCollector))
+	}
+	if len(conf.PortMonitors) > 0 {
+		collectors = append(collectors, new(portsCollector))
+	}
+	for _, stats := range conf.UnixStats {
+		collectors = append(collectors, &unixStatsCollector{conf: stats})
+	}
+	if conf.Librato.FailureThreshold > 0 || conf.Librato.MinSuccessRate > 0 || conf.TimeSkewWarnSeconds > 0 || conf.CollectorLiveness {
+		collectors = append(collectors, new(selfCollector))
+	}
+	for _, monitor := range conf.LogMonitors {
+		collectors = append(collectors, newLogMonitorCollector(monitor))
+	}
+	if len(conf.StaticGauges) > 0 {
+		collectors = append(collectors, new(staticCollector))
+	}
+	return collectors
+}
+
+// how long to wait after a collector's first reading when conf.WarmupImmediate
+// is set, rather than a whole period. a variable so that tests can shorten it
+var warmupInterval = time.Second
+
+// the longest a failing collector will wait between attempts
+const maxBackoff = 5 * time.Minute
+
+// permanentError reports whether a collector's error means it will never
+// work on this host, rather than it having been unlucky this time
+func permanentError(err error) bool {
+	return err != nil && (os.IsPermission(err) || os.IsNotExist(err))
+}
+
+// backoff returns how long to wait after the given number of consecutive
+// failures: the period after the first, doubling with each one after that
+func backoff(period time.Duration, failures int) time.Duration {
+	sleep := period
+	for i := 1; i < failures && sleep < maxBackoff; i++ {
+		sleep *= 2
+	}
+	if sleep > maxBackoff && period < maxBackoff {
+		sleep = maxBackoff
+	}
+	return sleep
+}
+
+// seconds converts a config value in seconds to a time.Duration
+func seconds(n flexInt) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// counterRates turns readings of cumulative counters into per-second rates
+// over the time between readings
+type counterRates struct {
+	previous     map[string]int64
+	previousTime time.Time
+}
+
+// update records the current counter values and returns the rate of each
+// since the last update. the first update returns nothing since there is
+// nothing to compare against, and counters that went backwards (because they
+// wrapped or were reset) are left out.
+func (r *counterRates) update(current map[string]int64, now time.Time) map[string]float64 {
+	previous, previousTime := r.previous, r.previousTime
+	r.previous, r.previousTime = current, now
+	elapsed := now.Sub(previousTime).Seconds()
+	if previous == nil || elapsed <= 0 {
+		return nil
+	}
+	rates := make(map[string]float64)
+	for key, value := range current {
+		last, ok := previous[key]
+		if !ok || value < last {
+			continue
+		}
+		rates[key] = float64(value-last) / elapsed
+	}
+	return rates
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWarmupImmediate(t *testing.T) {
+	old := warmupInterval
+	warmupInterval = 10 * time.Millisecond
+	defer func() { warmupInterval = old }()
+	for _, warmup := range []bool{false, true} {
+		c := useConfig(t, `{}`)
+		c.WarmupImmediate = warmup
+		// a first reading that isn't empty mustn't stop the warmup
+		diffs := &fakeCollector{label: "diffs", every: time.Hour, diffs: true, read: func(n int) ([]interface{}, error) {
+			return []interface{}{testGauge("count", 1)}, nil
+		}}
+		metrics := make(chan interface{}, 10)
+		sched := startCollectors([]collector{diffs}, metrics)
+		time.Sleep(200 * time.Millisecond)
+		sched.stop()
+		expected := 1
+		if warmup {
+			expected = 2
+		}
+		if diffs.count() != expected {
+			t.Errorf("With WarmupImmediate %v expected %d readings, got %d", warmup, expected, diffs.count())
+		}
+	}
+}
+
+func TestStopCancelsWarmup(t *testing.T) {
+	old := warmupInterval
+	warmupInterval = 100 * time.Millisecond
+	defer func() { warmupInterval = old }()
+	c := useConfig(t, `{}`)
+	c.WarmupImmediate = true
+	diffs := &fakeCollector{label: "diffs", every: time.Hour, diffs: true, read: func(n int) ([]interface{}, error) {
+		return nil, nil
+	}}
+	sched := startCollectors([]collector{diffs}, make(chan interface{}, 10))
+	waitFor(t, 5*time.Second, func() bool { return diffs.count() == 1 })
+	sched.stop()
+	time.Sleep(2 * warmupInterval)
+	if diffs.count() != 1 {
+		t.Errorf("Expected the warmup reading to be cancelled by stopping, got %d readings", diffs.count())
+	}
+}
+
+func TestBackoffGrowsAndResets(t *testing.T) {
+	for _, test := range []struct {
+		failures int
+		sleep    time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{100, maxBackoff},
+	} {
+		if sleep := backoff(time.Second, test.failures); sleep != test.sleep {
+			t.Errorf("After %d failures expected to wait %s, got %s", test.failures, test.sleep, sleep)
+		}
+	}
+	// a period longer than the limit is left alone
+	if sleep := backoff(time.Hour, 3); sleep != time.Hour {
+		t.Errorf("Expected an hour long period to stay an hour, got %s", sleep)
+	}
+}
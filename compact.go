@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// compacted reports whether the collector with the given name is in
+// conf.CompactEmit
+func compacted(name string) bool {
+	for _, compact := range conf.CompactEmit {
+		if compact == name {
+			return true
+		}
+	}
+	return false
+}
+
+// compactMetrics packs the metrics from one reading into a single
+// <collector>-compact gauge for when bytes on the wire matter more than
+// being able to graph each metric. its value is how many metrics it holds
+// and its description is a JSON object of their names and values. the
+// measure time and source are taken from the first metric.
+func compactMetrics(name string, metrics []interface{}) gauge {
+	compact := gauge{Name: fmt.Sprintf("%s-compact", name), Value: float64(len(metrics))}
+	values := make(map[string]interface{}, len(metrics))
+	for i, metric := range metrics {
+		switch m := metric.(type) {
+		case gauge:
+			// these can't be encoded, and sanitize would have dropped them
+			if !math.IsNaN(m.Value) && !math.IsInf(m.Value, 0) {
+				values[m.Name] = m.Value
+			}
+			if i == 0 {
+				compact.MeasureTime, compact.Source = m.MeasureTime, m.Source
+			}
+		case counter:
+			values[m.Name] = m.Value
+			if i == 0 {
+				compact.MeasureTime, compact.Source = m.MeasureTime, m.Source
+			}
+		}
+	}
+	if data, err := json.Marshal(values); err == nil {
+		compact.Description = string(data)
+	}
+	return compact
+}
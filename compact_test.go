@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCompactEmit(t *testing.T) {
+	c := useConfig(t, `{"CompactEmit": ["fake"]}`)
+	reading := []interface{}{testGauge("load-1", 0.5), testGauge("load-5", math.NaN()), counter{Name: "ctxt", Value: 42, Source: "test"}}
+	out := c.transforms.run(&fakeCollector{label: "fake"}, reading)
+	if len(out) != 1 {
+		t.Fatalf("Expected a single compact gauge, got %v", out)
+	}
+	compact, ok := out[0].(gauge)
+	if !ok || compact.Name != "fake-compact" || compact.Value != 3 || compact.Source != "test" || compact.MeasureTime != 1 {
+		t.Fatalf("Unexpected compact gauge %+v", out[0])
+	}
+	var values map[string]float64
+	if err := json.Unmarshal([]byte(compact.Description), &values); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]float64{"load-1": 0.5, "ctxt": 42}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected a description of %v, got %v", expected, values)
+	}
+	// collectors that aren't named are left alone
+	if out := c.transforms.run(&fakeCollector{label: "other"}, reading[:1]); len(out) != 1 || out[0].(gauge).Name != "load-1" {
+		t.Errorf("Expected the other collector's reading untouched, got %v", out)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// readConfigValues reads a single config file into generic JSON values so
+// that it can be merged with others. a loc of "-" reads from stdin.
+func readConfigValues(loc string) (map[string]interface{}, error) {
+	var contents []byte
+	var err error
+	if loc == "-" {
+		contents, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		contents, err = ioutil.ReadFile(loc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	// keep numbers exactly as written rather than going through float64
+	decoder.UseNumber()
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("%s: %s", loc, err)
+	}
+	return values, nil
+}
+
+// mergeConfigValues merges src into dst. objects are merged key by key,
+// matching keys case-insensitively the same way the config struct does, and
+// anything else in src replaces what is in dst unless it is a zero value
+// such as 0, false, "", [] or null.
+func mergeConfigValues(dst, src map[string]interface{}) {
+	for key, value := range src {
+		for existing := range dst {
+			if strings.EqualFold(existing, key) {
+				key = existing
+				break
+			}
+		}
+		if srcValues, ok := value.(map[string]interface{}); ok {
+			if dstValues, ok := dst[key].(map[string]interface{}); ok {
+				mergeConfigValues(dstValues, srcValues)
+				continue
+			}
+		}
+		if isZeroConfigValue(value) {
+			if _, ok := dst[key]; ok {
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+func isZeroConfigValue(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !value
+	case string:
+		return value == ""
+	case json.Number:
+		f, err := value.Float64()
+		return err == nil && f == 0
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	}
+	return false
+}
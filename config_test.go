@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigFromStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(`{"Librato": {"Email": "e", "Token": "t", "Url": "http://librato", "PeriodSeconds": 30}}`)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	c, err := readConfig("-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Librato.Email != "e" || c.Librato.Token != "t" || c.Librato.PeriodSeconds != 30 {
+		t.Errorf("Unexpected config from stdin %+v", c.Librato)
+	}
+}
+
+// configOptions returns the exported fields of t, and of the structs in it, as
+// dotted paths. the fields of structs in maps and slices are under the name
+// of the map or slice followed by [].
+func configOptions(t reflect.Type, prefix string) []string {
+	var options []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		options = append(options, prefix+field.Name)
+		ft := field.Type
+		switch ft.Kind() {
+		case reflect.Struct:
+			options = append(options, configOptions(ft, prefix+field.Name+".")...)
+		case reflect.Map, reflect.Slice:
+			if elem := ft.Elem(); elem.Kind() == reflect.Ptr && elem.Elem().Kind() == reflect.Struct {
+				options = append(options, configOptions(elem.Elem(), prefix+field.Name+"[].")...)
+			}
+		}
+	}
+	return options
+}
+
+func TestExampleConfigHasEveryOption(t *testing.T) {
+	c, err := readConfig("grotto.conf.example")
+	if err != nil {
+		t.Fatalf("Could not read the example config: %s", err)
+	}
+	if c.Librato.PeriodSeconds != 5 {
+		t.Errorf("Expected the example to have the defaults, got %+v", c.Librato)
+	}
+	data, err := ioutil.ReadFile("grotto.conf.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var example map[string]interface{}
+	if err := json.Unmarshal(data, &example); err != nil {
+		t.Fatal(err)
+	}
+	readme, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, option := range configOptions(reflect.TypeOf(config{}), "") {
+		path := strings.Split(option, ".")
+		if !strings.Contains(string(readme), "`"+path[len(path)-1]+"`") {
+			t.Errorf("%s is not documented in README.md", option)
+		}
+		if strings.Contains(option, "[]") {
+			continue
+		}
+		section := example
+		for i, name := range path {
+			value, ok := section[name]
+			if !ok {
+				t.Errorf("%s is missing from grotto.conf.example", option)
+				break
+			}
+			if i < len(path)-1 {
+				section = value.(map[string]interface{})
+			}
+		}
+	}
+}
+
+func TestPeriodsAsStrings(t *testing.T) {
+	var periods struct {
+		PeriodSeconds flexInt
+		Other         flexInt
+	}
+	if err := json.Unmarshal([]byte(`{"PeriodSeconds": "5", "Other": 7}`), &periods); err != nil {
+		t.Fatal(err)
+	}
+	if periods.PeriodSeconds != 5 || periods.Other != 7 {
+		t.Errorf("Expected 5 and 7, got %d and %d", periods.PeriodSeconds, periods.Other)
+	}
+	if err := json.Unmarshal([]byte(`{"PeriodSeconds": "five"}`), &periods); err == nil {
+		t.Error("Expected an error for a period that isn't a number")
+	}
+	c := useConfig(t, `{"Cpu": {"PeriodSeconds": "5"}, "Librato": {"PeriodSeconds": "0"}}`)
+	if c.Cpu.PeriodSeconds != 5 {
+		t.Errorf("Expected conf.Cpu.PeriodSeconds of 5, got %d", c.Cpu.PeriodSeconds)
+	}
+	if c.Librato.PeriodSeconds != 5 {
+		t.Errorf("Expected a zero conf.Librato.PeriodSeconds to get the default, got %d", c.Librato.PeriodSeconds)
+	}
+}
+
+func TestConfigFilesAreMerged(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.conf")
+	override := filepath.Join(dir, "host.conf")
+	writeFiles(t, dir, map[string]string{
+		"base.conf": `{"Librato": {"Email": "e", "Token": "t", "Url": "http://librato", "PeriodSeconds": 10},
+			"Cpu": {"PeriodSeconds": 2, "PerCoreGauges": true}, "Filter": {"Exclude": ["-nice$"]}}`,
+		// zero values leave what the base said alone
+		"host.conf": `{"librato": {"Token": "host-token", "PeriodSeconds": 0}, "Cpu": {"PerCoreGauges": false, "EmitSummary": true},
+			"Filter": {"Exclude": []}, "LogLevel": "debug"}`,
+	})
+	c, err := readConfig(base + "," + override)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Librato.Email != "e" || c.Librato.Token != "host-token" || c.Librato.PeriodSeconds != 10 {
+		t.Errorf("Unexpected merged Librato config %+v", c.Librato)
+	}
+	if c.Cpu.PeriodSeconds != 2 || !c.Cpu.PerCoreGauges || !c.Cpu.EmitSummary {
+		t.Errorf("Unexpected merged Cpu config %+v", c.Cpu)
+	}
+	if !reflect.DeepEqual(c.Filter.Exclude, []string{"-nice$"}) || c.LogLevel != "debug" {
+		t.Errorf("Unexpected merged config %+v", c)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the most reverse lookups kept by remoteNames before it starts over, and how
+// long each is trusted for
+const (
+	maxResolvedNames  = 1024
+	resolvedNameTTL   = 10 * time.Minute
+	resolveLookupTime = time.Second
+)
+
+// connectionsCollector counts the established connections to each remote
+// host and reports the conf.Connections.TopN busiest as
+// connections-to-<address>, to show which hosts this one depends on most.
+// connections over loopback are left out. with conf.Connections.Resolve the
+// hosts are named by reverse lookup where that works.
+type connectionsCollector struct {
+	names remoteNames
+}
+
+func (c *connectionsCollector) name() string {
+	return "connections"
+}
+
+func (c *connectionsCollector) period() time.Duration {
+	return seconds(conf.Connections.PeriodSeconds)
+}
+
+func (c *connectionsCollector) backend() string {
+	return conf.Connections.Backend
+}
+
+func (c *connectionsCollector) source() string {
+	return "/proc/net/tcp and /proc/net/tcp6"
+}
+
+func (c *connectionsCollector) collect() ([]interface{}, error) {
+	sockets, err := readTcpSockets()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, socket := range sockets {
+		if socket.state != tcpEstablished || socket.remoteAddr.IsLoopback() {
+			continue
+		}
+		// ipv4 connections on ipv6 sockets, which are ::ffff:a.b.c.d, come
+		// out as a.b.c.d here and are counted along with the rest
+		counts[socket.remoteAddr.String()]++
+	}
+	remotes := topRemotes(counts, conf.Connections.TopN)
+	epoch := measureTime(time.Now())
+	var metrics []interface{}
+	for _, remote := range remotes {
+		name := remote
+		if conf.Connections.Resolve {
+			name = c.names.lookup(remote)
+		}
+		metrics = append(metrics, gauge{Name: "connections-to-" + name, MeasureTime: epoch, Value: float64(counts[remote]), Source: hostname})
+	}
+	return metrics, nil
+}
+
+func (c *connectionsCollector) describe() []string {
+	return []string{"connections-to-<address>"}
+}
+
+// topRemotes returns up to n of the remotes with the most connections, most
+// first, with ties broken by address so the same ones are picked each time
+func topRemotes(counts map[string]int, n int) []string {
+	remotes := make([]string, 0, len(counts))
+	for remote := range counts {
+		remotes = append(remotes, remote)
+	}
+	sort.Slice(remotes, func(i, j int) bool {
+		if counts[remotes[i]] != counts[remotes[j]] {
+			return counts[remotes[i]] > counts[remotes[j]]
+		}
+		return remotes[i] < remotes[j]
+	})
+	if len(remotes) > n {
+		remotes = remotes[:n]
+	}
+	return remotes
+}
+
+// remoteNames caches reverse lookups of remote addresses. an address that
+// doesn't resolve keeps its address as its name. the cache is emptied when it
+// gets to maxResolvedNames, which keeps it bounded on hosts that talk to a
+// lot of others.
+type remoteNames struct {
+	mu    sync.Mutex
+	names map[string]resolvedName
+}
+
+type resolvedName struct {
+	name    string
+	expires time.Time
+}
+
+func (r *remoteNames) lookup(addr string) string {
+	now := time.Now()
+	r.mu.Lock()
+	if resolved, ok := r.names[addr]; ok && now.Before(resolved.expires) {
+		r.mu.Unlock()
+		return resolved.name
+	}
+	r.mu.Unlock()
+	name := addr
+	ctx, cancel := context.WithTimeout(context.Background(), resolveLookupTime)
+	defer cancel()
+	if hosts, err := net.DefaultResolver.LookupAddr(ctx, addr); err == nil && len(hosts) > 0 {
+		name = strings.TrimSuffix(hosts[0], ".")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names == nil || len(r.names) >= maxResolvedNames {
+		r.names = make(map[string]resolvedName)
+	}
+	r.names[addr] = resolvedName{name: name, expires: now.Add(resolvedNameTTL)}
+	return name
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTopRemotes(t *testing.T) {
+	root := useFakeProc(t)
+	writeFiles(t, filepath.Join(root, "net"), map[string]string{
+		"tcp": tcpHeader +
+			tcpLine("00000000:01BB", "00000000:0000", "0A", "100") +
+			tcpLine("0100000A:C350", "0200000A:01BB", "01", "101") +
+			tcpLine("0100000A:C351", "0200000A:01BB", "01", "102") +
+			tcpLine("0100000A:C352", "0200000A:0050", "01", "103") +
+			tcpLine("0100000A:C353", "0300000A:01BB", "01", "104") +
+			tcpLine("0100000A:C354", "0400000A:01BB", "01", "105") +
+			tcpLine("0100000A:C355", "0400000A:01BB", "01", "106") +
+			// closing connections and those over loopback are left out
+			tcpLine("0100000A:C356", "0300000A:01BB", "06", "107") +
+			tcpLine("0100007F:C357", "0100007F:01BB", "01", "108") +
+			tcpLine("0100007F:C358", "0100007F:01BB", "01", "109") +
+			tcpLine("0100007F:C359", "0100007F:01BB", "01", "110") +
+			tcpLine("0100007F:C35A", "0100007F:01BB", "01", "111"),
+		"tcp6": tcpHeader,
+	})
+	useConfig(t, `{"Connections": {"TopN": 2}}`)
+	metrics, err := new(connectionsCollector).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{"connections-to-10.0.0.2": 3, "connections-to-10.0.0.4": 2}
+	if values := gaugeValues(metrics); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+	// ties go to the lowest address so the same remotes are picked each time
+	if top := topRemotes(map[string]int{"10.0.0.9": 1, "10.0.0.1": 1, "10.0.0.5": 1}, 2); !reflect.DeepEqual(top, []string{"10.0.0.1", "10.0.0.5"}) {
+		t.Errorf("Expected ties broken by address, got %v", top)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// conntrackCollector reports how full the netfilter connection tracking table
+// is. once it fills up new connections are dropped without a word, which on a
+// NAT gateway looks like the network going flaky. hosts without conntrack
+// loaded report nothing, and start reporting if it is loaded later.
+type conntrackCollector struct{}
+
+func (c *conntrackCollector) name() string {
+	return "conntrack"
+}
+
+func (c *conntrackCollector) period() time.Duration {
+	return seconds(conf.Conntrack.PeriodSeconds)
+}
+
+func (c *conntrackCollector) backend() string {
+	return conf.Conntrack.Backend
+}
+
+func (c *conntrackCollector) source() string {
+	return filepath.Join(conf.Conntrack.Dir, "nf_conntrack_count")
+}
+
+func (c *conntrackCollector) collect() ([]interface{}, error) {
+	used, err := readInt64File(filepath.Join(conf.Conntrack.Dir, "nf_conntrack_count"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	limit, err := readInt64File(filepath.Join(conf.Conntrack.Dir, "nf_conntrack_max"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	epoch := measureTime(time.Now())
+	newGauge := func(name string, value float64) gauge {
+		return gauge{Name: name, MeasureTime: epoch, Value: value, Source: hostname}
+	}
+	metrics := []interface{}{
+		newGauge("conntrack-used", float64(used)),
+		newGauge("conntrack-max", float64(limit)),
+	}
+	if limit > 0 {
+		metrics = append(metrics, newGauge("conntrack-used-percent", float64(used)/float64(limit)))
+	}
+	return metrics, nil
+}
+
+func (c *conntrackCollector) describe() []string {
+	return []string{"conntrack-used", "conntrack-max", "conntrack-used-percent"}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConntrackUsage(t *testing.T) {
+	dir := t.TempDir()
+	useConfig(t, `{"Conntrack": {"Dir": "`+dir+`"}}`)
+	c := &conntrackCollector{}
+	// without conntrack loaded there is nothing to report
+	if metrics, err := c.collect(); err != nil || len(metrics) != 0 {
+		t.Fatalf("Expected nothing without conntrack, got %v, %v", metrics, err)
+	}
+	writeFiles(t, dir, map[string]string{"nf_conntrack_count": "16384\n", "nf_conntrack_max": "65536\n"})
+	metrics, err := c.collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{"conntrack-used": 16384, "conntrack-max": 65536, "conntrack-used-percent": .25}
+	if values := gaugeValues(metrics); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+}
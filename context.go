@@ -0,0 +1,22 @@
+package main
+
+import "strings"
+
+// emitContext is what a collector knows about where a metric came from beyond
+// the host, such as the mount point of a disk. it is merged with the source
+// when the metric goes out, see expandMetric, so that each mount or device
+// can be its own series rather than being baked into the metric name.
+type emitContext struct {
+	// joined to the source with a colon, as in myhost:/var
+	qualifier string
+	// added to the tags of a measurement when conf.Librato.ApiVersion is "tags"
+	tags map[string]string
+}
+
+// qualify returns source with the context's qualifier joined to it
+func (c *emitContext) qualify(source string) string {
+	if c == nil || c.qualifier == "" {
+		return source
+	}
+	return strings.Join([]string{source, c.qualifier}, ":")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// coretempCollector reports the temperature of each cpu core from the
+// coretemp hwmon driver, named after the sensor labels, so "Core 0" becomes
+// coretemp-core0-celsius. with more than one socket the cores are named after
+// their package as well, as in coretemp-package1-core0-celsius. hosts without
+// coretemp report nothing.
+type coretempCollector struct{}
+
+func (c *coretempCollector) name() string {
+	return "coretemp"
+}
+
+func (c *coretempCollector) period() time.Duration {
+	return seconds(conf.CoreTemp.PeriodSeconds)
+}
+
+func (c *coretempCollector) backend() string {
+	return conf.CoreTemp.Backend
+}
+
+func (c *coretempCollector) source() string {
+	return filepath.Join(conf.CoreTemp.SysfsRoot, "class/hwmon/hwmon*/temp*_input")
+}
+
+func (c *coretempCollector) collect() ([]interface{}, error) {
+	devices, err := coretempDevices(conf.CoreTemp.SysfsRoot)
+	if err != nil {
+		return nil, err
+	}
+	epoch := measureTime(time.Now())
+	var metrics []interface{}
+	for _, dir := range devices {
+		temps, err := readCoretemps(dir)
+		if err != nil {
+			return nil, err
+		}
+		socket := ""
+		if len(devices) > 1 {
+			// each socket has its own device with its own Core 0, Core 1...
+			socket = coretempPackage(dir, temps) + "-"
+		}
+		for label, celsius := range temps {
+			name := "coretemp-" + socket + label + "-celsius"
+			if strings.HasPrefix(label, "package") {
+				name = "coretemp-" + label + "-celsius"
+			}
+			metrics = append(metrics, gauge{
+				Name:        name,
+				MeasureTime: epoch,
+				Value:       celsius,
+				Source:      hostname,
+			})
+		}
+	}
+	return metrics, nil
+}
+
+func (c *coretempCollector) describe() []string {
+	return []string{"coretemp-core<N>-celsius", "coretemp-package<N>-core<N>-celsius", "coretemp-package<N>-celsius"}
+}
+
+// coretempPackage names the socket a coretemp device is for after the
+// "Package id N" sensor among its temps. hwmon devices are numbered in the
+// order they were found, which can change between boots, so their directory
+// is only used when there is no package sensor.
+func coretempPackage(dir string, temps map[string]float64) string {
+	for label := range temps {
+		if strings.HasPrefix(label, "package") {
+			return label
+		}
+	}
+	return filepath.Base(dir)
+}
+
+// coretempDevices returns the hwmon directories under sysfsRoot that belong to
+// the coretemp driver, sorted by name
+func coretempDevices(sysfsRoot string) ([]string, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysfsRoot, "class/hwmon/hwmon*"))
+	if err != nil {
+		return nil, err
+	}
+	var devices []string
+	for _, dir := range dirs {
+		name, err := readFileString(filepath.Join(dir, "name"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if name == "coretemp" {
+			devices = append(devices, dir)
+		}
+	}
+	sort.Strings(devices)
+	return devices, nil
+}
+
+// readCoretemps reads each temp<N>_input in a hwmon directory, which is in
+// millidegrees celsius, keyed by its temp<N>_label. labels like "Core 0" and
+// "Package id 0" become core0 and package0, and inputs without a label are
+// keyed by temp<N>.
+func readCoretemps(dir string) (map[string]float64, error) {
+	inputs, err := filepath.Glob(filepath.Join(dir, "temp*_input"))
+	if err != nil {
+		return nil, err
+	}
+	temps := make(map[string]float64)
+	for _, input := range inputs {
+		base := strings.TrimSuffix(input, "_input")
+		millidegrees, err := readInt64File(input)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read %s: %s", input, err)
+		}
+		label := filepath.Base(base)
+		if text, err := readFileString(base + "_label"); err == nil {
+			var words []string
+			for _, word := range strings.Fields(strings.ToLower(text)) {
+				if word != "id" {
+					words = append(words, word)
+				}
+			}
+			label = strings.Join(words, "")
+		}
+		temps[label] = float64(millidegrees) / 1000
+	}
+	return temps, nil
+}
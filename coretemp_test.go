@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeCoretemp writes a coretemp hwmon device under root with the given
+// labels and millidegrees
+func writeCoretemp(t *testing.T, root, hwmon string, temps map[string]string) {
+	t.Helper()
+	dir := filepath.Join(root, "class/hwmon", hwmon)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"name": "coretemp\n"}
+	i := 1
+	for label, value := range temps {
+		files["temp"+strconv.Itoa(i)+"_label"] = label + "\n"
+		files["temp"+strconv.Itoa(i)+"_input"] = value + "\n"
+		i++
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCoretempNamesSocketsByPackage(t *testing.T) {
+	root := t.TempDir()
+	// hwmon numbers don't follow the packages
+	writeCoretemp(t, root, "hwmon2", map[string]string{"Package id 1": "51000", "Core 0": "49000"})
+	writeCoretemp(t, root, "hwmon4", map[string]string{"Package id 0": "45000", "Core 0": "43500"})
+	useConfig(t, `{"CoreTemp": {"SysfsRoot": "`+root+`"}}`)
+	metrics, err := new(coretempCollector).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	var names []string
+	for _, metric := range metrics {
+		g := metric.(gauge)
+		values[g.Name] = g.Value
+		names = append(names, g.Name)
+	}
+	sort.Strings(names)
+	expected := map[string]float64{
+		"coretemp-package0-celsius":       45,
+		"coretemp-package0-core0-celsius": 43.5,
+		"coretemp-package1-celsius":       51,
+		"coretemp-package1-core0-celsius": 49,
+	}
+	if len(values) != len(expected) {
+		t.Fatalf("Expected %d gauges, got %s", len(expected), strings.Join(names, ", "))
+	}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, values[name])
+		}
+	}
+}
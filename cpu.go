@@ -1,10 +1,11 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
+	"math"
 	"os"
-	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,6 +16,7 @@ type cpuStat struct {
 	nice   int
 	system int
 	idle   int
+	iowait int
 	total  int
 	epoch  int64
 }
@@ -27,33 +29,77 @@ func (s *cpuStat) usagePercentage() float64 {
 	return s.percentage(s.user + s.nice + s.system)
 }
 
-func (s *cpuStat) userPercentage() float64 {
-	return s.percentage(s.user)
+// metricName names a metric for the cpu. the aggregate cpu line is named
+// cpu-total so that its metrics can't be mistaken for, or summed along with,
+// those of the cores.
+func (s *cpuStat) metricName(suffix string) string {
+	if s.name == "cpu" {
+		return "cpu-total-" + suffix
+	}
+	return s.name + "-" + suffix
 }
 
-func (s *cpuStat) nicePercentage() float64 {
-	return s.percentage(s.nice)
+// isCpuCore reports whether a /proc/stat line name is for a single core, like
+// cpu0, rather than the aggregate cpu line
+func isCpuCore(name string) bool {
+	if len(name) <= 3 {
+		return false
+	}
+	for _, r := range name[3:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
 }
 
-func (s *cpuStat) systemPercentage() float64 {
-	return s.percentage(s.system)
+// gauge converts a cpuStat into a slice of gauges. a kernel reporting garbage
+// jiffies can produce percentages outside of [0,1], which are clamped so they
+// don't wreck the scale of every dashboard they appear on.
+func (s *cpuStat) metrics() []gauge {
+	newGauge := func(name string, value float64) gauge {
+		if value < 0 || value > 1 {
+			debugf("Clamping implausible %s of %v (user=%d nice=%d system=%d idle=%d total=%d)\n",
+				s.metricName(name), value, s.user, s.nice, s.system, s.idle, s.total)
+			value = math.Max(0, math.Min(1, value))
+		}
+		return gauge{Name: s.metricName(name), MeasureTime: s.epoch, Value: value, Source: hostname}
+	}
+	fractions := s.fractions()
+	return []gauge{
+		newGauge("user", fractions[0]),
+		newGauge("nice", fractions[1]),
+		newGauge("system", fractions[2]),
+		newGauge("idle", fractions[3]),
+		newGauge("usage", fractions[4]),
+	}
 }
 
-func (s *cpuStat) idlePercentage() float64 {
-	return s.percentage(s.idle)
+// fractions returns the user, nice, system, idle and usage fractions of the
+// total. this runs for every cpu every period, so it divides once and
+// multiplies rather than going through percentage for each of them.
+func (s *cpuStat) fractions() [5]float64 {
+	scale := 1 / float64(s.total)
+	return [5]float64{
+		float64(s.user) * scale,
+		float64(s.nice) * scale,
+		float64(s.system) * scale,
+		float64(s.idle) * scale,
+		float64(s.user+s.nice+s.system) * scale,
+	}
 }
 
-// gauge converts a cpuStat into a slice of gauges
-func (s *cpuStat) metrics() []gauge {
-	newGauge := func(name string, value float64) gauge {
-		return gauge{Name: fmt.Sprintf("%s-%s", s.name, name), MeasureTime: s.epoch, Value: value, Source: hostname}
+// counters converts a cpuStat into a slice of counters holding the raw
+// jiffy values, so that rates can be worked out by Librato
+func (s *cpuStat) counters() []counter {
+	newCounter := func(name string, value int) counter {
+		return counter{Name: s.metricName(name + "-jiffies"), MeasureTime: s.epoch, Value: int64(value), Source: hostname}
 	}
-	return []gauge{
-		newGauge("user", s.userPercentage()),
-		newGauge("nice", s.nicePercentage()),
-		newGauge("system", s.systemPercentage()),
-		newGauge("idle", s.idlePercentage()),
-		newGauge("usage", s.usagePercentage()),
+	return []counter{
+		newCounter("user", s.user),
+		newCounter("nice", s.nice),
+		newCounter("system", s.system),
+		newCounter("idle", s.idle),
 	}
 }
 
@@ -67,90 +113,352 @@ func (s *cpuStat) difference(other *cpuStat) cpuStat {
 		nice:   other.nice - s.nice,
 		system: other.system - s.system,
 		idle:   other.idle - s.idle,
+		iowait: other.iowait - s.iowait,
 		total:  other.total - s.total,
 	}
 }
 
-// monitorCpuUsage starts a goroutine and sends cpuStats to a channel
-// each successive cpuStat for a particular cpu will only consider values
-// since the last measurement.
-func monitorCpuUsage(metrics chan interface{}) {
-	lookup := make(map[string]cpuStat)
-	go func() {
-		for {
-			cpuStats, err := readCpuStats()
-			if err != nil {
-				fmt.Printf("Could not get cpu stats: %v\n", err)
-			} else {
-				for _, stat := range cpuStats {
-					cumulative, ok := lookup[stat.name]
-					if !ok {
-						cumulative = *new(cpuStat)
-						lookup[stat.name] = cumulative
-						// skip this one
-						continue
-					}
-					difference := cumulative.difference(&stat)
-					for _, metric := range difference.metrics() {
-						metrics <- metric
-					}
-					lookup[stat.name] = stat
-				}
+// cpuCollector reports cpu usage. each successive cpuStat for a particular
+// cpu will only consider values since the last measurement.
+type cpuCollector struct {
+	// held while collecting so that stop sees a whole reading
+	mu          sync.Mutex
+	lookup      map[string]cpuStat
+	warnedEmpty bool
+	// for conf.Cpu.PersistBaselineFile
+	baselineLoaded bool
+	bootTime       int64
+	// reused between reads of conf.Cpu.StatPath
+	buf   bytes.Buffer
+	stats []cpuStat
+}
+
+// the rates need a reading to compare against, see differencer
+func (c *cpuCollector) differences() bool {
+	return true
+}
+
+func newCpuCollector() *cpuCollector {
+	return &cpuCollector{lookup: make(map[string]cpuStat)}
+}
+
+func (c *cpuCollector) name() string {
+	return "cpu"
+}
+
+func (c *cpuCollector) period() time.Duration {
+	return seconds(conf.Cpu.PeriodSeconds)
+}
+
+func (c *cpuCollector) backend() string {
+	return conf.Cpu.Backend
+}
+
+func (c *cpuCollector) source() string {
+	return conf.Cpu.StatPath
+}
+
+func (c *cpuCollector) collect() ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cpuStats, bootTime, err := readCpuStats(&c.buf, c.stats)
+	if err != nil {
+		return nil, err
+	}
+	c.stats = cpuStats
+	c.bootTime = bootTime
+	if !c.baselineLoaded && conf.Cpu.PersistBaselineFile != "" {
+		// with a baseline from before grotto restarted, this first reading
+		// can be reported rather than only being remembered
+		c.baselineLoaded = true
+		lookup, err := loadCpuBaseline(conf.Cpu.PersistBaselineFile, bootTime)
+		if err != nil {
+			fmt.Printf("Could not load cpu baseline: %s\n", err)
+		}
+		if lookup != nil {
+			c.lookup = lookup
+		}
+	}
+	if len(cpuStats) == 0 {
+		// without this the collector would silently never report anything
+		if !c.warnedEmpty {
+			fmt.Printf("Warning: %s has no usable cpu lines, no cpu metrics will be sent\n", conf.Cpu.StatPath)
+			c.warnedEmpty = true
+		}
+		return nil, nil
+	}
+	c.warnedEmpty = false
+	var metrics []interface{}
+	if bootTime > 0 {
+		// a change in this between readings means the host rebooted
+		metrics = append(metrics, gauge{Name: "boot-time", MeasureTime: cpuStats[0].epoch, Value: float64(bootTime), Source: hostname})
+	}
+	var cores []cpuStat
+	for _, stat := range cpuStats {
+		// cores can be read without conf.Cpu.PerCoreGauges, see readCores
+		isCore := isCpuCore(stat.name)
+		if !isCore && stat.name != "cpu" {
+			continue
+		}
+		emit := conf.Cpu.PerCoreGauges
+		if !isCore {
+			emit = !conf.Cpu.SkipAggregate
+		}
+		if emit && conf.Cpu.EmitRawCounters {
+			for _, metric := range stat.counters() {
+				metrics = append(metrics, metric)
 			}
-			time.Sleep(time.Duration(conf.Cpu.PeriodSeconds) * time.Second)
 		}
-	}()
+		cumulative, ok := c.lookup[stat.name]
+		c.lookup[stat.name] = stat
+		if !ok {
+			// skip this one
+			continue
+		}
+		difference := cumulative.difference(&stat)
+		if isCore {
+			cores = append(cores, difference)
+		} else if conf.Cpu.EmitIowaitAggregate && difference.total > 0 {
+			// the cpu line is already the sum across cores
+			iowait := math.Max(0, math.Min(1, difference.percentage(difference.iowait)))
+			metrics = append(metrics, gauge{Name: "cpu-iowait-percent", MeasureTime: difference.epoch, Value: iowait, Source: hostname})
+		}
+		if emit {
+			for _, metric := range difference.metrics() {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	if conf.Cpu.EmitSummary {
+		for _, metric := range summarizeCores(cores) {
+			metrics = append(metrics, metric)
+		}
+	}
+	if conf.Cpu.EmitCount {
+		// this doesn't need a difference, so it's sent from the first reading
+		count := 0
+		for _, stat := range cpuStats {
+			if isCpuCore(stat.name) {
+				count++
+			}
+		}
+		metrics = append(metrics, gauge{Name: "cpu-count", MeasureTime: cpuStats[0].epoch, Value: float64(count), Source: hostname})
+	}
+	return metrics, nil
 }
 
-// readCpuStats reads /proc/stat, parses the values for the individual cpus
-// and then returns a slice of cpuStat, one for each cpu
-func readCpuStats() ([]cpuStat, error) {
-	file, err := os.Open("/proc/stat")
+// stop saves the latest readings to conf.Cpu.PersistBaselineFile
+func (c *cpuCollector) stop() {
+	if conf.Cpu.PersistBaselineFile == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.lookup) == 0 {
+		return
+	}
+	if err := saveCpuBaseline(conf.Cpu.PersistBaselineFile, c.bootTime, c.lookup); err != nil {
+		fmt.Printf("Could not save cpu baseline: %s\n", err)
+	}
+}
+
+// summarizeCores returns the min, max and average usage across the
+// differences for each core from a single reading
+func summarizeCores(cores []cpuStat) []gauge {
+	var lowest, highest, sum float64
+	var epoch int64
+	count := 0
+	for _, core := range cores {
+		if core.total <= 0 {
+			continue
+		}
+		usage := core.usagePercentage()
+		if count == 0 || usage < lowest {
+			lowest = usage
+		}
+		if count == 0 || usage > highest {
+			highest = usage
+		}
+		sum += usage
+		epoch = core.epoch
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	newGauge := func(name string, value float64) gauge {
+		return gauge{Name: name, MeasureTime: epoch, Value: value, Source: hostname}
+	}
+	return []gauge{
+		newGauge("cpu-usage-min", lowest),
+		newGauge("cpu-usage-max", highest),
+		newGauge("cpu-usage-avg", sum/float64(count)),
+	}
+}
+
+func (c *cpuCollector) describe() []string {
+	var names []string
+	if !conf.Cpu.SkipAggregate {
+		names = append(names, "cpu-total")
+	}
+	if conf.Cpu.PerCoreGauges {
+		names = append(names, "cpu<N>")
+	}
+	var described []string
+	for _, name := range names {
+		for _, suffix := range []string{"user", "nice", "system", "idle", "usage"} {
+			described = append(described, fmt.Sprintf("%s-%s", name, suffix))
+		}
+		if conf.Cpu.EmitRawCounters {
+			for _, suffix := range []string{"user", "nice", "system", "idle"} {
+				described = append(described, fmt.Sprintf("%s-%s-jiffies", name, suffix))
+			}
+		}
+	}
+	if conf.Cpu.EmitSummary {
+		described = append(described, "cpu-usage-min", "cpu-usage-max", "cpu-usage-avg")
+	}
+	if conf.Cpu.EmitCount {
+		described = append(described, "cpu-count")
+	}
+	if conf.Cpu.EmitIowaitAggregate {
+		described = append(described, "cpu-iowait-percent")
+	}
+	return append(described, "boot-time")
+}
+
+// readCpuStats reads conf.Cpu.StatPath, normally /proc/stat, parses the values for the individual cpus
+// and then returns a slice of cpuStat, one for each cpu, along with the boot
+// time in epoch seconds from the btime line, or 0 if there isn't one. this runs every
+// period on hosts with a lot of cores, so the file is read into buf and the
+// stats are written over the front of stats, letting callers reuse both.
+func readCpuStats(buf *bytes.Buffer, stats []cpuStat) (_ []cpuStat, bootTime int64, err error) {
+	file, err := os.Open(conf.Cpu.StatPath)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer func() {
-		if err := file.Close(); err != nil {
-			panic(err)
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("Could not close %s: %s", conf.Cpu.StatPath, closeErr)
 		}
 	}()
-	stats := make([]cpuStat, 0)
-	scanner := bufio.NewScanner(bufio.NewReader(file))
-	for scanner.Scan() {
-		text := scanner.Text()
-		tokens := split(text)
-		cpuName := tokens[0]
-		if strings.HasPrefix(cpuName, "cpu") {
-			if !conf.Cpu.PerCoreGauges && len(cpuName) > 3 {
-				// skip things like cpu0, cpu1, etc
-				continue
+	buf.Reset()
+	if _, err := buf.ReadFrom(file); err != nil {
+		return nil, 0, err
+	}
+	// with conf.Cpu.AlignTimestamps every cpu gets the time the read started,
+	// so that one reading doesn't straddle a second and land in two of
+	// Librato's aggregation buckets
+	readTime := measureTime(time.Now())
+	stats = stats[:0]
+	data := buf.Bytes()
+	for len(data) > 0 {
+		var line []byte
+		if end := bytes.IndexByte(data, '\n'); end >= 0 {
+			line, data = data[:end], data[end+1:]
+		} else {
+			line, data = data, nil
+		}
+		cpuName, rest := nextField(line)
+		if bytes.Equal(cpuName, btimePrefix) {
+			field, _ := nextField(rest)
+			if value, err := parseCpuValue(field); err == nil {
+				bootTime = int64(value)
 			}
-			var stat cpuStat
-			stat.name = cpuName
-			stat.epoch = time.Now().Unix()
-			for index, valueString := range tokens[1:] {
-				value, err := atoi(valueString)
-				if err != nil {
-					return nil, err
-				}
-				switch index {
-				case 0:
-					stat.user = value
-				case 1:
-					stat.nice = value
-				case 2:
-					stat.system = value
-				case 3:
-					stat.idle = value
-				}
-				stat.total = stat.total + value
+			continue
+		}
+		if !bytes.HasPrefix(cpuName, cpuPrefix) {
+			continue
+		}
+		if !readCores() && len(cpuName) > 3 {
+			// skip things like cpu0, cpu1, etc
+			continue
+		}
+		var stat cpuStat
+		if n := len(stats); n < cap(stats) {
+			// the slot we're about to fill most likely held the same cpu
+			// last time, in which case its name can be reused
+			stat.name = stats[:n+1][n].name
+		}
+		if stat.name != string(cpuName) {
+			stat.name = string(cpuName)
+		}
+		stat.epoch = readTime
+		if !conf.Cpu.AlignTimestamps {
+			stat.epoch = measureTime(time.Now())
+		}
+		for index := 0; ; index++ {
+			var field []byte
+			if field, rest = nextField(rest); field == nil {
+				break
 			}
-
-			stats = append(stats, stat)
+			value, err := parseCpuValue(field)
+			if err != nil {
+				return nil, 0, err
+			}
+			switch index {
+			case 0:
+				stat.user = value
+			case 1:
+				stat.nice = value
+			case 2:
+				stat.system = value
+			case 3:
+				stat.idle = value
+			case 4:
+				stat.iowait = value
+			}
+			stat.total = stat.total + value
 		}
+		stats = append(stats, stat)
 	}
-	if err = scanner.Err(); err != nil {
-		return nil, err
+	return stats, bootTime, nil
+}
+
+// where cpu stats are read from unless conf.Cpu.StatPath says otherwise
+const defaultStatPath = "/proc/stat"
+
+var (
+	cpuPrefix   = []byte("cpu")
+	btimePrefix = []byte("btime")
+)
+
+// readCores reports whether the lines for individual cores are needed, which
+// they are for more than just conf.Cpu.PerCoreGauges
+func readCores() bool {
+	return conf.Cpu.PerCoreGauges || conf.Cpu.EmitSummary || conf.Cpu.EmitCount
+}
+
+// nextField returns the first whitespace separated field in line and what
+// follows it, or nil if there are no more fields
+func nextField(line []byte) (field []byte, rest []byte) {
+	start := 0
+	for start < len(line) && isSpace(line[start]) {
+		start++
+	}
+	if start == len(line) {
+		return nil, nil
+	}
+	end := start
+	for end < len(line) && !isSpace(line[end]) {
+		end++
+	}
+	return line[start:end], line[end:]
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+// parseCpuValue parses a jiffy count without the allocation that atoi would
+// need to turn the field into a string first
+func parseCpuValue(field []byte) (int, error) {
+	value := 0
+	for _, b := range field {
+		if b < '0' || b > '9' {
+			return atoi(string(field))
+		}
+		value = value*10 + int(b-'0')
 	}
-	return stats, nil
+	return value, nil
 }
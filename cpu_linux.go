@@ -0,0 +1,7 @@
+package main
+
+// newPlatformCpuCollector returns the cpu collector, which reads /proc/stat
+// or whatever conf.Cpu.StatPath points at
+func newPlatformCpuCollector() collector {
+	return newCpuCollector()
+}
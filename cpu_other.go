@@ -0,0 +1,54 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// newPlatformCpuCollector returns the cpu collector when conf.Cpu.StatPath
+// points somewhere other than /proc/stat, as it would at a fixture on a
+// development machine, and otherwise one that reports nothing since there is
+// no /proc/stat to read
+func newPlatformCpuCollector() collector {
+	if conf.Cpu.StatPath != defaultStatPath {
+		return newCpuCollector()
+	}
+	return new(noopCpuCollector)
+}
+
+// noopCpuCollector stands in for the cpu collector off Linux, saying so once
+type noopCpuCollector struct {
+	warned bool
+}
+
+func (c *noopCpuCollector) name() string {
+	return "cpu"
+}
+
+func (c *noopCpuCollector) period() time.Duration {
+	return seconds(conf.Cpu.PeriodSeconds)
+}
+
+func (c *noopCpuCollector) backend() string {
+	return conf.Cpu.Backend
+}
+
+func (c *noopCpuCollector) source() string {
+	return defaultStatPath
+}
+
+func (c *noopCpuCollector) collect() ([]interface{}, error) {
+	if !c.warned {
+		fmt.Printf("Warning: there is no %s on %s, no cpu metrics will be sent unless conf.Cpu.StatPath is set\n", defaultStatPath, runtime.GOOS)
+		c.warned = true
+	}
+	return nil, nil
+}
+
+func (c *noopCpuCollector) describe() []string {
+	return nil
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCpuDescribe(t *testing.T) {
+	c := useConfig(t, `{}`)
+	expected := []string{"cpu-total-user", "cpu-total-nice", "cpu-total-system", "cpu-total-idle", "cpu-total-usage", "boot-time"}
+	if names := new(cpuCollector).describe(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+	c.Cpu.PerCoreGauges = true
+	names := new(cpuCollector).describe()
+	for _, name := range []string{"cpu-total-usage", "cpu<N>-usage"} {
+		found := false
+		for _, described := range names {
+			found = found || described == name
+		}
+		if !found {
+			t.Errorf("Expected %s among %v", name, names)
+		}
+	}
+}
+
+func TestRawCountersAreCumulative(t *testing.T) {
+	useConfig(t, `{"Cpu": {"EmitRawCounters": true}}`)
+	stat := cpuStat{name: "cpu", user: 160, nice: 10, system: 80, idle: 1150, total: 1400, epoch: 1}
+	counters := make(map[string]int64)
+	for _, c := range stat.counters() {
+		counters[c.Name] = c.Value
+	}
+	expected := map[string]int64{"cpu-total-user-jiffies": 160, "cpu-total-nice-jiffies": 10, "cpu-total-system-jiffies": 80, "cpu-total-idle-jiffies": 1150}
+	if !reflect.DeepEqual(counters, expected) {
+		t.Errorf("Expected counters %v, got %v", expected, counters)
+	}
+	names := new(cpuCollector).describe()
+	if names[len(names)-2] != "cpu-total-idle-jiffies" {
+		t.Errorf("Expected the jiffies to be described, got %v", names)
+	}
+}
+
+// collectCpu writes each snapshot of /proc/stat to path in turn and returns
+// what the collector read from each
+func collectCpu(t *testing.T, c *cpuCollector, path string, snapshots ...string) [][]interface{} {
+	t.Helper()
+	old := conf.Cpu.StatPath
+	defer func() { conf.Cpu.StatPath = old }()
+	conf.Cpu.StatPath = path
+	var readings [][]interface{}
+	for _, snapshot := range snapshots {
+		writeFiles(t, filepath.Dir(path), map[string]string{filepath.Base(path): snapshot})
+		metrics, err := c.collect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		readings = append(readings, metrics)
+	}
+	return readings
+}
+
+// statPath returns where a test's /proc/stat goes
+func statPath(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "stat")
+}
+
+func TestNoUsableCpuLinesWarnsOnce(t *testing.T) {
+	useConfig(t, `{}`)
+	c := newCpuCollector()
+	output := captureOutput(t, func() {
+		for _, reading := range collectCpu(t, c, statPath(t), "intr 1 0\n", "intr 2 0\n", "intr 3 0\n") {
+			if len(reading) > 0 {
+				t.Errorf("Expected no metrics, got %v", reading)
+			}
+		}
+	})
+	if warnings := strings.Count(output, "has no usable cpu lines"); warnings != 1 {
+		t.Errorf("Expected a single warning, got %d in %q", warnings, output)
+	}
+}
+
+// readCpuStatsRegexp is how /proc/stat was parsed before readCpuStats
+// tokenized it by hand
+func readCpuStatsRegexp(t testing.TB, path string) []cpuStat {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var stats []cpuStat
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		tokens := split(scanner.Text())
+		if !strings.HasPrefix(tokens[0], "cpu") {
+			continue
+		}
+		stat := cpuStat{name: tokens[0]}
+		for index, valueString := range tokens[1:] {
+			value, err := atoi(valueString)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch index {
+			case 0:
+				stat.user = value
+			case 1:
+				stat.nice = value
+			case 2:
+				stat.system = value
+			case 3:
+				stat.idle = value
+			case 4:
+				stat.iowait = value
+			}
+			stat.total = stat.total + value
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// useStatFixture reads /proc/stat from testdata for the rest of the test
+func useStatFixture(t testing.TB) {
+	old := conf.Cpu.StatPath
+	t.Cleanup(func() { conf.Cpu.StatPath = old })
+	conf.Cpu.StatPath = "testdata/proc/stat"
+}
+
+func TestReadCpuStatsMatchesRegexp(t *testing.T) {
+	useConfig(t, `{"Cpu": {"PerCoreGauges": true}}`)
+	useStatFixture(t)
+	var buf bytes.Buffer
+	stats, _, err := readCpuStats(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := readCpuStatsRegexp(t, conf.Cpu.StatPath)
+	if len(stats) != len(expected) || len(stats) != 5 {
+		t.Fatalf("Expected 5 cpus from both parsers, got %d and %d", len(stats), len(expected))
+	}
+	for i, stat := range stats {
+		stat.epoch = 0
+		if stat != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], stat)
+		}
+	}
+	// reusing the buffer and stats must give the same result
+	again, _, err := readCpuStats(&buf, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, stat := range again {
+		stat.epoch = 0
+		if stat != expected[i] {
+			t.Errorf("Expected %+v on a second read, got %+v", expected[i], stat)
+		}
+	}
+}
+
+func BenchmarkReadCpuStats(b *testing.B) {
+	oldConf := conf
+	defer func() { conf = oldConf }()
+	conf = &config{}
+	conf.Cpu.PerCoreGauges = true
+	useStatFixture(b)
+	b.Run("hand", func(b *testing.B) {
+		var buf bytes.Buffer
+		var stats []cpuStat
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var err error
+			if stats, _, err = readCpuStats(&buf, stats); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("regexp", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			readCpuStatsRegexp(b, conf.Cpu.StatPath)
+		}
+	})
+}
+
+func TestImplausiblePercentagesAreClamped(t *testing.T) {
+	useConfig(t, `{"LogLevel": "debug"}`)
+	previous := cpuStat{name: "cpu", user: 100, idle: 100, total: 200}
+	// user went up by more than the total did
+	current := cpuStat{name: "cpu", user: 250, idle: 100, total: 300}
+	diff := previous.difference(&current)
+	if user := diff.percentage(diff.user); user != 1.5 {
+		t.Fatalf("Expected the difference to give a user fraction of 1.5, got %v", user)
+	}
+	var metrics []gauge
+	output := captureOutput(t, func() { metrics = diff.metrics() })
+	for _, g := range metrics {
+		if g.Name == "cpu-total-user" && g.Value != 1 {
+			t.Errorf("Expected cpu-total-user to be clamped to 1, got %v", g.Value)
+		}
+	}
+	if !strings.Contains(output, "Clamping implausible cpu-total-user of 1.5") {
+		t.Errorf("Expected the clamp to be logged, got %q", output)
+	}
+}
+
+func TestCoreSummary(t *testing.T) {
+	path := statPath(t)
+	useConfig(t, `{"Cpu": {"EmitSummary": true}}`)
+	readings := collectCpu(t, newCpuCollector(), path,
+		"cpu  0 0 0 0\ncpu0 0 0 0 0\ncpu1 0 0 0 0\ncpu2 0 0 0 0\n",
+		"cpu  150 0 0 150\ncpu0 20 0 0 80\ncpu1 30 10 10 50\ncpu2 70 0 10 20\n",
+	)
+	values := gaugeValues(readings[1])
+	for name, expected := range map[string]float64{"cpu-usage-min": 0.2, "cpu-usage-max": 0.8, "cpu-usage-avg": 0.5} {
+		if value, ok := values[name]; !ok || math.Abs(value-expected) > 1e-9 {
+			t.Errorf("Expected %s to be %v, got %v", name, expected, value)
+		}
+	}
+	// the summary doesn't need the per-core gauges to be sent
+	if _, ok := values["cpu0-usage"]; ok {
+		t.Error("Expected no per-core gauges without PerCoreGauges")
+	}
+}
+
+// divideEach is how the percentages were worked out before fractions
+// multiplied by the reciprocal of the total instead
+func divideEach(s *cpuStat) [5]float64 {
+	return [5]float64{s.percentage(s.user), s.percentage(s.nice), s.percentage(s.system), s.percentage(s.idle), s.usagePercentage()}
+}
+
+// cpuStatsForScaling are stats from the fixture along with some awkward ones
+func cpuStatsForScaling(t testing.TB) []cpuStat {
+	stats := readCpuStatsRegexp(t, "testdata/proc/stat")
+	return append(stats,
+		cpuStat{name: "cpu4", user: 1, nice: 1, system: 1, idle: 0, total: 3},
+		cpuStat{name: "cpu5", user: 7, nice: 0, system: 3, idle: 1<<31 - 1, total: 1<<31 + 9},
+		cpuStat{name: "cpu6", user: 0, nice: 0, system: 0, idle: 0, total: 0},
+	)
+}
+
+func TestCpuFractionsMatchDividing(t *testing.T) {
+	const epsilon = 1e-12
+	for _, stat := range cpuStatsForScaling(t) {
+		expected := divideEach(&stat)
+		for i, value := range stat.fractions() {
+			if math.IsNaN(expected[i]) && math.IsNaN(value) {
+				continue
+			}
+			if math.Abs(value-expected[i]) > epsilon {
+				t.Errorf("Fraction %d of %s was %v by multiplying and %v by dividing", i, stat.name, value, expected[i])
+			}
+		}
+	}
+}
+
+// fractionsSink keeps the compiler from optimizing the benchmarks away
+var fractionsSink [5]float64
+
+func BenchmarkCpuFractions(b *testing.B) {
+	stats := cpuStatsForScaling(b)
+	b.Run("multiply", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := range stats {
+				fractionsSink = stats[j].fractions()
+			}
+		}
+	})
+	b.Run("divide", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := range stats {
+				fractionsSink = divideEach(&stats[j])
+			}
+		}
+	})
+}
+
+func TestProvenanceDescription(t *testing.T) {
+	path := statPath(t)
+	useConfig(t, `{"Librato": {"IncludeProvenance": true}}`)
+	c := newCpuCollector()
+	reading := collectCpu(t, c, path, "cpu  100 10 50 1000 0\n", "cpu  160 10 80 1150 0\n")[1]
+	out := outgoing(c, reading)
+	if len(out) == 0 {
+		t.Fatal("Expected cpu gauges from the second reading")
+	}
+	expected := "cpu collector from " + conf.Cpu.StatPath
+	for _, metric := range out {
+		if g := metric.(gauge); g.Description != expected {
+			t.Errorf("Expected %s to be described as %q, got %q", g.Name, expected, g.Description)
+		}
+	}
+}
+
+func TestCpuCount(t *testing.T) {
+	useConfig(t, `{"Cpu": {"EmitCount": true}}`)
+	useStatFixture(t)
+	// the count doesn't need a difference, so it comes from the first reading
+	metrics, err := newCpuCollector().collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, ok := gaugeValues(metrics)["cpu-count"]; !ok || count != 4 {
+		t.Errorf("Expected a cpu-count of 4, got %v", count)
+	}
+}
+
+func TestBootTime(t *testing.T) {
+	path := statPath(t)
+	useConfig(t, `{}`)
+	c := newCpuCollector()
+	readings := collectCpu(t, c, path,
+		"cpu  100 10 50 1000 0\nbtime 1700000000\n",
+		"cpu  160 10 80 1150 0\nbtime 1700000000\n",
+		"cpu  10 1 5 100 0\nbtime 1700003600\n",
+	)
+	for i, expected := range []float64{1700000000, 1700000000, 1700003600} {
+		if bootTime, ok := gaugeValues(readings[i])["boot-time"]; !ok || bootTime != expected {
+			t.Errorf("Expected a boot-time of %v from reading %d, got %v", expected, i+1, bootTime)
+		}
+	}
+}
+
+func TestAlignedTimestamps(t *testing.T) {
+	path := statPath(t)
+	// milliseconds and plenty of cores give a reading every chance to
+	// straddle a boundary
+	useConfig(t, `{"TimestampUnit": "ms", "Cpu": {"PerCoreGauges": true, "AlignTimestamps": true}}`)
+	var first, second strings.Builder
+	first.WriteString("cpu  0 0 0 0\n")
+	second.WriteString("cpu  6400 0 0 6400\n")
+	for i := 0; i < 64; i++ {
+		fmt.Fprintf(&first, "cpu%d 0 0 0 0\n", i)
+		fmt.Fprintf(&second, "cpu%d 100 0 0 100\n", i)
+	}
+	reading := collectCpu(t, newCpuCollector(), path, first.String(), second.String())[1]
+	if len(reading) != 65*5 {
+		t.Fatalf("Expected gauges for the aggregate and 64 cores, got %d", len(reading))
+	}
+	epoch := reading[0].(gauge).MeasureTime
+	for _, metric := range reading {
+		if g := metric.(gauge); g.MeasureTime != epoch {
+			t.Fatalf("Expected every gauge to be measured at %d, %s was measured at %d", epoch, g.Name, g.MeasureTime)
+		}
+	}
+}
+
+func TestBaselineRoundTrip(t *testing.T) {
+	path := statPath(t)
+	baseline := filepath.Join(t.TempDir(), "baseline.json")
+	useConfig(t, `{"Cpu": {"PersistBaselineFile": "`+baseline+`"}}`)
+	before := newCpuCollector()
+	collectCpu(t, before, path, "cpu  100 10 50 1000 0\nbtime 1700000000\n")
+	before.stop()
+	// after a restart the first reading is compared against the saved one
+	after := newCpuCollector()
+	reading := collectCpu(t, after, path, "cpu  160 10 80 1150 0\nbtime 1700000000\n")[0]
+	if usage, ok := gaugeValues(reading)["cpu-total-usage"]; !ok || math.Abs(usage-0.375) > 1e-9 {
+		t.Errorf("Expected the first reading after a restart to give a usage of 0.375, got %v", usage)
+	}
+	// a baseline from before a reboot isn't trusted
+	after.stop()
+	rebooted := newCpuCollector()
+	reading = collectCpu(t, rebooted, path, "cpu  10 1 5 100 0\nbtime 1700003600\n")[0]
+	if _, ok := gaugeValues(reading)["cpu-total-usage"]; ok {
+		t.Error("Expected no usage from a baseline saved before a reboot")
+	}
+}
+
+func TestStatPathFixture(t *testing.T) {
+	useConfig(t, `{"Cpu": {"StatPath": "testdata/proc/stat", "PerCoreGauges": true}}`)
+	stats, bootTime, err := readCpuStats(new(bytes.Buffer), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bootTime != 1062191376 {
+		t.Errorf("Expected a boot time of 1062191376, got %d", bootTime)
+	}
+	var names []string
+	for _, stat := range stats {
+		names = append(names, stat.name)
+	}
+	if expected := []string{"cpu", "cpu0", "cpu1", "cpu2", "cpu3"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Expected stats for %v, got %v", expected, names)
+	}
+	// cpu3 is separated by a tab in the fixture
+	cpu3 := stats[4]
+	if cpu3.user != 1224330 || cpu3.nice != 27645 || cpu3.system != 516339 || cpu3.idle != 13502208 || cpu3.iowait != 5785 {
+		t.Errorf("Unexpected stats for cpu3: %+v", cpu3)
+	}
+	if c := newPlatformCpuCollector(); c.source() != "testdata/proc/stat" {
+		t.Errorf("Expected the platform collector to read the fixture, got %s", c.source())
+	}
+}
+
+func TestIowaitAggregate(t *testing.T) {
+	path := statPath(t)
+	c := useConfig(t, `{"Cpu": {"StatPath": "`+path+`", "EmitIowaitAggregate": true}}`)
+	snapshots := []string{"cpu  100 0 100 700 100\n", "cpu  200 0 200 1300 300\n"}
+	values := gaugeValues(collectCpu(t, newCpuCollector(), path, snapshots...)[1])
+	if iowait, ok := values["cpu-iowait-percent"]; !ok || math.Abs(iowait-0.2) > 1e-9 {
+		t.Errorf("Expected cpu-iowait-percent of 0.2, got %v", values)
+	}
+	c.Cpu.EmitIowaitAggregate = false
+	values = gaugeValues(collectCpu(t, newCpuCollector(), path, snapshots...)[1])
+	if _, ok := values["cpu-iowait-percent"]; ok {
+		t.Error("Expected no cpu-iowait-percent without EmitIowaitAggregate")
+	}
+}
+
+func TestAggregateNamesAreDistinct(t *testing.T) {
+	path := statPath(t)
+	c := useConfig(t, `{"Cpu": {"StatPath": "`+path+`", "PerCoreGauges": true}}`)
+	snapshots := []string{"cpu  0 0 0 0\ncpu0 0 0 0 0\ncpu1 0 0 0 0\n", "cpu  50 0 50 100\ncpu0 25 0 25 50\ncpu1 25 0 25 50\n"}
+	names := metricNames(collectCpu(t, newCpuCollector(), path, snapshots...)[1])
+	var total, cores int
+	for _, name := range names {
+		switch {
+		case strings.HasPrefix(name, "cpu-total-"):
+			total++
+		case strings.HasPrefix(name, "cpu0-") || strings.HasPrefix(name, "cpu1-"):
+			cores++
+		default:
+			t.Errorf("Expected only cpu-total-* and per-core names, got %s", name)
+		}
+	}
+	if total == 0 || cores == 0 {
+		t.Fatalf("Expected both aggregate and per-core gauges, got %v", names)
+	}
+	c.Cpu.SkipAggregate = true
+	for _, name := range metricNames(collectCpu(t, newCpuCollector(), path, snapshots...)[1]) {
+		if strings.HasPrefix(name, "cpu-total-") {
+			t.Errorf("Expected no aggregate gauges with SkipAggregate, got %s", name)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// deadLetter is what gets posted to conf.Librato.DeadLetterUrl for a payload
+// that could not be sent
+type deadLetter struct {
+	Timestamp int64           `json:"timestamp"`
+	Host      string          `json:"host"`
+	Backend   string          `json:"backend"`
+	Error     string          `json:"error"`
+	Payload   *libratoPayload `json:"payload"`
+}
+
+// sendDeadLetter posts a payload that failed to send, along with why it
+// failed, to the dead-letter url. this is only tried once so that an outage
+// doesn't turn into twice as many failing requests.
+func sendDeadLetter(b backend, payload *libratoPayload, sendErr error) error {
+	data, err := json.Marshal(deadLetter{
+		Timestamp: time.Now().Unix(),
+		Host:      hostname,
+		Backend:   b.name(),
+		Error:     sendErr.Error(),
+		Payload:   payload,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(conf.Librato.DeadLetterUrl, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Dead-letter endpoint responded with %d", resp.StatusCode)
+	}
+	return nil
+}
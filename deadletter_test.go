@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFailedPayloadGoesToDeadLetterUrl(t *testing.T) {
+	letters := make(chan deadLetter, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var letter deadLetter
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &letter); err != nil {
+			t.Errorf("Could not parse dead letter %s: %s", body, err)
+		}
+		letters <- letter
+	}))
+	defer server.Close()
+	c := useConfig(t, `{}`)
+	c.Librato.DeadLetterUrl = server.URL
+	fake := primary(t)
+	fake.fail(errors.New("librato is down"))
+	payload := newLibratoPayload()
+	payload.addMetric(testGauge("cpu", 1))
+	flushPayload(backends[""], payload)
+	select {
+	case letter := <-letters:
+		if letter.Host != "test" || !strings.Contains(letter.Error, "503") {
+			t.Errorf("Unexpected dead letter metadata %+v", letter)
+		}
+		if names := gaugeNames(letter.Payload); len(names) != 1 || names[0] != "cpu" {
+			t.Errorf("Expected the cpu gauge in the dead letter, got %v", names)
+		}
+	default:
+		t.Fatal("Nothing was posted to the dead-letter url")
+	}
+}
+
+func TestOpenCircuitSkipsDeadLetterAndSpool(t *testing.T) {
+	posted := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted <- struct{}{}
+	}))
+	defer server.Close()
+	c := useConfig(t, `{}`)
+	c.Librato.DeadLetterUrl = server.URL
+	c.Librato.SpoolDir = t.TempDir()
+	fake := new(fakeBackend)
+	for _, err := range []error{errCircuitOpen, errDestinationPaused} {
+		fake.err = err
+		payload := newLibratoPayload()
+		payload.addMetric(testGauge("cpu", 1))
+		flushPayload(fake, payload)
+	}
+	select {
+	case <-posted:
+		t.Error("Expected nothing to be posted to the dead-letter url")
+	default:
+	}
+	if spooled, err := ioutil.ReadDir(c.Librato.SpoolDir); err != nil || len(spooled) != 0 {
+		t.Errorf("Expected nothing to be spooled, got %d files (%v)", len(spooled), err)
+	}
+}
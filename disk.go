@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// the fewest samples of a mount's usage needed before guessing when it will
+// fill up, so that a single noisy reading doesn't produce a wild guess
+const minFillSamples = 3
+
+// mount is a line from /proc/mounts
+type mount struct {
+	device     string
+	mountPoint string
+	fsType     string
+	options    []string
+}
+
+// diskUsage is a sample of how much of a mount is used
+type diskUsage struct {
+	used      float64
+	available float64
+	total     float64
+	timestamp time.Time
+}
+
+// diskCollector reports space used on each mounted disk, along with a guess
+// at how many hours are left until it fills up based on how fast it has been
+// filling recently. it also reports whether each is mounted read-only, which
+// is what ext4 falls back to after I/O errors, and for ext4 how many errors
+// the filesystem has seen.
+type diskCollector struct {
+	history map[string][]diskUsage
+}
+
+func newDiskCollector() *diskCollector {
+	return &diskCollector{history: make(map[string][]diskUsage)}
+}
+
+func (c *diskCollector) name() string {
+	return "disk"
+}
+
+func (c *diskCollector) period() time.Duration {
+	return seconds(conf.Disk.PeriodSeconds)
+}
+
+func (c *diskCollector) backend() string {
+	return conf.Disk.Backend
+}
+
+// usage changes slowly enough that it can go in the slow tier
+func (c *diskCollector) slow() bool {
+	return true
+}
+
+func (c *diskCollector) source() string {
+	return conf.Disk.MountsPath + ", statfs and " + filepath.Join(conf.Disk.SysfsRoot, "fs/ext4")
+}
+
+func (c *diskCollector) collect() ([]interface{}, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+	// statfs can hang on a struggling disk or network mount, so the mounts
+	// are looked at concurrently, see scanAll, each into its own slot
+	picked := diskMounts(mounts)
+	usages := make([]diskUsage, len(picked))
+	errs := make([]error, len(picked))
+	scanAll(len(picked), func(i int) {
+		usages[i], errs[i] = statDisk(picked[i].mountPoint)
+	})
+	var metrics []interface{}
+	for i, m := range picked {
+		usage, err := usages[i], errs[i]
+		if err != nil {
+			fmt.Printf("Could not stat %s: %s\n", m.mountPoint, err)
+			continue
+		}
+		history := append(c.history[m.mountPoint], usage)
+		if len(history) > conf.Disk.HistorySamples {
+			history = history[len(history)-conf.Disk.HistorySamples:]
+		}
+		c.history[m.mountPoint] = history
+		// with conf.Disk.SourcePerMount the mount point goes in the source
+		// rather than the name
+		prefix := "disk-" + mountName(m.mountPoint)
+		var context *emitContext
+		if conf.Disk.SourcePerMount {
+			prefix = "disk"
+			context = &emitContext{qualifier: m.mountPoint, tags: map[string]string{"mount": m.mountPoint}}
+		}
+		newGauge := func(name string, value float64) gauge {
+			return gauge{Name: prefix + "-" + name, MeasureTime: measureTime(usage.timestamp), Value: value, Source: hostname, context: context}
+		}
+		metrics = append(metrics,
+			newGauge("used-bytes", usage.used),
+			newGauge("total-bytes", usage.total),
+		)
+		if usage.used+usage.available > 0 {
+			metrics = append(metrics, newGauge("used-percent", usage.used/(usage.used+usage.available)))
+		}
+		if hours, ok := hoursUntilFull(history); ok {
+			metrics = append(metrics, newGauge("hours-until-full", hours))
+		}
+		readonly := 0.0
+		if m.readonly() {
+			readonly = 1
+		}
+		metrics = append(metrics, newGauge("readonly", readonly))
+		if m.fsType == "ext4" {
+			if errors, ok := ext4Errors(conf.Disk.SysfsRoot, m.device); ok {
+				metrics = append(metrics, newGauge("errors", float64(errors)))
+			}
+		}
+	}
+	return metrics, nil
+}
+
+func (c *diskCollector) describe() []string {
+	if conf.Disk.SourcePerMount {
+		return []string{"disk-used-bytes", "disk-total-bytes", "disk-used-percent", "disk-hours-until-full", "disk-readonly", "disk-errors"}
+	}
+	return []string{
+		"disk-<mount>-used-bytes",
+		"disk-<mount>-total-bytes",
+		"disk-<mount>-used-percent",
+		"disk-<mount>-hours-until-full",
+		"disk-<mount>-readonly",
+		"disk-<mount>-errors",
+	}
+}
+
+// diskMounts picks the mounts to report on: the ones in conf.Disk.Mounts if
+// there are any, otherwise every mount backed by a device in /dev. a device
+// mounted in several places is only reported once.
+func diskMounts(mounts []mount) []mount {
+	var picked []mount
+	seen := make(map[string]bool)
+	for _, m := range mounts {
+		if len(conf.Disk.Mounts) > 0 {
+			for _, want := range conf.Disk.Mounts {
+				if m.mountPoint == want && !seen[m.mountPoint] {
+					seen[m.mountPoint] = true
+					picked = append(picked, m)
+				}
+			}
+			continue
+		}
+		if !strings.HasPrefix(m.device, "/dev/") || seen[m.device] {
+			continue
+		}
+		seen[m.device] = true
+		picked = append(picked, m)
+	}
+	return picked
+}
+
+// readonly reports whether the mount options say the mount is read-only
+func (m mount) readonly() bool {
+	for _, option := range m.options {
+		if option == "ro" {
+			return true
+		}
+	}
+	return false
+}
+
+// ext4Errors reads how many errors ext4 has recorded for a device from
+// <sysfsRoot>/fs/ext4/<device>/errors_count, which older kernels don't have.
+// the directory is named after the kernel's name for the device, so links
+// like /dev/mapper/root are followed to /dev/dm-0 first.
+func ext4Errors(sysfsRoot string, device string) (int64, bool) {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	errors, err := readInt64File(filepath.Join(sysfsRoot, "fs/ext4", filepath.Base(device), "errors_count"))
+	if err != nil {
+		return 0, false
+	}
+	return errors, true
+}
+
+// mountName turns a mount point into something that can go in a metric name,
+// so / becomes _ and /var/lib becomes _var_lib
+func mountName(mountPoint string) string {
+	return strings.Replace(mountPoint, "/", "_", -1)
+}
+
+// statDisk returns the usage of the filesystem mounted at mountPoint. like df,
+// space reserved for root counts as neither used nor available.
+func statDisk(mountPoint string) (diskUsage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(mountPoint, &stat); err != nil {
+		return diskUsage{}, err
+	}
+	blockSize := float64(stat.Bsize)
+	return diskUsage{
+		used:      float64(stat.Blocks-stat.Bfree) * blockSize,
+		available: float64(stat.Bavail) * blockSize,
+		total:     float64(stat.Blocks) * blockSize,
+		timestamp: time.Now(),
+	}, nil
+}
+
+// hoursUntilFull fits a line to the used bytes in history and works out when
+// it will reach the space that was available in the latest sample. there is
+// no answer when there are too few samples or the disk isn't filling up.
+func hoursUntilFull(history []diskUsage) (float64, bool) {
+	latest := history[len(history)-1]
+	if len(history) < minFillSamples || latest.used <= history[0].used {
+		return 0, false
+	}
+	start := history[0].timestamp
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range history {
+		x := sample.timestamp.Sub(start).Seconds()
+		sumX += x
+		sumY += sample.used
+		sumXY += x * sample.used
+		sumXX += x * x
+	}
+	n := float64(len(history))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+	// bytes per second
+	slope := (n*sumXY - sumX*sumY) / denominator
+	if slope <= 0 {
+		return 0, false
+	}
+	return latest.available / slope / 3600, true
+}
+
+// readMounts parses conf.Disk.MountsPath, normally /proc/mounts
+func readMounts() ([]mount, error) {
+	file, err := os.Open(conf.Disk.MountsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var mounts []mount
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		mounts = append(mounts, mount{
+			device:     unescapeMountField(fields[0]),
+			mountPoint: unescapeMountField(fields[1]),
+			fsType:     fields[2],
+			options:    strings.Split(fields[3], ","),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// unescapeMountField undoes the octal escapes, like \040 for a space, that
+// the kernel uses for whitespace in /proc/mounts
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+4 <= len(field) {
+			if value, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
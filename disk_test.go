@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHoursUntilFull(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	// filling by 1GB an hour with 10GB left at the end
+	var filling []diskUsage
+	for i := 0; i < 5; i++ {
+		filling = append(filling, diskUsage{
+			used:      float64(50+i) * 1e9,
+			available: float64(14-i) * 1e9,
+			timestamp: start.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	if _, ok := hoursUntilFull(filling[:minFillSamples-1]); ok {
+		t.Error("Expected no prediction from too few samples")
+	}
+	hours, ok := hoursUntilFull(filling)
+	if !ok || math.Abs(hours-10) > 1e-6 {
+		t.Errorf("Expected 10 hours until full, got %v", hours)
+	}
+	steady := []diskUsage{filling[0], filling[0], filling[0]}
+	for i := range steady {
+		steady[i].timestamp = start.Add(time.Duration(i) * time.Hour)
+	}
+	if _, ok := hoursUntilFull(steady); ok {
+		t.Error("Expected no prediction for a disk that isn't filling up")
+	}
+}
+
+func TestSourcePerMount(t *testing.T) {
+	useConfig(t, `{"Disk": {"SourcePerMount": true, "Mounts": ["/"]}}`)
+	metrics, err := newDiskCollector().collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gauges []gauge
+	for _, metric := range metrics {
+		for _, expanded := range expandMetric(metric) {
+			if g, ok := expanded.(gauge); ok {
+				gauges = append(gauges, g)
+			}
+		}
+	}
+	if len(gauges) == 0 {
+		t.Fatal("Expected disk gauges")
+	}
+	for _, g := range gauges {
+		if !strings.HasPrefix(g.Name, "disk-") || strings.Contains(g.Name, "/") {
+			t.Errorf("Expected the mount point to stay out of %s", g.Name)
+		}
+		if g.Source != "test:/" {
+			t.Errorf("Expected %s to have source test:/, got %s", g.Name, g.Source)
+		}
+	}
+}
+
+func TestReadOnlyMount(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"mounts":                    "/dev/sdb1 " + dir + " ext4 ro,relatime 0 0\n",
+		"fs/ext4/sdb1/errors_count": "7\n",
+	})
+	useConfig(t, `{"Disk": {"MountsPath": "`+filepath.Join(dir, "mounts")+`", "SysfsRoot": "`+dir+`"}}`)
+	metrics, err := newDiskCollector().collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := gaugeValues(metrics)
+	prefix := "disk-" + mountName(dir)
+	if readonly, ok := values[prefix+"-readonly"]; !ok || readonly != 1 {
+		t.Errorf("Expected %s-readonly of 1, got %v", prefix, values)
+	}
+	if errors, ok := values[prefix+"-errors"]; !ok || errors != 7 {
+		t.Errorf("Expected %s-errors of 7, got %v", prefix, values)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// diskStat holds the cumulative counters for one block device from
+// /proc/diskstats
+type diskStat struct {
+	name      string
+	reads     int64
+	readMs    int64
+	writes    int64
+	writeMs   int64
+	timestamp time.Time
+}
+
+// diskstatsCollector reports I/O rates and average latencies for each block
+// device, worked out from the change in /proc/diskstats between readings
+type diskstatsCollector struct {
+	lookup map[string]diskStat
+}
+
+func newDiskstatsCollector() *diskstatsCollector {
+	return &diskstatsCollector{lookup: make(map[string]diskStat)}
+}
+
+// the rates need a reading to compare against, see differencer
+func (c *diskstatsCollector) differences() bool {
+	return true
+}
+
+func (c *diskstatsCollector) name() string {
+	return "diskstats"
+}
+
+func (c *diskstatsCollector) period() time.Duration {
+	return seconds(conf.DiskStats.PeriodSeconds)
+}
+
+func (c *diskstatsCollector) backend() string {
+	return conf.DiskStats.Backend
+}
+
+func (c *diskstatsCollector) source() string {
+	return "/proc/diskstats"
+}
+
+func (c *diskstatsCollector) collect() ([]interface{}, error) {
+	stats, err := readDiskStats()
+	if err != nil {
+		return nil, err
+	}
+	var metrics []interface{}
+	for _, stat := range stats {
+		previous, ok := c.lookup[stat.name]
+		c.lookup[stat.name] = stat
+		if !ok {
+			continue
+		}
+		for _, metric := range previous.difference(&stat) {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, nil
+}
+
+func (c *diskstatsCollector) describe() []string {
+	return []string{
+		"disk-<device>-reads-per-sec",
+		"disk-<device>-writes-per-sec",
+		"disk-<device>-read-latency-ms",
+		"disk-<device>-write-latency-ms",
+	}
+}
+
+// difference returns gauges describing what happened between the receiver
+// and a later reading. the latencies are the time spent on each kind of
+// operation divided by how many there were, so they are left out for an
+// interval that had none.
+func (s *diskStat) difference(other *diskStat) []gauge {
+	elapsed := other.timestamp.Sub(s.timestamp).Seconds()
+	if elapsed <= 0 || other.reads < s.reads || other.writes < s.writes {
+		// the counters were reset, so there's nothing sensible to report
+		return nil
+	}
+	epoch := measureTime(other.timestamp)
+	newGauge := func(name string, value float64) gauge {
+		return gauge{Name: fmt.Sprintf("disk-%s-%s", s.name, name), MeasureTime: epoch, Value: value, Source: hostname}
+	}
+	reads, writes := other.reads-s.reads, other.writes-s.writes
+	gauges := []gauge{
+		newGauge("reads-per-sec", float64(reads)/elapsed),
+		newGauge("writes-per-sec", float64(writes)/elapsed),
+	}
+	if reads > 0 {
+		gauges = append(gauges, newGauge("read-latency-ms", float64(other.readMs-s.readMs)/float64(reads)))
+	}
+	if writes > 0 {
+		gauges = append(gauges, newGauge("write-latency-ms", float64(other.writeMs-s.writeMs)/float64(writes)))
+	}
+	return gauges
+}
+
+// readDiskStats parses /proc/diskstats, skipping loop and ram devices which
+// are rarely interesting and can be numerous
+func readDiskStats() ([]diskStat, error) {
+	file, err := os.Open(filepath.Join(procRoot, "diskstats"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	now := time.Now()
+	var stats []diskStat
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 11 {
+			continue
+		}
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		stat := diskStat{name: name, timestamp: now}
+		for _, field := range []struct {
+			index int
+			value *int64
+		}{{3, &stat.reads}, {6, &stat.readMs}, {7, &stat.writes}, {10, &stat.writeMs}} {
+			if *field.value, err = parseInt64(fields[field.index]); err != nil {
+				return nil, err
+			}
+		}
+		stats = append(stats, stat)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+// diskstatsLine formats a /proc/diskstats line for sda with the given reads,
+// time spent reading, writes and time spent writing
+func diskstatsLine(reads, readMs, writes, writeMs string) string {
+	return "   8       0 sda " + reads + " 0 0 " + readMs + " " + writes + " 0 0 " + writeMs + " 0 0 0\n" +
+		"   7       0 loop0 1 0 0 1 1 0 0 1 0 0 0\n"
+}
+
+func TestDiskLatency(t *testing.T) {
+	root := useFakeProc(t)
+	useConfig(t, `{}`)
+	c := newDiskstatsCollector()
+	var metrics []interface{}
+	for _, snapshot := range []string{
+		diskstatsLine("100", "400", "50", "500"),
+		// 10 reads that took 30ms and no writes
+		diskstatsLine("110", "430", "50", "500"),
+	} {
+		writeFiles(t, root, map[string]string{"diskstats": snapshot})
+		var err error
+		if metrics, err = c.collect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values := make(map[string]float64)
+	for _, metric := range metrics {
+		g := metric.(gauge)
+		values[g.Name] = g.Value
+	}
+	if latency, ok := values["disk-sda-read-latency-ms"]; !ok || latency != 3 {
+		t.Errorf("Expected a read latency of 3ms, got %v", latency)
+	}
+	if _, ok := values["disk-sda-write-latency-ms"]; ok {
+		t.Error("Expected no write latency for an interval without writes")
+	}
+	if _, ok := values["disk-loop0-reads-per-sec"]; ok {
+		t.Error("Expected loop devices to be skipped")
+	}
+}
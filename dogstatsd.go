@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// the largest datagram we'll send. this is what the Datadog agent recommends
+// for UDP so that packets aren't fragmented on a typical network
+const dogstatsdMaxPacketSize = 1432
+
+// dogstatsdBackend sends metrics to a DogStatsD agent over UDP
+type dogstatsdBackend struct {
+	label string // the name in conf.Backends, if it has one
+	addr  string
+	tags  map[string]string
+}
+
+func (b *dogstatsdBackend) name() string {
+	if b.label != "" {
+		return b.label
+	}
+	return "dogstatsd"
+}
+
+// send writes each metric as a DogStatsD gauge line, packing as many lines
+// into each datagram as will fit. counters are cumulative here, which isn't
+// what a DogStatsD counter means, so they are sent as gauges too.
+func (b *dogstatsdBackend) send(payload *libratoPayload) error {
+	conn, err := net.Dial("udp", b.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var lines [][]byte
+	for _, g := range payload.Gauges {
+		lines = append(lines, b.line(g.Name, strconv.FormatFloat(g.Value, 'f', -1, 64), g.Source))
+	}
+	for _, c := range payload.Counters {
+		lines = append(lines, b.line(c.Name, strconv.FormatInt(c.Value, 10), c.Source))
+	}
+	for _, packet := range packLines(lines, dogstatsdMaxPacketSize) {
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// line formats a single metric, e.g. cpu-total-usage:0.25|g|#env:prod,host:web1
+func (b *dogstatsdBackend) line(name string, value string, source string) []byte {
+	environment := environmentTags()
+	tags := make([]string, 0, len(environment)+len(b.tags)+1)
+	for key, value := range environment {
+		if _, ok := b.tags[key]; !ok {
+			tags = append(tags, fmt.Sprintf("%s:%s", key, value))
+		}
+	}
+	for key, value := range b.tags {
+		tags = append(tags, fmt.Sprintf("%s:%s", key, value))
+	}
+	sort.Strings(tags)
+	if source != "" {
+		tags = append(tags, "host:"+source)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s:%s|g", name, value)
+	for i, tag := range tags {
+		if i == 0 {
+			buf.WriteString("|#")
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(tag)
+	}
+	return buf.Bytes()
+}
+
+// packLines joins lines with newlines into packets no bigger than max. a
+// line that is too big on its own gets a packet to itself.
+func packLines(lines [][]byte, max int) [][]byte {
+	var packets [][]byte
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > max {
+			packets = append(packets, packet)
+			packet = nil
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		packets = append(packets, packet)
+	}
+	return packets
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDogstatsdPacketHasTags(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	useConfig(t, `{"Dogstatsd": {"Addr": "`+listener.LocalAddr().String()+`", "Tags": {"env": "prod", "role": "web"}}}`)
+	payload := newLibratoPayload()
+	g := testGauge("cpu-total-usage", 0.25)
+	g.Source = "web1"
+	payload.Gauges = append(payload.Gauges, g)
+	if err := newBackend().send(payload); err != nil {
+		t.Fatal(err)
+	}
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, dogstatsdMaxPacketSize)
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "cpu-total-usage:0.25|g|#env:prod,role:web,host:web1"
+	if packet := string(buf[:n]); packet != expected {
+		t.Errorf("Expected %q, got %q", expected, packet)
+	}
+}
+
+func TestPackLinesSplitsPackets(t *testing.T) {
+	line := []byte(strings.Repeat("x", 600))
+	packets := packLines([][]byte{line, line, line}, dogstatsdMaxPacketSize)
+	if len(packets) != 2 {
+		t.Fatalf("Expected 2 packets, got %d", len(packets))
+	}
+	for _, packet := range packets {
+		if len(packet) > dogstatsdMaxPacketSize {
+			t.Errorf("Packet of %d bytes is over the limit", len(packet))
+		}
+	}
+}
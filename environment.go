@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// readEnvironmentFile reads the key=value pairs in conf.EnvironmentFile,
+// which provisioning writes out per host, like dc=iad1,env=prod. pairs may be
+// separated by commas or newlines, and lines starting with # are ignored. the
+// pairs are added as tags to everything sent, see environmentTags, or to the
+// source when Librato is sent the legacy form, see environmentSource.
+func readEnvironmentFile(loc string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(loc)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read conf.EnvironmentFile: %s", err)
+	}
+	tags := make(map[string]string)
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, pair := range strings.Split(line, ",") {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			sep := strings.IndexByte(pair, '=')
+			if sep <= 0 {
+				return nil, fmt.Errorf("Could not parse %q in conf.EnvironmentFile, expected key=value", pair)
+			}
+			tags[strings.TrimSpace(pair[:sep])] = strings.TrimSpace(pair[sep+1:])
+		}
+	}
+	return tags, nil
+}
+
+// environmentTags returns the tags from conf.EnvironmentFile, if there is one
+func environmentTags() map[string]string {
+	if conf == nil {
+		return nil
+	}
+	return conf.environment
+}
+
+// environmentSource adds the values from conf.EnvironmentFile to source, in
+// order of their keys and joined with colons like an emitContext, as in
+// myhost:iad1:prod. Librato's legacy form has no tags, so this is how the
+// environment is kept apart there.
+func environmentSource(source string) string {
+	tags := environmentTags()
+	if len(tags) == 0 {
+		return source
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys)+1)
+	if source != "" {
+		parts = append(parts, source)
+	}
+	for _, key := range keys {
+		parts = append(parts, tags[key])
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnvironmentInLegacySource(t *testing.T) {
+	loc := filepath.Join(t.TempDir(), "environment")
+	if err := ioutil.WriteFile(loc, []byte("# written by provisioning\nenv=prod, dc=iad1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, apiVersion := range []string{"", "tags"} {
+		useConfig(t, fmt.Sprintf(`{"EnvironmentFile": %q, "Librato": {"ApiVersion": %q}}`, loc, apiVersion))
+		payload := newLibratoPayload()
+		payload.addMetric(testGauge("load", 1))
+		data, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `"source":"test:iad1:prod"`
+		if apiVersion == "tags" {
+			expected = `"tags":{"dc":"iad1","env":"prod","host":"test"}`
+		}
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("With ApiVersion %q expected %s in %s", apiVersion, expected, data)
+		}
+		// the payload itself is left alone for the other backends
+		if payload.Gauges[0].Source != "test" {
+			t.Errorf("Expected the payload's source to be unchanged, got %s", payload.Gauges[0].Source)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileBackend appends metrics to a local file as JSON lines, one per metric,
+// for hosts that can't reach a backend and have their metrics shipped out
+// some other way. the file is rotated to <path>.1 once it would grow past
+// conf.FileOutput.MaxBytes, and written out and synced to disk every
+// conf.FileOutput.SyncSeconds rather than on every send.
+type fileBackend struct {
+	path     string
+	maxBytes int64
+	// guards the rest, since payloads are flushed concurrently with each
+	// other and with syncing
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+// fileLine is a single line in the file
+type fileLine struct {
+	Type        string            `json:"type"`
+	Name        string            `json:"name"`
+	Value       float64           `json:"value"`
+	Source      string            `json:"source,omitempty"`
+	MeasureTime int64             `json:"measure_time"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// newFileBackend returns a fileBackend and starts syncing it every interval
+func newFileBackend(path string, maxBytes int64, interval time.Duration) *fileBackend {
+	b := &fileBackend{path: path, maxBytes: maxBytes}
+	go func() {
+		for range time.Tick(interval) {
+			if err := b.sync(); err != nil {
+				limitedf("Could not sync %s: %s\n", b.path, err)
+			}
+		}
+	}()
+	return b
+}
+
+func (b *fileBackend) name() string {
+	return "file"
+}
+
+func (b *fileBackend) send(payload *libratoPayload) error {
+	tags := environmentTags()
+	var lines []fileLine
+	for _, g := range payload.Gauges {
+		lines = append(lines, fileLine{"gauge", g.Name, g.Value, g.Source, g.MeasureTime, tags})
+	}
+	for _, c := range payload.Counters {
+		lines = append(lines, fileLine{"counter", c.Name, float64(c.Value), c.Source, c.MeasureTime, tags})
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, line := range lines {
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		if err := b.rotate(int64(len(data))); err != nil {
+			return err
+		}
+		if b.file == nil {
+			if err := b.open(); err != nil {
+				return err
+			}
+		}
+		n, err := b.w.Write(data)
+		b.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open opens the file for appending, creating it if need be
+func (b *fileBackend) open() error {
+	file, err := os.OpenFile(b.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	b.file, b.w, b.size = file, bufio.NewWriter(file), info.Size()
+	return nil
+}
+
+// rotate moves the file aside to <path>.1, replacing any that was there, if
+// writing another n bytes would take it past the limit. a file with nothing
+// in it is never rotated, so a single line bigger than the limit still goes
+// somewhere.
+func (b *fileBackend) rotate(n int64) error {
+	if b.maxBytes <= 0 {
+		return nil
+	}
+	if b.file == nil {
+		if err := b.open(); err != nil {
+			return err
+		}
+	}
+	if b.size == 0 || b.size+n <= b.maxBytes {
+		return nil
+	}
+	if err := b.close(); err != nil {
+		return err
+	}
+	if err := os.Rename(b.path, b.path+".1"); err != nil {
+		return fmt.Errorf("Could not rotate %s: %s", b.path, err)
+	}
+	return nil
+}
+
+// sync writes out what is buffered and syncs it to disk
+func (b *fileBackend) sync() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file == nil {
+		return nil
+	}
+	if err := b.w.Flush(); err != nil {
+		return err
+	}
+	return b.file.Sync()
+}
+
+// close syncs and closes the file, which is opened again on the next send
+func (b *fileBackend) close() error {
+	if b.file == nil {
+		return nil
+	}
+	err := b.w.Flush()
+	if syncErr := b.file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := b.file.Close(); err == nil {
+		err = closeErr
+	}
+	b.file, b.w = nil, nil
+	return err
+}
+
+// stop makes sure nothing buffered is lost when grotto exits
+func (b *fileBackend) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.close(); err != nil {
+		fmt.Printf("Could not close %s: %s\n", b.path, err)
+	}
+}
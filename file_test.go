@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readFileLines returns the names in the JSON lines at loc
+func readFileLines(t *testing.T, loc string) []string {
+	t.Helper()
+	file, err := os.Open(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line fileLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("Could not parse %q: %s", scanner.Text(), err)
+		}
+		names = append(names, line.Name)
+	}
+	return names
+}
+
+func TestFileBackendRotates(t *testing.T) {
+	useConfig(t, `{}`)
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	// room for two lines, but not three
+	b := newFileBackend(path, 200, time.Hour)
+	defer b.stop()
+	payload := newLibratoPayload()
+	payload.addMetric(testGauge("load", 1))
+	payload.addMetric(counter{Name: "ctxt", Value: 42, Source: "test", MeasureTime: 1})
+	if err := b.send(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if names := readFileLines(t, path); !reflect.DeepEqual(names, []string{"load", "ctxt"}) {
+		t.Fatalf("Expected a line for each metric, got %v", names)
+	}
+	payload = newLibratoPayload()
+	payload.addMetric(testGauge("cpu", 1))
+	if err := b.send(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if names := readFileLines(t, path+".1"); !reflect.DeepEqual(names, []string{"load", "ctxt"}) {
+		t.Errorf("Expected the full file to be rotated, got %v", names)
+	}
+	if names := readFileLines(t, path); !reflect.DeepEqual(names, []string{"cpu"}) {
+		t.Errorf("Expected the new line in a fresh file, got %v", names)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// metricFilter decides which metrics get sent based on their names. a metric
+// is sent if it matches one of the Include patterns, or there aren't any,
+// and it matches none of the Exclude patterns.
+type metricFilter struct {
+	Include []string
+	Exclude []string
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// compile compiles the patterns so they can be used by allows
+func (f *metricFilter) compile() error {
+	var err error
+	if f.include, err = compilePatterns(f.Include); err != nil {
+		return err
+	}
+	f.exclude, err = compilePatterns(f.Exclude)
+	return err
+}
+
+// allows reports whether a metric with the given name should be sent
+func (f *metricFilter) allows(name string) bool {
+	if len(f.include) > 0 && !matchesAny(f.include, name) {
+		return false
+	}
+	return !matchesAny(f.exclude, name)
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q: %s", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, name string) bool {
+	for _, re := range patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterExcludesNice(t *testing.T) {
+	useConfig(t, `{"Filter": {"Exclude": ["-nice$"]}}`)
+	reading := []interface{}{testGauge("cpu-user", 1), testGauge("cpu-nice", 2), testGauge("cpu0-nice", 3), testGauge("cpu0-usage", 4)}
+	out := outgoing(&fakeCollector{label: "cpu"}, reading)
+	if names := metricNames(out); !reflect.DeepEqual(names, []string{"cpu-user", "cpu0-usage"}) {
+		t.Errorf("Expected the nice gauges to be dropped, got %v", names)
+	}
+}
+
+func TestInvalidFilterPattern(t *testing.T) {
+	if _, err := readTestConfig(t, `{"Filter": {"Include": ["cpu-("]}}`); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
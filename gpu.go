@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long nvidia-smi gets to answer before the reading is given up, since
+// it can hang when a driver is wedged
+const gpuQueryTimeout = 5 * time.Second
+
+// the query nvidia-smi is run with, one row per gpu
+var gpuQueryArgs = []string{
+	"--query-gpu=utilization.gpu,memory.used,memory.total,temperature.gpu",
+	"--format=csv,noheader,nounits",
+}
+
+// gpuCollector reports utilization, memory and temperature for each nvidia
+// gpu by running nvidia-smi. hosts without it report nothing.
+type gpuCollector struct {
+	warnedMissing bool
+}
+
+func (c *gpuCollector) name() string {
+	return "gpu"
+}
+
+func (c *gpuCollector) period() time.Duration {
+	return seconds(conf.Gpu.PeriodSeconds)
+}
+
+func (c *gpuCollector) backend() string {
+	return conf.Gpu.Backend
+}
+
+func (c *gpuCollector) source() string {
+	return "nvidia-smi"
+}
+
+func (c *gpuCollector) collect() ([]interface{}, error) {
+	path, err := exec.LookPath("nvidia-smi")
+	if err != nil {
+		if !c.warnedMissing {
+			fmt.Printf("Warning: nvidia-smi is not on the PATH, no gpu metrics will be sent\n")
+			c.warnedMissing = true
+		}
+		return nil, nil
+	}
+	c.warnedMissing = false
+	ctx, cancel := context.WithTimeout(context.Background(), gpuQueryTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, gpuQueryArgs...).Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("nvidia-smi took longer than %s", gpuQueryTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseGpuQuery(string(output), measureTime(time.Now()))
+}
+
+func (c *gpuCollector) describe() []string {
+	return []string{
+		"gpu<N>-util-percent",
+		"gpu<N>-mem-used-mb",
+		"gpu<N>-mem-total-mb",
+		"gpu<N>-temp-celsius",
+	}
+}
+
+// parseGpuQuery turns the csv rows from nvidia-smi into gauges. fields that
+// a gpu doesn't support come back as [N/A] and are left out.
+func parseGpuQuery(output string, epoch int64) ([]interface{}, error) {
+	reader := csv.NewReader(strings.NewReader(output))
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse nvidia-smi output: %s", err)
+	}
+	var metrics []interface{}
+	for i, row := range rows {
+		if len(row) != 4 {
+			return nil, fmt.Errorf("Expected 4 fields from nvidia-smi, got %d", len(row))
+		}
+		for j, suffix := range []string{"util-percent", "mem-used-mb", "mem-total-mb", "temp-celsius"} {
+			value, err := strconv.ParseFloat(strings.TrimSpace(row[j]), 64)
+			if err != nil {
+				continue
+			}
+			if j == 0 {
+				// percentages are sent as fractions like cpu usage
+				value /= 100
+			}
+			metrics = append(metrics, gauge{Name: fmt.Sprintf("gpu%d-%s", i, suffix), MeasureTime: epoch, Value: value, Source: hostname})
+		}
+	}
+	return metrics, nil
+}
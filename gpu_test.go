@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// useFakeNvidiaSmi puts an nvidia-smi on the PATH that prints output
+func useFakeNvidiaSmi(t *testing.T, output string) {
+	t.Helper()
+	dir := t.TempDir()
+	// printf is a shell builtin, so the script works with only dir on the PATH
+	script := "#!/bin/sh\nprintf '" + output + "'\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "nvidia-smi"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestGpuQuery(t *testing.T) {
+	useConfig(t, `{}`)
+	useFakeNvidiaSmi(t, `45, 1024, 8192, 61\n100, 2048, 8192, [N/A]\n`)
+	metrics, err := (&gpuCollector{}).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := gaugeValues(metrics)
+	expected := map[string]float64{
+		"gpu0-util-percent": .45,
+		"gpu0-mem-used-mb":  1024,
+		"gpu0-mem-total-mb": 8192,
+		"gpu0-temp-celsius": 61,
+		"gpu1-util-percent": 1,
+		"gpu1-mem-used-mb":  2048,
+		"gpu1-mem-total-mb": 8192,
+	}
+	if len(values) != len(expected) {
+		t.Errorf("Expected %d gauges, got %v", len(expected), values)
+	}
+	for name, value := range expected {
+		if got, ok := values[name]; !ok || got != value {
+			t.Errorf("Expected %s of %v, got %v", name, value, got)
+		}
+	}
+}
+
+func TestMissingNvidiaSmi(t *testing.T) {
+	useConfig(t, `{}`)
+	t.Setenv("PATH", t.TempDir())
+	c := &gpuCollector{}
+	metrics, err := c.collect()
+	if err != nil || len(metrics) != 0 {
+		t.Errorf("Expected nothing without nvidia-smi, got %v, %v", metrics, err)
+	}
+	if !c.warnedMissing {
+		t.Error("Expected a warning about the missing nvidia-smi")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// newHttpClient builds the client used to talk to Librato from the global config.
+// the usual proxy environment variables are respected unless a proxy is set in
+// the config, in which case it is always used. credentials can be given in the
+// proxy url as user:password@. keep-alives and the idle connection timeout
+// keep Go's defaults unless they are configured.
+func newHttpClient() http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.Librato.proxyUrl != nil {
+		transport.Proxy = http.ProxyURL(conf.Librato.proxyUrl)
+	}
+	if conf.Librato.IdleConnTimeoutSeconds > 0 {
+		transport.IdleConnTimeout = seconds(conf.Librato.IdleConnTimeoutSeconds)
+	}
+	transport.DisableKeepAlives = conf.Librato.DisableKeepAlives
+	dialer := &cachingDialer{
+		resolver: net.DefaultResolver,
+		ttl:      seconds(conf.Librato.DnsCacheSeconds),
+		cache:    make(map[string]resolvedHost),
+	}
+	dialer.dialer.Timeout = 30 * time.Second
+	dialer.dialer.KeepAlive = 30 * time.Second
+	transport.DialContext = dialer.DialContext
+	return http.Client{Transport: transport}
+}
+
+// resolvedHost is the result of a successful lookup
+type resolvedHost struct {
+	addrs    []string
+	resolved time.Time
+}
+
+// cachingDialer does its own name resolution so that DNS failures can be
+// told apart from connection failures. if ttl is positive it remembers the
+// last good addresses for each host and falls back to them when a lookup
+// fails.
+type cachingDialer struct {
+	dialer   net.Dialer
+	resolver *net.Resolver
+	ttl      time.Duration
+	mu       sync.Mutex
+	cache    map[string]resolvedHost
+}
+
+func (d *cachingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+	addrs, err := d.resolver.LookupHost(ctx, host)
+	if err != nil {
+		fmt.Printf("DNS lookup for %s failed: %s\n", host, err)
+		if addrs = d.cached(host); addrs == nil {
+			return nil, err
+		}
+		fmt.Printf("Using cached addresses for %s: %v\n", host, addrs)
+	} else {
+		d.store(host, addrs)
+	}
+	for _, addr := range addrs {
+		var conn net.Conn
+		conn, err = d.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("Could not connect to %s: %s", address, err)
+}
+
+// cached returns the last good addresses for host, or nil if there are none
+// or they are too old to be trusted
+func (d *cachingDialer) cached(host string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.cache[host]
+	if !ok || time.Since(entry.resolved) > d.ttl {
+		return nil
+	}
+	return entry.addrs
+}
+
+func (d *cachingDialer) store(host string, addrs []string) {
+	if d.ttl <= 0 || len(addrs) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cache[host] = resolvedHost{addrs: addrs, resolved: time.Now()}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachedAddressesOnDnsFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	failing := &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("no DNS here")
+	}}
+	dialer := &cachingDialer{resolver: failing, ttl: time.Minute, cache: make(map[string]resolvedHost)}
+	if _, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("librato.test", port)); err == nil {
+		t.Fatal("Expected the dial to fail without a cached address")
+	}
+	// a lookup that worked earlier
+	dialer.store("librato.test", []string{"127.0.0.1"})
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("librato.test", port))
+	if err != nil {
+		t.Fatalf("Expected the cached address to be used, got %s", err)
+	}
+	conn.Close()
+	// once the cached addresses are too old they aren't trusted
+	dialer.ttl = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("librato.test", port)); err == nil {
+		t.Error("Expected the dial to fail once the cache had expired")
+	}
+}
+
+func TestRequestsGoThroughProxy(t *testing.T) {
+	proxied := make(chan *http.Request, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r
+	}))
+	defer proxy.Close()
+	c := useConfig(t, `{"Librato": {"ProxyUrl": "`+strings.Replace(proxy.URL, "http://", "http://grotto:secret@", 1)+`"}}`)
+	client := newHttpClient()
+	resp, err := client.Get("http://librato.test/v1/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case r := <-proxied:
+		if r.URL.String() != "http://librato.test/v1/metrics" {
+			t.Errorf("Expected the proxy to be asked for the Librato url, got %s", r.URL)
+		}
+		expected := "Basic " + base64.StdEncoding.EncodeToString([]byte("grotto:secret"))
+		if auth := r.Header.Get("Proxy-Authorization"); auth != expected {
+			t.Errorf("Expected the proxy credentials %q, got %q", expected, auth)
+		}
+	default:
+		t.Fatalf("The request didn't go through the proxy at %s", c.Librato.ProxyUrl)
+	}
+}
+
+func TestTransportFollowsConfig(t *testing.T) {
+	useConfig(t, `{}`)
+	transport := newHttpClient().Transport.(*http.Transport)
+	defaults := http.DefaultTransport.(*http.Transport)
+	if transport.IdleConnTimeout != defaults.IdleConnTimeout || transport.DisableKeepAlives {
+		t.Errorf("Expected Go's defaults, got an idle timeout of %s and DisableKeepAlives %v", transport.IdleConnTimeout, transport.DisableKeepAlives)
+	}
+	useConfig(t, `{"Librato": {"IdleConnTimeoutSeconds": 15, "DisableKeepAlives": true}}`)
+	transport = newHttpClient().Transport.(*http.Transport)
+	if transport.IdleConnTimeout != 15*time.Second || !transport.DisableKeepAlives {
+		t.Errorf("Expected an idle timeout of 15s and no keep-alives, got %s and %v", transport.IdleConnTimeout, transport.DisableKeepAlives)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the buddy allocator order of a 2MB block made of 4KB pages, which is what
+// a transparent hugepage needs
+const hugepageOrder = 9
+
+// hugepagesCollector reports hugepage usage from /proc/meminfo and how
+// fragmented free memory is from /proc/buddyinfo. kernels without hugepage
+// support don't have the fields, in which case their gauges are left out.
+type hugepagesCollector struct{}
+
+func (c *hugepagesCollector) name() string {
+	return "hugepages"
+}
+
+func (c *hugepagesCollector) period() time.Duration {
+	return seconds(conf.HugePages.PeriodSeconds)
+}
+
+func (c *hugepagesCollector) backend() string {
+	return conf.HugePages.Backend
+}
+
+func (c *hugepagesCollector) source() string {
+	return "/proc/meminfo and /proc/buddyinfo"
+}
+
+func (c *hugepagesCollector) collect() ([]interface{}, error) {
+	meminfo, err := readKeyValueFile(filepath.Join(procRoot, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	epoch := measureTime(time.Now())
+	newGauge := func(name string, value float64) gauge {
+		return gauge{Name: name, MeasureTime: epoch, Value: value, Source: hostname}
+	}
+	var metrics []interface{}
+	if value, ok := meminfo["AnonHugePages"]; ok {
+		metrics = append(metrics, newGauge("mem-anon-hugepages-bytes", float64(value*1024)))
+	}
+	if value, ok := meminfo["HugePages_Total"]; ok {
+		metrics = append(metrics, newGauge("hugepages-total", float64(value)))
+	}
+	if value, ok := meminfo["HugePages_Free"]; ok {
+		metrics = append(metrics, newGauge("hugepages-free", float64(value)))
+	}
+	free, err := readBuddyinfo(filepath.Join(procRoot, "buddyinfo"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if index, ok := fragmentationIndex(free, hugepageOrder); ok {
+		metrics = append(metrics, newGauge("mem-fragmentation-index", index))
+	}
+	return metrics, nil
+}
+
+func (c *hugepagesCollector) describe() []string {
+	return []string{"mem-anon-hugepages-bytes", "hugepages-total", "hugepages-free", "mem-fragmentation-index"}
+}
+
+// readBuddyinfo returns the number of free blocks of each order, summed over
+// every node and zone in buddyinfo. lines look like
+// "Node 0, zone   Normal   8706   2147    216 ..." with order 0 first.
+func readBuddyinfo(loc string) ([]int64, error) {
+	file, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var free []int64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "Node" || fields[2] != "zone" {
+			continue
+		}
+		for order, field := range fields[4:] {
+			value, err := parseInt64(field)
+			if err != nil {
+				return nil, err
+			}
+			if order >= len(free) {
+				free = append(free, make([]int64, order+1-len(free))...)
+			}
+			free[order] += value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return free, nil
+}
+
+// fragmentationIndex returns the fraction of free memory that is in blocks
+// too small for an allocation of the given order: 0 when all of it could be
+// handed out in blocks that big, and close to 1 when it is in tiny pieces.
+// there is nothing to say when no memory is free.
+func fragmentationIndex(free []int64, order int) (float64, bool) {
+	var total, usable int64
+	for o, blocks := range free {
+		pages := blocks << uint(o)
+		total += pages
+		if o >= order {
+			usable += pages
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return 1 - float64(usable)/float64(total), true
+}
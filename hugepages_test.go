@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHugepageGauges(t *testing.T) {
+	root := useFakeProc(t)
+	useConfig(t, `{}`)
+	writeFiles(t, root, map[string]string{
+		"meminfo": "MemTotal:       16384000 kB\nAnonHugePages:     40960 kB\nHugePages_Total:      64\nHugePages_Free:       16\n",
+		// 1024 free pages on their own and 1024 in two hugepage sized blocks
+		"buddyinfo": "Node 0, zone      DMA      0      0      0      0      0      0      0      0      0      0      0\n" +
+			"Node 0, zone   Normal    512      0      0      0      0      0      0      0      0      1      0\n" +
+			"Node 1, zone   Normal    512      0      0      0      0      0      0      0      0      1      0\n",
+	})
+	metrics, err := new(hugepagesCollector).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{
+		"mem-anon-hugepages-bytes": 40960 * 1024,
+		"hugepages-total":          64,
+		"hugepages-free":           16,
+		"mem-fragmentation-index":  0.5,
+	}
+	if values := gaugeValues(metrics); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+}
+
+func TestHugepagesWithoutKernelSupport(t *testing.T) {
+	root := useFakeProc(t)
+	useConfig(t, `{}`)
+	writeFiles(t, root, map[string]string{"meminfo": "MemTotal:       16384000 kB\nMemFree:         8192000 kB\n"})
+	metrics, err := new(hugepagesCollector).collect()
+	if err != nil || len(metrics) != 0 {
+		t.Errorf("Expected nothing and no error, got %v and %v", metrics, err)
+	}
+}
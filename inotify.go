@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inotifyCollector reports the inotify watch limit and, with
+// conf.Inotify.ScanUsage, how many watches are in use. running out of
+// watches makes things like log shippers quietly stop noticing changes.
+type inotifyCollector struct {
+	warnedDenied bool
+}
+
+func (c *inotifyCollector) name() string {
+	return "inotify"
+}
+
+func (c *inotifyCollector) period() time.Duration {
+	return seconds(conf.Inotify.PeriodSeconds)
+}
+
+func (c *inotifyCollector) backend() string {
+	return conf.Inotify.Backend
+}
+
+func (c *inotifyCollector) source() string {
+	return "/proc/sys/fs/inotify and /proc/<pid>/fdinfo"
+}
+
+func (c *inotifyCollector) collect() ([]interface{}, error) {
+	limit, err := readInt64File(filepath.Join(procRoot, "sys/fs/inotify/max_user_watches"))
+	if err != nil {
+		return nil, err
+	}
+	epoch := measureTime(time.Now())
+	metrics := []interface{}{
+		gauge{Name: "inotify-watches-max", MeasureTime: epoch, Value: float64(limit), Source: hostname},
+	}
+	if conf.Inotify.ScanUsage {
+		used, err := c.countWatches()
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, gauge{Name: "inotify-watches-used", MeasureTime: epoch, Value: float64(used), Source: hostname})
+	}
+	return metrics, nil
+}
+
+func (c *inotifyCollector) describe() []string {
+	names := []string{"inotify-watches-max"}
+	if conf.Inotify.ScanUsage {
+		names = append(names, "inotify-watches-used")
+	}
+	return names
+}
+
+// countWatches adds up the watches on every inotify fd of every process,
+// looking at the processes concurrently, see scanAll. the limit is per user,
+// but this is the total for the host, which is what matters when one service
+// holds most of them. processes whose fds we aren't allowed to look at are
+// left out.
+func (c *inotifyCollector) countWatches() (int, error) {
+	pids, err := listPids()
+	if err != nil {
+		return 0, err
+	}
+	// each pid's count goes in its own slot, so nothing is shared
+	counts := make([]int, len(pids))
+	denied := make([]bool, len(pids))
+	scanAll(len(pids), func(i int) {
+		count, err := countProcessWatches(pids[i])
+		if err != nil {
+			denied[i] = os.IsPermission(err)
+			return
+		}
+		counts[i] = count
+	})
+	total := 0
+	for i, count := range counts {
+		if denied[i] && !c.warnedDenied {
+			fmt.Printf("Warning: not allowed to read the fds of some processes, their inotify watches won't be counted\n")
+			c.warnedDenied = true
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// countProcessWatches counts the "inotify wd:" lines in the fdinfo of each of
+// a process's inotify fds
+func countProcessWatches(pid int) (int, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join(dir, "fd", entry.Name()))
+		if err != nil || target != "anon_inode:inotify" {
+			continue
+		}
+		file, err := os.Open(filepath.Join(dir, "fdinfo", entry.Name()))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "inotify wd:") {
+				count++
+			}
+		}
+		file.Close()
+	}
+	return count, nil
+}
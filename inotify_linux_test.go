@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestCountWatches(t *testing.T) {
+	for _, concurrency := range []string{"1", "8"} {
+		useConfig(t, `{"ScanConcurrency": `+concurrency+`}`)
+		fd, err := syscall.InotifyInit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := syscall.InotifyAddWatch(fd, t.TempDir(), syscall.IN_CREATE); err != nil {
+			t.Fatal(err)
+		}
+		own, err := countProcessWatches(os.Getpid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if own < 1 {
+			t.Errorf("Expected at least one watch for this process, got %d", own)
+		}
+		total, err := new(inotifyCollector).countWatches()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total < own {
+			t.Errorf("With ScanConcurrency %s expected at least %d watches in total, got %d", concurrency, own, total)
+		}
+		syscall.Close(fd)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// writeFakeInotify gives a fake process an inotify fd with the given number
+// of watches, along with an fd that isn't inotify
+func writeFakeInotify(t *testing.T, root string, pid, watches int) {
+	t.Helper()
+	writeFakeProcess(t, root, pid, "shipper", "S", 0, 0)
+	writeFakeFds(t, root, pid, "/var/log/syslog", "anon_inode:inotify")
+	fdinfo := "pos:\t0\nflags:\t00\nmnt_id:\t15\n"
+	for i := 0; i < watches; i++ {
+		fdinfo += "inotify wd:" + strconv.Itoa(i+1) + " ino:1 sdev:800001 mask:100 ignored_mask:0\n"
+	}
+	writeFiles(t, filepath.Join(root, strconv.Itoa(pid), "fdinfo"), map[string]string{"0": "pos:\t0\n", "1": fdinfo})
+}
+
+func TestInotifyGauges(t *testing.T) {
+	root := useFakeProc(t)
+	writeFiles(t, filepath.Join(root, "sys/fs/inotify"), map[string]string{"max_user_watches": "8192\n"})
+	writeFakeInotify(t, root, 100, 3)
+	writeFakeInotify(t, root, 200, 2)
+	useConfig(t, `{}`)
+	metrics, err := new(inotifyCollector).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := gaugeValues(metrics); !reflect.DeepEqual(values, map[string]float64{"inotify-watches-max": 8192}) {
+		t.Errorf("Expected only the max without ScanUsage, got %v", values)
+	}
+	conf.Inotify.ScanUsage = true
+	if metrics, err = new(inotifyCollector).collect(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{"inotify-watches-max": 8192, "inotify-watches-used": 5}
+	if values := gaugeValues(metrics); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// the highest syslog level counted as an error: emerg, alert, crit and err
+const kmsgErrorLevel = 3
+
+// kmsgCollector reports how many kernel messages at error level or worse
+// were logged each period. the device stays open between collections so
+// that each one only sees messages it hasn't seen before.
+type kmsgCollector struct {
+	fd      int
+	open    bool
+	partial []byte
+}
+
+func (c *kmsgCollector) name() string {
+	return "kmsg"
+}
+
+func (c *kmsgCollector) period() time.Duration {
+	return seconds(conf.Kmsg.PeriodSeconds)
+}
+
+func (c *kmsgCollector) backend() string {
+	return conf.Kmsg.Backend
+}
+
+func (c *kmsgCollector) source() string {
+	return conf.Kmsg.Path
+}
+
+func (c *kmsgCollector) collect() ([]interface{}, error) {
+	if !c.open {
+		if err := c.openSource(); err != nil {
+			return nil, err
+		}
+	}
+	lines, err := c.readLines()
+	if err != nil {
+		syscall.Close(c.fd)
+		c.open = false
+		return nil, err
+	}
+	count := 0
+	for _, line := range lines {
+		if level, ok := kmsgLevel(line); ok && level <= kmsgErrorLevel {
+			count++
+		}
+	}
+	return []interface{}{
+		gauge{Name: "kmsg-errors-per-period", MeasureTime: measureTime(time.Now()), Value: float64(count), Source: hostname},
+	}, nil
+}
+
+func (c *kmsgCollector) describe() []string {
+	return []string{"kmsg-errors-per-period"}
+}
+
+// openSource opens conf.Kmsg.Path without blocking. the os package would
+// park reads on a pollable device like /dev/kmsg until a message arrives, so
+// the fd is read directly. the kernel's ring buffer is skipped so that only
+// messages logged from now on are counted.
+func (c *kmsgCollector) openSource() error {
+	fd, err := syscall.Open(conf.Kmsg.Path, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	if stat.Mode&syscall.S_IFMT == syscall.S_IFCHR {
+		if _, err := syscall.Seek(fd, 0, io.SeekEnd); err != nil {
+			syscall.Close(fd)
+			return err
+		}
+	}
+	c.fd, c.open, c.partial = fd, true, nil
+	return nil
+}
+
+// readLines reads everything available without blocking. each read of
+// /dev/kmsg returns a single record, while a plain file may return several
+// or split one, so records are split on newlines either way.
+func (c *kmsgCollector) readLines() ([]string, error) {
+	var lines []string
+	buf := make([]byte, 8192)
+	for {
+		n, err := syscall.Read(c.fd, buf)
+		if err == syscall.EAGAIN || (err == nil && n == 0) {
+			break
+		}
+		if err == syscall.EPIPE {
+			// older records were overwritten before we read them
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		data := append(c.partial, buf[:n]...)
+		for {
+			end := bytes.IndexByte(data, '\n')
+			if end < 0 {
+				break
+			}
+			lines = append(lines, string(data[:end]))
+			data = data[end+1:]
+		}
+		c.partial = append([]byte(nil), data...)
+	}
+	return lines, nil
+}
+
+// kmsgLevel returns the syslog level of a record, which starts with
+// "priority,sequence,timestamp,flags;" where the level is the low three bits
+// of the priority. continuation lines, which start with a space, have none.
+func kmsgLevel(line string) (int, bool) {
+	end := strings.IndexAny(line, ",;")
+	if end <= 0 {
+		return 0, false
+	}
+	priority, err := atoi(line[:end])
+	if err != nil {
+		return 0, false
+	}
+	return priority & 7, true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKmsgErrorCount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kmsg")
+	writeFiles(t, filepath.Dir(path), map[string]string{"kmsg": ""})
+	useConfig(t, `{"Kmsg": {"Path": "`+path+`"}}`)
+	c := new(kmsgCollector)
+	errors := func() float64 {
+		t.Helper()
+		metrics, err := c.collect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gaugeValues(metrics)["kmsg-errors-per-period"]
+	}
+	if count := errors(); count != 0 {
+		t.Errorf("Expected no errors from an empty log, got %v", count)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	// crit, err, warning, info and then an err split across periods
+	file.WriteString("2,100,1000,-;raid failed\n3,101,1001,-;ext4 error\n4,102,1002,-;slow disk\n6,103,1003,-;link up\n3,104,10")
+	if count := errors(); count != 2 {
+		t.Errorf("Expected 2 errors, got %v", count)
+	}
+	file.WriteString("04,-;i/o error\n")
+	if count := errors(); count != 1 {
+		t.Errorf("Expected only the error finished since the last period, got %v", count)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"time"
+)
+
+// the pid of kthreadd, which every other kernel thread is a child of
+const kthreaddPid = 2
+
+// kthreadsCollector reports how much of the host's cpu goes to kernel
+// threads such as ksoftirqd and kworker, which are easy to miss when they are
+// what is saturating a host
+type kthreadsCollector struct {
+	buf       bytes.Buffer
+	previous  map[int]int // jiffies used by each kernel thread last time
+	lastTotal int         // jiffies spent by all cpus last time
+}
+
+// the cpu share needs a reading to compare against, see differencer
+func (c *kthreadsCollector) differences() bool {
+	return true
+}
+
+func (c *kthreadsCollector) name() string {
+	return "kthreads"
+}
+
+func (c *kthreadsCollector) period() time.Duration {
+	return seconds(conf.KernelThreads.PeriodSeconds)
+}
+
+func (c *kthreadsCollector) backend() string {
+	return conf.KernelThreads.Backend
+}
+
+func (c *kthreadsCollector) source() string {
+	return "/proc/<pid>/stat and /proc/stat"
+}
+
+// collect returns the share of all cpu time since the last reading that was
+// used by kernel threads. only threads seen in both readings are counted, so
+// ones that come and go don't throw the sum off.
+func (c *kthreadsCollector) collect() ([]interface{}, error) {
+	procs, err := readProcStats()
+	if err != nil {
+		return nil, err
+	}
+	cpuStats, _, err := readCpuStats(&c.buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(cpuStats) == 0 || cpuStats[0].name != "cpu" {
+		return nil, nil
+	}
+	current := make(map[int]int)
+	used := 0
+	for _, proc := range procs {
+		if !isKernelThread(proc) {
+			continue
+		}
+		jiffies := proc.utime + proc.stime
+		current[proc.pid] = jiffies
+		if last, ok := c.previous[proc.pid]; ok && jiffies >= last {
+			used += jiffies - last
+		}
+	}
+	previous, lastTotal := c.previous, c.lastTotal
+	c.previous, c.lastTotal = current, cpuStats[0].total
+	elapsed := cpuStats[0].total - lastTotal
+	if previous == nil || elapsed <= 0 {
+		return nil, nil
+	}
+	return []interface{}{
+		gauge{Name: "kernel-threads-cpu-percent", MeasureTime: cpuStats[0].epoch, Value: float64(used) / float64(elapsed), Source: hostname},
+	}, nil
+}
+
+func (c *kthreadsCollector) describe() []string {
+	return []string{"kernel-threads-cpu-percent"}
+}
+
+// isKernelThread reports whether a process is kthreadd or was started by it.
+// ps shows these with their names in brackets, but that is ps's doing and
+// /proc/<pid>/stat just has the plain name.
+func isKernelThread(proc procStat) bool {
+	return proc.pid == kthreaddPid || proc.ppid == kthreaddPid
+}
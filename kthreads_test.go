@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestKernelThreadsCpuPercent(t *testing.T) {
+	root := useFakeProc(t)
+	stat := statPath(t)
+	old := conf.Cpu.StatPath
+	defer func() { conf.Cpu.StatPath = old }()
+	conf.Cpu.StatPath = stat
+	c := new(kthreadsCollector)
+	var metrics []interface{}
+	for _, reading := range []struct {
+		stat                      string
+		kthreadd, ksoftirqd, bash int
+	}{
+		{"cpu  100 0 100 800\n", 0, 10, 50},
+		// 1000 jiffies went by, 100 of them in ksoftirqd, 50 in kthreadd
+		// and 400 in bash, which isn't a kernel thread
+		{"cpu  600 0 100 1300\n", 50, 110, 450},
+	} {
+		writeFakeChild(t, root, 1, 0, "systemd", "S", 0, 0)
+		writeFakeChild(t, root, kthreaddPid, 0, "kthreadd", "S", reading.kthreadd, 0)
+		writeFakeChild(t, root, 10, kthreaddPid, "ksoftirqd/0", "S", reading.ksoftirqd/2, reading.ksoftirqd/2)
+		writeFakeChild(t, root, 500, 1, "bash", "R", reading.bash, 0)
+		writeFiles(t, filepath.Dir(stat), map[string]string{filepath.Base(stat): reading.stat})
+		var err error
+		if metrics, err = c.collect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if percent, ok := gaugeValues(metrics)["kernel-threads-cpu-percent"]; !ok || math.Abs(percent-0.15) > 1e-9 {
+		t.Errorf("Expected kernel threads to have used 15%% of the cpu, got %v", percent)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// liveness keeps track of how many times each collector has read successfully
+// and when it last did, so that with conf.CollectorLiveness a collector that
+// has silently stopped shows up as a flat <collector>-collections-total and a
+// climbing <collector>-last-collection-age-seconds. collectors run on their
+// own goroutines, hence the mutex.
+type liveness struct {
+	mu          sync.Mutex
+	collections map[string]int64
+	last        map[string]time.Time
+}
+
+var collectorLiveness = &liveness{
+	collections: make(map[string]int64),
+	last:        make(map[string]time.Time),
+}
+
+// start registers a collector, counting its age from now until it first
+// reads successfully
+func (l *liveness) start(name string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.collections[name] = 0
+	l.last[name] = now
+}
+
+// record notes a successful reading by the named collector
+func (l *liveness) record(name string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.collections[name]++
+	l.last[name] = now
+}
+
+// metrics returns the liveness counter and age gauge of every collector
+func (l *liveness) metrics(now time.Time) []interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0, len(l.last))
+	for name := range l.last {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	epoch := measureTime(now)
+	var metrics []interface{}
+	for _, name := range names {
+		metrics = append(metrics,
+			counter{Name: name + "-collections-total", MeasureTime: epoch, Value: l.collections[name], Source: hostname},
+			gauge{Name: name + "-last-collection-age-seconds", MeasureTime: epoch, Value: now.Sub(l.last[name]).Seconds(), Source: hostname},
+		)
+	}
+	return metrics
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLivenessAdvances(t *testing.T) {
+	useConfig(t, `{}`)
+	l := &liveness{collections: make(map[string]int64), last: make(map[string]time.Time)}
+	start := time.Unix(1700000000, 0)
+	l.start("cpu", start)
+	l.start("disk", start)
+	l.record("cpu", start.Add(time.Second))
+	l.record("cpu", start.Add(2*time.Second))
+	metrics := l.metrics(start.Add(5 * time.Second))
+	expected := []interface{}{
+		counter{Name: "cpu-collections-total", MeasureTime: 1700000005, Value: 2, Source: "test"},
+		gauge{Name: "cpu-last-collection-age-seconds", MeasureTime: 1700000005, Value: 3, Source: "test"},
+		counter{Name: "disk-collections-total", MeasureTime: 1700000005, Value: 0, Source: "test"},
+		gauge{Name: "disk-last-collection-age-seconds", MeasureTime: 1700000005, Value: 5, Source: "test"},
+	}
+	if !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("Expected %v, got %v", expected, metrics)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// debugf prints a message only when conf.LogLevel is "debug"
+func debugf(format string, args ...interface{}) {
+	if debugEnabled() {
+		fmt.Printf(format, args...)
+	}
+}
+
+// debugEnabled reports whether debug messages are printed, for when working
+// out what to print costs something
+func debugEnabled() bool {
+	return conf != nil && conf.LogLevel == "debug"
+}
+
+// repeated messages printed by limitedf, keyed by the message. payloads are
+// flushed concurrently, hence the mutex.
+var (
+	limitedMu       sync.Mutex
+	limitedMessages = make(map[string]*limitedMessage)
+)
+
+type limitedMessage struct {
+	printed    time.Time
+	suppressed int
+}
+
+// limitedAfter is how limitedf waits out conf.ErrorLogIntervalSeconds
+var limitedAfter = time.AfterFunc
+
+// limitedf prints a message like fmt.Printf unless the same message was
+// printed less than conf.ErrorLogIntervalSeconds ago, in which case it is
+// only counted. when the interval is up, how many times it was left out is
+// printed and the message is forgotten, so a long outage logs a couple of
+// lines per interval rather than one per failed send.
+func limitedf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	limitedMu.Lock()
+	defer limitedMu.Unlock()
+	if m, ok := limitedMessages[message]; ok {
+		m.suppressed++
+		return
+	}
+	m := &limitedMessage{printed: time.Now()}
+	limitedMessages[message] = m
+	fmt.Print(message)
+	limitedAfter(seconds(conf.ErrorLogIntervalSeconds), func() {
+		limitedMu.Lock()
+		defer limitedMu.Unlock()
+		delete(limitedMessages, message)
+		if m.suppressed > 0 {
+			fmt.Printf("Suppressed %d more in the last %s: %s", m.suppressed, time.Since(m.printed).Round(time.Second), message)
+		}
+	})
+}
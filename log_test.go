@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepeatedErrorsAreCoalesced(t *testing.T) {
+	useConfig(t, `{"ErrorLogIntervalSeconds": 3600}`)
+	limitedMu.Lock()
+	limitedMessages = make(map[string]*limitedMessage)
+	limitedMu.Unlock()
+	// the end of the interval is up to the test
+	var expire []func()
+	old := limitedAfter
+	defer func() { limitedAfter = old }()
+	limitedAfter = func(d time.Duration, f func()) *time.Timer {
+		if d != time.Hour {
+			t.Errorf("Expected to wait out an interval of 1h, got %s", d)
+		}
+		expire = append(expire, f)
+		return nil
+	}
+	message := "Could not send to fake: connection refused\n"
+	output := captureOutput(t, func() {
+		for i := 0; i < 3; i++ {
+			limitedf("Could not send to %s: %s\n", "fake", "connection refused")
+		}
+	})
+	if output != message || len(expire) != 1 {
+		t.Fatalf("Expected the message printed once, got %q", output)
+	}
+	// once the interval is up how many were left out is printed, whether or
+	// not the message comes up again, and the message is forgotten
+	output = captureOutput(t, expire[0])
+	if !strings.HasPrefix(output, "Suppressed 2 more in the last 0s: ") || !strings.HasSuffix(output, message) {
+		t.Errorf("Expected the suppressed count with the message, got %q", output)
+	}
+	limitedMu.Lock()
+	remembered := len(limitedMessages)
+	limitedMu.Unlock()
+	if remembered != 0 {
+		t.Errorf("Expected the message to be forgotten once reported, got %d", remembered)
+	}
+	output = captureOutput(t, func() {
+		limitedf("Could not send to %s: %s\n", "fake", "connection refused")
+	})
+	if output != message || len(expire) != 2 {
+		t.Errorf("Expected the message printed again after the interval, got %q", output)
+	}
+	// nothing is printed for an interval with nothing left out
+	if output = captureOutput(t, expire[1]); output != "" {
+		t.Errorf("Expected nothing when no messages were left out, got %q", output)
+	}
+}
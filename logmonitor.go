@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"syscall"
+	"time"
+)
+
+// logMonitorConfig is an entry in conf.LogMonitors
+type logMonitorConfig struct {
+	Name          string
+	Path          string
+	Regex         string
+	PeriodSeconds flexInt // defaults to conf.Librato.PeriodSeconds
+	Backend       string
+	// where how far the file has been read is kept, so that a restart
+	// picks up where the last run left off, see logMonitorState
+	StateFile string
+	regex     *regexp.Regexp
+}
+
+// logMonitorState is what is written to a log monitor's StateFile. the inode
+// and device tell whether the file at the path is still the one the offset
+// is into, or whether it was rotated while grotto wasn't running.
+type logMonitorState struct {
+	Inode  uint64
+	Device uint64
+	Offset int64
+}
+
+// logMonitorCollector follows a log file like tail -F and counts the lines
+// matching a regex in each period. with a StateFile it resumes from where the
+// last run left off, otherwise it starts from the end of the file so that
+// restarting grotto doesn't count lines again. it picks up the new file when
+// the log is rotated or starts over when it is truncated.
+type logMonitorCollector struct {
+	conf    *logMonitorConfig
+	file    *os.File
+	offset  int64
+	partial []byte // the start of a line that hasn't been finished yet
+	started bool   // whether we've started following the file
+	buf     []byte
+}
+
+func newLogMonitorCollector(conf *logMonitorConfig) *logMonitorCollector {
+	return &logMonitorCollector{conf: conf, buf: make([]byte, 32*1024)}
+}
+
+func (c *logMonitorCollector) name() string {
+	return "logmonitor-" + c.conf.Name
+}
+
+func (c *logMonitorCollector) period() time.Duration {
+	if c.conf.PeriodSeconds > 0 {
+		return seconds(c.conf.PeriodSeconds)
+	}
+	return seconds(conf.Librato.PeriodSeconds)
+}
+
+func (c *logMonitorCollector) backend() string {
+	return c.conf.Backend
+}
+
+func (c *logMonitorCollector) source() string {
+	return c.conf.Path
+}
+
+func (c *logMonitorCollector) collect() ([]interface{}, error) {
+	count, err := c.follow()
+	if err != nil {
+		return nil, err
+	}
+	if c.conf.StateFile != "" && c.file != nil {
+		if err := c.saveState(); err != nil {
+			limitedf("Could not save the state of log monitor %s: %s\n", c.conf.Name, err)
+		}
+	}
+	return []interface{}{
+		gauge{Name: c.conf.Name + "-per-period", MeasureTime: measureTime(time.Now()), Value: float64(count), Source: hostname},
+	}, nil
+}
+
+func (c *logMonitorCollector) describe() []string {
+	return []string{c.conf.Name + "-per-period"}
+}
+
+// follow counts the matching lines written since it was last called. when the
+// path has been moved aside for a new file, what is left of the old one is
+// read before switching over.
+func (c *logMonitorCollector) follow() (int, error) {
+	count := 0
+	if c.file != nil {
+		current, err := os.Stat(c.conf.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		open, err := c.file.Stat()
+		if err != nil {
+			return 0, err
+		}
+		if current != nil && !os.SameFile(current, open) {
+			// rotated. finish off the old file and move on to the new one
+			if count, err = c.read(); err != nil {
+				return 0, err
+			}
+			c.file.Close()
+			c.file, c.offset, c.partial = nil, 0, nil
+		} else if open.Size() < c.offset {
+			// truncated, so start over from the top
+			c.offset, c.partial = 0, nil
+		}
+	}
+	if c.file == nil {
+		file, err := os.Open(c.conf.Path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// in between the old file going and the new one showing up,
+				// or it hasn't been written yet. either way, once it is there
+				// every line in it is new.
+				c.started = true
+				return count, nil
+			}
+			return 0, err
+		}
+		if !c.started {
+			if c.offset, err = c.startingOffset(file); err != nil {
+				file.Close()
+				return 0, err
+			}
+		}
+		c.file, c.started = file, true
+	}
+	read, err := c.read()
+	return count + read, err
+}
+
+// read counts the matching lines between the offset and the end of the file
+func (c *logMonitorCollector) read() (int, error) {
+	count := 0
+	for {
+		n, err := c.file.ReadAt(c.buf, c.offset)
+		c.offset += int64(n)
+		data := append(c.partial, c.buf[:n]...)
+		for {
+			end := bytes.IndexByte(data, '\n')
+			if end < 0 {
+				break
+			}
+			if c.conf.regex.Match(data[:end]) {
+				count++
+			}
+			data = data[end+1:]
+		}
+		c.partial = append(c.partial[:0], data...)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+// startingOffset is where to start reading file from when grotto starts. that
+// is where the last run left off if the StateFile says it was reading this
+// same file, the top if the file has been rotated or truncated since, since
+// every line in it is new, and otherwise the end so that lines aren't counted
+// again.
+func (c *logMonitorCollector) startingOffset(file *os.File) (int64, error) {
+	state, err := c.loadState()
+	if err != nil {
+		fmt.Printf("Could not load the state of log monitor %s, starting from the end: %s\n", c.conf.Name, err)
+	}
+	if state != nil {
+		info, err := file.Stat()
+		if err != nil {
+			return 0, err
+		}
+		inode, device := fileIdentity(info)
+		if inode == state.Inode && device == state.Device && state.Offset <= info.Size() {
+			return state.Offset, nil
+		}
+		return 0, nil
+	}
+	return file.Seek(0, io.SeekEnd)
+}
+
+// loadState reads the StateFile, returning nothing if there isn't one
+func (c *logMonitorCollector) loadState() (*logMonitorState, error) {
+	if c.conf.StateFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(c.conf.StateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var state logMonitorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// saveState writes the inode and device of the file being followed and the
+// offset of the end of its last whole line to the StateFile
+func (c *logMonitorCollector) saveState() error {
+	info, err := c.file.Stat()
+	if err != nil {
+		return err
+	}
+	inode, device := fileIdentity(info)
+	data, err := json.Marshal(logMonitorState{Inode: inode, Device: device, Offset: c.offset - int64(len(c.partial))})
+	if err != nil {
+		return err
+	}
+	// write it next to where it goes and move it into place so that
+	// stopping halfway through doesn't leave half a file behind
+	tmp := c.conf.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.conf.StateFile)
+}
+
+// fileIdentity returns the inode and device of a file
+func fileIdentity(info os.FileInfo) (uint64, uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(stat.Ino), uint64(stat.Dev)
+}
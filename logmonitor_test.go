@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// appendLines appends to the file at path, creating it if need be
+func appendLines(t *testing.T, path, lines string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(lines); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// collectCount returns the count from a reading of a log monitor
+func collectCount(t *testing.T, c *logMonitorCollector) float64 {
+	t.Helper()
+	metrics, err := c.collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metrics[0].(gauge).Value
+}
+
+func TestLogMonitorResumesFromState(t *testing.T) {
+	useConfig(t, `{}`)
+	dir := t.TempDir()
+	monitor := &logMonitorConfig{
+		Name:      "errors",
+		Path:      filepath.Join(dir, "app.log"),
+		StateFile: filepath.Join(dir, "app.state"),
+		regex:     regexp.MustCompile("ERROR"),
+	}
+	appendLines(t, monitor.Path, "ERROR before grotto ever ran\n")
+
+	// the first run has no state, so it starts from the end
+	first := newLogMonitorCollector(monitor)
+	if count := collectCount(t, first); count != 0 {
+		t.Errorf("Expected lines from before the first run to be skipped, got %v", count)
+	}
+	appendLines(t, monitor.Path, "ERROR one\nINFO fine\nERROR two\nERROR unfinis")
+	if count := collectCount(t, first); count != 2 {
+		t.Errorf("Expected 2 matching lines, got %v", count)
+	}
+
+	// lines written while grotto was restarting are counted by the next run,
+	// including the one that was only partly written
+	appendLines(t, monitor.Path, "hed\nERROR three\n")
+	second := newLogMonitorCollector(monitor)
+	if count := collectCount(t, second); count != 2 {
+		t.Errorf("Expected 2 matching lines after resuming, got %v", count)
+	}
+
+	// a log rotated while grotto wasn't running is read from the top
+	if err := os.Rename(monitor.Path, monitor.Path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, monitor.Path, "ERROR new file\n")
+	third := newLogMonitorCollector(monitor)
+	if count := collectCount(t, third); count != 1 {
+		t.Errorf("Expected 1 matching line in the rotated log, got %v", count)
+	}
+}
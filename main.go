@@ -8,11 +8,17 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"reflect"
 	"regexp"
+	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -20,10 +26,16 @@ var (
 	conf       *config
 	httpClient http.Client
 	hostname   string
+	// where metrics can be sent, keyed by their name in conf.Backends. the
+	// primary backend is under the empty name.
+	backends map[string]backend
 )
 
 func main() {
-	var confFlag = flag.String("conf", "grotto.conf", "the config file")
+	var confFlag = flag.String("conf", "grotto.conf", "the config file, or - to read it from stdin. several can be given separated by commas")
+	var listMetricsFlag = flag.Bool("list-metrics", false, "print the names of the metrics that would be sent and exit")
+	var oneshotFlag = flag.Bool("oneshot", false, "collect once, send what was collected and exit")
+	var testSendFlag = flag.Bool("test-send", false, "send a single grotto-test gauge through the primary backend, report how it went and exit")
 	var err error
 	flag.Parse()
 
@@ -33,38 +45,205 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *listMetricsFlag {
+		for _, c := range enabledCollectors() {
+			for _, name := range c.describe() {
+				fmt.Println(name)
+			}
+		}
+		return
+	}
+
+	httpClient = newHttpClient()
+	backends, err = newBackends()
+	if err != nil {
+		fmt.Printf("Could not set up backends: %s\n", err)
+		os.Exit(1)
+	}
+
 	hostname, err = os.Hostname()
 	if err != nil {
 		fmt.Printf("Could not read hostname: %s\n", err)
 		os.Exit(1)
 	}
 
-	metrics := startMetricsSender()
-	monitorCpuUsage(metrics)
+	for _, source := range conf.Librato.Sources {
+		conf.Librato.sources = append(conf.Librato.sources, strings.Replace(source, "$hostname", hostname, -1))
+	}
+
+	if *testSendFlag {
+		if !testSend() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	collectors := enabledCollectors()
+	if err := checkCollectors(collectors); err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
 
-	var quit chan bool
-	<-quit
+	if *oneshotFlag {
+		runOneshot(collectors)
+		return
+	}
+
+	if conf.Librato.Adaptive {
+		sendPeriod = newAdaptivePeriod(seconds(conf.Librato.PeriodSeconds), seconds(conf.Librato.MaxPeriodSeconds))
+	}
+
+	// SIGUSR1 sends whatever has been collected so far right away
+	flushRequests := make(chan os.Signal, 1)
+	signal.Notify(flushRequests, syscall.SIGUSR1)
+	drainRequests := make(chan chan struct{})
+	senderStops := make(chan chan struct{})
+	metrics := startMetricsSender(flushRequests, drainRequests, senderStops)
+	var sched *scheduler
+	startCollectingAfter(seconds(conf.StartupDelaySeconds), func() {
+		sched = startCollectors(collectors, metrics)
+		if os.Getenv(reloadedEnv) != "" {
+			annotate(fmt.Sprintf("grotto reloaded its config on %s", hostname))
+		} else {
+			annotate(fmt.Sprintf("grotto started on %s", hostname))
+		}
+	})
+
+	// run until we're told to stop or reload, then give collectors a chance
+	// to clean up. SIGHUP reloads the config, see debounceReloads.
+	stopRequests := make(chan os.Signal, 1)
+	signal.Notify(stopRequests, syscall.SIGINT, syscall.SIGTERM)
+	reloadRequests := make(chan os.Signal, 1)
+	signal.Notify(reloadRequests, syscall.SIGHUP)
+	reload := false
+	select {
+	case <-stopRequests:
+	case <-debounceReloads(reloadRequests, *confFlag):
+		reload = true
+	}
+	sched.stop()
+	for _, c := range collectors {
+		if s, ok := c.(stopper); ok {
+			s.stop()
+		}
+	}
+	if reload {
+		// the new grotto starts with nothing, so send what has been
+		// collected and wait for it to go out before replacing this one
+		drained := make(chan struct{})
+		drainRequests <- drained
+		<-drained
+	}
+	stopped := make(chan struct{})
+	senderStops <- stopped
+	<-stopped
+	stopBackends()
+	if reload {
+		fmt.Printf("Reloading config\n")
+		if err := reexec(); err != nil {
+			fmt.Printf("Could not reload: %s\n", err)
+			os.Exit(1)
+		}
+	}
 }
 
+// startCollectingAfter calls start once delay has passed, see
+// conf.StartupDelaySeconds. holding off on collecting keeps hosts deployed
+// together from all reporting at the same moment.
+func startCollectingAfter(delay time.Duration, start func()) {
+	if delay > 0 {
+		startupSleep(delay)
+	}
+	start()
+}
+
+// startupSleep waits out the startup delay
+var startupSleep = time.Sleep
+
 // the main struct we'll be sending to Librato
 type libratoPayload struct {
-	Gauges []gauge `json:"gauges"`
+	Gauges   []gauge   `json:"gauges"`
+	Counters []counter `json:"counters,omitempty"`
+	created  time.Time
+	// where each metric lives in Gauges or Counters, for deduplication
+	gaugeIndex   map[metricKey]int
+	counterIndex map[metricKey]int
+}
+
+// metricKey identifies a single measurement of a metric
+type metricKey struct {
+	name        string
+	source      string
+	measureTime int64
+}
+
+func newLibratoPayload() *libratoPayload {
+	return &libratoPayload{
+		created:      time.Now(),
+		gaugeIndex:   make(map[metricKey]int),
+		counterIndex: make(map[metricKey]int),
+	}
 }
 
 // libratoPayload adds a metric to its internal state. it returns an
-// error if it does not know what to do with the metric
+// error if it does not know what to do with the metric. when conf.Librato.Dedupe
+// is set a metric replaces any earlier one with the same name, source and
+// measure time rather than being sent alongside it.
 func (p *libratoPayload) addMetric(metric interface{}) error {
 	switch metric := metric.(type) {
 	default:
 		return fmt.Errorf("Unsupported metric: %s", reflect.TypeOf(metric))
 	case gauge:
+		key := metricKey{metric.Name, metric.Source, metric.MeasureTime}
+		if i, ok := p.gaugeIndex[key]; ok && conf.Librato.Dedupe {
+			p.Gauges[i] = metric
+			return nil
+		}
+		p.gaugeIndex[key] = len(p.Gauges)
 		p.Gauges = append(p.Gauges, metric)
+	case counter:
+		key := metricKey{metric.Name, metric.Source, metric.MeasureTime}
+		if i, ok := p.counterIndex[key]; ok && conf.Librato.Dedupe {
+			p.Counters[i] = metric
+			return nil
+		}
+		p.counterIndex[key] = len(p.Counters)
+		p.Counters = append(p.Counters, metric)
 	}
 	return nil
 }
 
+// take moves up to n of the payload's metrics, gauges first, into a payload
+// of their own
+func (p *libratoPayload) take(n int) *libratoPayload {
+	taken, rest := newLibratoPayload(), newLibratoPayload()
+	taken.created, rest.created = p.created, p.created
+	add := func(metric interface{}) {
+		if taken.size() < n {
+			taken.addMetric(metric)
+		} else {
+			rest.addMetric(metric)
+		}
+	}
+	for _, g := range p.Gauges {
+		add(g)
+	}
+	for _, c := range p.Counters {
+		add(c)
+	}
+	*p = *rest
+	return taken
+}
+
 func (p *libratoPayload) size() int {
-	return len(p.Gauges)
+	return len(p.Gauges) + len(p.Counters)
+}
+
+// stale reports whether the payload is older than conf.Librato.MaxPayloadAgeSeconds
+// and should no longer be sent
+func (p *libratoPayload) stale() bool {
+	maxAge := seconds(conf.Librato.MaxPayloadAgeSeconds)
+	return maxAge > 0 && time.Since(p.created) > maxAge
 }
 
 // a gauge is a one-off reading that is sent to Librato
@@ -72,29 +251,322 @@ type gauge struct {
 	Name        string  `json:"name"`
 	Description string  `json:"description,omitempty"`
 	DisplayName string  `json:"display_name,omitempty"`
-	MeasureTime int64   `json:"measure_time"` // epoch seconds
+	MeasureTime int64   `json:"measure_time"` // see measureTime
 	Value       float64 `json:"value"`
 	Source      string  `json:"source,omitempty"`
+	context     *emitContext
+}
+
+// a counter is an ever-increasing value that Librato turns into a rate
+type counter struct {
+	Name        string `json:"name"`
+	MeasureTime int64  `json:"measure_time"` // see measureTime
+	Value       int64  `json:"value"`
+	Source      string `json:"source,omitempty"`
+	context     *emitContext
+}
+
+// measureTime converts t into the MeasureTime of a metric. this is epoch
+// seconds, which is what Librato expects, unless conf.TimestampUnit is "ms"
+func measureTime(t time.Time) int64 {
+	if conf.TimestampUnit == "ms" {
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	return t.Unix()
+}
+
+// MarshalJSON writes the value in plain decimal notation when
+// conf.Librato.FixedPointFloats is set, since some consumers choke on
+// exponents like 1e-7. NaN and infinite values are an error either way, as
+// JSON has no way to write them, see libratoPayload.sanitize.
+func (g gauge) MarshalJSON() ([]byte, error) {
+	type plainGauge gauge
+	if conf == nil || !conf.Librato.FixedPointFloats {
+		return json.Marshal(plainGauge(g))
+	}
+	if math.IsNaN(g.Value) || math.IsInf(g.Value, 0) {
+		return nil, fmt.Errorf("Could not encode %s: %v can't be written as JSON", g.Name, g.Value)
+	}
+	return json.Marshal(struct {
+		plainGauge
+		Value json.RawMessage `json:"value"`
+	}{plainGauge(g), fixedPointFloat(g.Value)})
+}
+
+// fixedPointFloat formats v for JSON without an exponent
+func fixedPointFloat(v float64) json.RawMessage {
+	return json.RawMessage(strconv.FormatFloat(v, 'f', -1, 64))
 }
 
 // the global config struct.
 type config struct {
-	Librato struct {
-		Email         string
-		Token         string
-		Url           string
-		PeriodSeconds int
+	StartupDelaySeconds flexInt
+	WarmupImmediate     bool
+	LogLevel            string
+	AllowNoCollectors   bool
+	// how many collectors may be reading at once, or 0 for no limit
+	CollectorConcurrency int
+	// how many processes or mounts a collector may look at at once, see
+	// scanAll. defaults to GOMAXPROCS.
+	ScanConcurrency int
+	// how long the config has to be left alone after a SIGHUP before it is
+	// reloaded
+	ReloadDebounceMs int
+	// how often the same send error may be logged, see limitedf
+	ErrorLogIntervalSeconds flexInt
+	// see liveness
+	CollectorLiveness bool
+	// key=value pairs sent with everything, see readEnvironmentFile
+	EnvironmentFile string
+	environment     map[string]string
+	// see checkPeriods
+	MinPeriodSeconds flexInt
+	StrictPeriods    bool
+	// see clockSkew
+	TimeSkewWarnSeconds flexInt
+	TimestampUnit       string
+	Librato             struct {
+		Email                  string
+		Token                  string
+		Url                    string
+		PeriodSeconds          flexInt
+		DnsCacheSeconds        flexInt
+		FixedPointFloats       bool
+		MaxPayloadAgeSeconds   flexInt
+		Dedupe                 bool
+		DeadLetterUrl          string
+		ApiVersion             string
+		MaxBatchSize           int
+		HighWatermark          int
+		LowWatermark           int
+		ProxyUrl               string
+		proxyUrl               *url.URL
+		Adaptive               bool
+		MaxPeriodSeconds       flexInt
+		IdleConnTimeoutSeconds flexInt
+		DisableKeepAlives      bool
+		Sources                []string
+		sources                []string
+		IncludeProvenance      bool
+		Annotations            bool
+		ShardByHostname        bool
+		FailureThreshold       int
+		CircuitCooldownSeconds flexInt
+		NormalizeNames         bool
+		SlowPeriodSeconds      flexInt
+		SpoolDir               string
+		SpoolMaxBytes          int64
+		SpoolCompress          bool
+		MinSuccessRate         float64
+		SuccessWindowSeconds   flexInt
+		PauseSeconds           flexInt
+		ClampMin               *float64
+		ClampMax               *float64
+	}
+	Backends     map[string]*backendConfig
+	Filter       metricFilter
+	Thresholds   map[string]*threshold
+	StaticGauges map[string]float64
+	Smoothing    map[string]float64
+	Rates        []string
+	rates        []*regexp.Regexp
+	// put in front of the name of every metric
+	MetricPrefix string
+	// the names of the transforms the metrics from each reading go through,
+	// in order. see newPipeline.
+	Transforms []string
+	transforms pipeline
+	// see changeSuppressor
+	ChangeThreshold    *float64
+	MaxSuppressPeriods int
+	PortMonitors       []int
+	CompactEmit        []string
+	UnixStats          []*unixStatsConfig
+	LogMonitors        []*logMonitorConfig
+	thresholds         []*threshold
+	// told about every successful flush, see notifyWebhook
+	Webhook struct {
+		Url string
+	}
+	Dogstatsd struct {
+		Addr string
+		Tags map[string]string
+	}
+	// see fileBackend
+	FileOutput struct {
+		Path        string
+		MaxBytes    int64
+		SyncSeconds flexInt
 	}
 	Cpu struct {
-		PeriodSeconds int
+		Disabled      bool
+		PeriodSeconds flexInt
+		Backend       string
 		PerCoreGauges bool
+		// leaves out the cpu-total gauges for the aggregate cpu line, for
+		// those who only want the cores
+		SkipAggregate       bool
+		EmitRawCounters     bool
+		EmitSummary         bool
+		EmitCount           bool
+		EmitIowaitAggregate bool
+		AlignTimestamps     bool
+		PersistBaselineFile string
+		// where cpu stats are read from, so that a fixture can stand in for
+		// /proc/stat where there isn't one
+		StatPath string
+	}
+	Memory struct {
+		PeriodSeconds flexInt
+		Backend       string
+		CgroupRoot    string
+		SwapDevices   bool
+	}
+	Procs struct {
+		PeriodSeconds flexInt
+		Backend       string
+		Sockets       []string
+	}
+	Inotify struct {
+		PeriodSeconds flexInt
+		Backend       string
+		ScanUsage     bool
+	}
+	KernelThreads struct {
+		PeriodSeconds flexInt
+		Backend       string
+	}
+	Release struct {
+		PeriodSeconds flexInt
+		Backend       string
+	}
+	SchedStat struct {
+		PeriodSeconds flexInt
+		Backend       string
+	}
+	SockStat struct {
+		PeriodSeconds flexInt
+		Backend       string
+	}
+	NetStat struct {
+		PeriodSeconds flexInt
+		Backend       string
+	}
+	Connections struct {
+		PeriodSeconds flexInt
+		Backend       string
+		TopN          int
+		Resolve       bool
+	}
+	Conntrack struct {
+		PeriodSeconds flexInt
+		Backend       string
+		// where nf_conntrack_count and nf_conntrack_max are
+		Dir string
+	}
+	Disk struct {
+		PeriodSeconds  flexInt
+		Backend        string
+		Mounts         []string
+		HistorySamples int
+		SourcePerMount bool
+		MountsPath     string
+		SysfsRoot      string
+	}
+	BlockQueue struct {
+		PeriodSeconds flexInt
+		Backend       string
+		SysfsRoot     string
+	}
+	DiskStats struct {
+		PeriodSeconds flexInt
+		Backend       string
+	}
+	Gpu struct {
+		PeriodSeconds flexInt
+		Backend       string
+	}
+	HugePages struct {
+		PeriodSeconds flexInt
+		Backend       string
+	}
+	Numa struct {
+		PeriodSeconds flexInt
+		Backend       string
+		SysfsRoot     string
+	}
+	Softirqs struct {
+		PeriodSeconds flexInt
+		Backend       string
+	}
+	Vmstat struct {
+		PeriodSeconds flexInt
+		Backend       string
+	}
+	CoreTemp struct {
+		PeriodSeconds flexInt
+		Backend       string
+		SysfsRoot     string
+	}
+	Battery struct {
+		PeriodSeconds flexInt
+		Backend       string
+		SysfsRoot     string
+	}
+	CgroupCpu struct {
+		PeriodSeconds flexInt
+		Backend       string
+		CgroupRoot    string
+	}
+	// the ports themselves are in PortMonitors. PeriodSeconds defaults to
+	// conf.Librato.PeriodSeconds
+	Ports struct {
+		PeriodSeconds flexInt
+		Backend       string
+		EmitZero      bool
+	}
+	Kmsg struct {
+		PeriodSeconds flexInt
+		Backend       string
+		Path          string
 	}
 }
 
+// flexInt is an int in the config that may also be given as a string, as in
+// "PeriodSeconds": "5", which is what some templating tools produce
+type flexInt int
+
+func (i *flexInt) UnmarshalJSON(data []byte) error {
+	var value int
+	if err := json.Unmarshal(data, &value); err == nil {
+		*i = flexInt(value)
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("Could not parse %s as an int or string", data)
+	}
+	value, err := atoi(strings.TrimSpace(str))
+	if err != nil {
+		return err
+	}
+	*i = flexInt(value)
+	return nil
+}
+
 // readConfig reads the global config for the agent and also checks to make
-// sure required fields are present
-func readConfig(loc string) (*config, error) {
-	contents, err := ioutil.ReadFile(loc)
+// sure required fields are present. locs is a comma separated list of files
+// that are merged in order, and a loc of "-" reads from stdin.
+func readConfig(locs string) (*config, error) {
+	merged := make(map[string]interface{})
+	for _, loc := range strings.Split(locs, ",") {
+		values, err := readConfigValues(strings.TrimSpace(loc))
+		if err != nil {
+			return nil, err
+		}
+		mergeConfigValues(merged, values)
+	}
+	contents, err := json.Marshal(merged)
 	if err != nil {
 		return nil, err
 	}
@@ -103,80 +575,449 @@ func readConfig(loc string) (*config, error) {
 	if err != nil {
 		return nil, err
 	}
-	if conf.Librato.Token == "" {
-		return nil, errors.New("Missing an API token for Librato")
+	// the periods as they were given, before any defaults, see checkPeriods
+	raw := conf
+	if conf.Dogstatsd.Addr == "" && conf.FileOutput.Path == "" {
+		if conf.Librato.Token == "" {
+			return nil, errors.New("Missing an API token for Librato")
+		}
+		if conf.Librato.Email == "" {
+			return nil, errors.New("Missing Email address for Librato")
+		}
+		if conf.Librato.Url == "" {
+			return nil, errors.New("Missing Url for Librato")
+		}
+	}
+	if conf.ReloadDebounceMs <= 0 {
+		conf.ReloadDebounceMs = 500
 	}
-	if conf.Librato.Email == "" {
-		return nil, errors.New("Missing Email address for Librato")
+	if conf.ScanConcurrency <= 0 {
+		conf.ScanConcurrency = runtime.GOMAXPROCS(0)
 	}
-	if conf.Librato.Url == "" {
-		return nil, errors.New("Missing Url for Librato")
+	if conf.ErrorLogIntervalSeconds <= 0 {
+		conf.ErrorLogIntervalSeconds = 60
 	}
 	if conf.Librato.PeriodSeconds <= 0 {
 		fmt.Printf("Using default value of 5 for conf.Librato.PeriodSeconds\n")
 		conf.Librato.PeriodSeconds = 5
 	}
+	if conf.Librato.FailureThreshold > 0 && conf.Librato.CircuitCooldownSeconds <= 0 {
+		fmt.Printf("Using default value of 60 for conf.Librato.CircuitCooldownSeconds\n")
+		conf.Librato.CircuitCooldownSeconds = 60
+	}
+	if conf.Librato.MinSuccessRate > 0 {
+		if conf.Librato.MinSuccessRate > 1 {
+			return nil, errors.New("conf.Librato.MinSuccessRate must be at most 1")
+		}
+		if conf.Librato.SuccessWindowSeconds <= 0 {
+			fmt.Printf("Using default value of 300 for conf.Librato.SuccessWindowSeconds\n")
+			conf.Librato.SuccessWindowSeconds = 300
+		}
+		if conf.Librato.PauseSeconds <= 0 {
+			fmt.Printf("Using default value of 300 for conf.Librato.PauseSeconds\n")
+			conf.Librato.PauseSeconds = 300
+		}
+	}
+	if conf.Librato.Adaptive && conf.Librato.MaxPeriodSeconds < conf.Librato.PeriodSeconds {
+		fmt.Printf("Using default value of %d for conf.Librato.MaxPeriodSeconds\n", 4*conf.Librato.PeriodSeconds)
+		conf.Librato.MaxPeriodSeconds = 4 * conf.Librato.PeriodSeconds
+	}
+	if conf.Librato.HighWatermark > 0 && conf.Librato.LowWatermark <= 0 {
+		fmt.Printf("Using default value of %d for conf.Librato.LowWatermark\n", conf.Librato.HighWatermark/2)
+		conf.Librato.LowWatermark = conf.Librato.HighWatermark / 2
+	}
+	if conf.Librato.HighWatermark > 0 && conf.Librato.LowWatermark >= conf.Librato.HighWatermark {
+		return nil, errors.New("conf.Librato.LowWatermark must be less than conf.Librato.HighWatermark")
+	}
+	switch conf.Librato.ApiVersion {
+	case "":
+		conf.Librato.ApiVersion = "sd"
+	case "sd", "tags":
+	default:
+		return nil, fmt.Errorf("Unknown conf.Librato.ApiVersion %q, expected sd or tags", conf.Librato.ApiVersion)
+	}
+	if conf.Librato.ProxyUrl != "" {
+		proxyUrl, err := url.Parse(conf.Librato.ProxyUrl)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid conf.Librato.ProxyUrl: %s", err)
+		}
+		if proxyUrl.Scheme != "http" && proxyUrl.Scheme != "https" {
+			return nil, fmt.Errorf("conf.Librato.ProxyUrl must be an http or https url")
+		}
+		conf.Librato.proxyUrl = proxyUrl
+	}
+	if err := conf.Filter.compile(); err != nil {
+		return nil, err
+	}
+	if conf.thresholds, err = compileThresholds(conf.Thresholds); err != nil {
+		return nil, err
+	}
+	if err := checkSmoothing(conf.Smoothing); err != nil {
+		return nil, err
+	}
+	if conf.rates, err = compilePatterns(conf.Rates); err != nil {
+		return nil, err
+	}
+	if conf.transforms, err = newPipeline(&conf); err != nil {
+		return nil, err
+	}
+	if conf.ChangeThreshold != nil && conf.MaxSuppressPeriods <= 0 {
+		fmt.Printf("Using default value of 10 for conf.MaxSuppressPeriods\n")
+		conf.MaxSuppressPeriods = 10
+	}
+	for _, stats := range conf.UnixStats {
+		if stats.NamePrefix == "" || stats.SocketPath == "" || stats.Command == "" {
+			return nil, errors.New("Each entry in conf.UnixStats needs a NamePrefix, SocketPath and Command")
+		}
+	}
+	for _, monitor := range conf.LogMonitors {
+		if monitor.Name == "" || monitor.Path == "" || monitor.Regex == "" {
+			return nil, errors.New("Each entry in conf.LogMonitors needs a Name, Path and Regex")
+		}
+		if monitor.regex, err = regexp.Compile(monitor.Regex); err != nil {
+			return nil, fmt.Errorf("Invalid regex for log monitor %s: %s", monitor.Name, err)
+		}
+	}
+	for _, port := range conf.PortMonitors {
+		if port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("Invalid port %d in conf.PortMonitors", port)
+		}
+	}
+	switch conf.TimestampUnit {
+	case "":
+		conf.TimestampUnit = "s"
+	case "s", "ms":
+	default:
+		return nil, fmt.Errorf("Unknown conf.TimestampUnit %q, expected s or ms", conf.TimestampUnit)
+	}
 	if conf.Cpu.PeriodSeconds <= 0 {
 		fmt.Printf("Using default value of 1 for conf.Cpu.PeriodSeconds\n")
 		conf.Cpu.PeriodSeconds = 1
 	}
+	if conf.Memory.CgroupRoot == "" {
+		conf.Memory.CgroupRoot = "/sys/fs/cgroup"
+	}
+	if conf.CgroupCpu.CgroupRoot == "" {
+		conf.CgroupCpu.CgroupRoot = "/sys/fs/cgroup"
+	}
+	if conf.Cpu.StatPath == "" {
+		conf.Cpu.StatPath = defaultStatPath
+	}
+	if conf.Kmsg.Path == "" {
+		conf.Kmsg.Path = "/dev/kmsg"
+	}
+	if conf.Disk.HistorySamples < minFillSamples {
+		conf.Disk.HistorySamples = 10
+	}
+	if conf.FileOutput.Path != "" && conf.FileOutput.SyncSeconds <= 0 {
+		fmt.Printf("Using default value of 5 for conf.FileOutput.SyncSeconds\n")
+		conf.FileOutput.SyncSeconds = 5
+	}
+	if conf.Connections.PeriodSeconds > 0 && conf.Connections.TopN <= 0 {
+		fmt.Printf("Using default value of 10 for conf.Connections.TopN\n")
+		conf.Connections.TopN = 10
+	}
+	if conf.Conntrack.Dir == "" {
+		conf.Conntrack.Dir = "/proc/sys/net/netfilter"
+	}
+	if conf.Disk.MountsPath == "" {
+		conf.Disk.MountsPath = "/proc/mounts"
+	}
+	if conf.Disk.SysfsRoot == "" {
+		conf.Disk.SysfsRoot = "/sys"
+	}
+	if conf.BlockQueue.SysfsRoot == "" {
+		conf.BlockQueue.SysfsRoot = "/sys"
+	}
+	if conf.Numa.SysfsRoot == "" {
+		conf.Numa.SysfsRoot = "/sys"
+	}
+	if conf.CoreTemp.SysfsRoot == "" {
+		conf.CoreTemp.SysfsRoot = "/sys"
+	}
+	if conf.Battery.SysfsRoot == "" {
+		conf.Battery.SysfsRoot = "/sys"
+	}
+	if conf.EnvironmentFile != "" {
+		if conf.environment, err = readEnvironmentFile(conf.EnvironmentFile); err != nil {
+			return nil, err
+		}
+	}
+	if conf.MinPeriodSeconds <= 0 {
+		conf.MinPeriodSeconds = 1
+	}
+	if err := checkPeriods(&raw, &conf); err != nil {
+		return nil, err
+	}
 	return &conf, nil
 }
 
 // startMetricsSender starts the goroutine that will consume payloads
-// and send them to Librato
-func startMetricsSender() chan interface{} {
+// and send them to the backends. a payload is sent when conf.Librato.PeriodSeconds
+// has passed or when it holds conf.Librato.MaxBatchSize metrics, whichever
+// comes first, and either one starts the period over. with conf.Librato.Adaptive
+// the period is stretched while the backend is slow, see adaptivePeriod.
+// anything arriving on flushRequests also causes a flush. a channel arriving
+// on drainRequests is closed once everything has been flushed and sent. with
+// conf.Librato.ShardByHostname each period ends at this host's place in it
+// rather than a whole period after the last send, see untilShard.
+// metrics wrapped in a routedMetric are kept in a payload of their own for
+// their backend, and with conf.Librato.SlowPeriodSeconds those from slow
+// collectors are sent on that period instead, see sendTier. with
+// conf.Librato.HighWatermark a burst of metrics is sent as it comes in, see
+// sendTier.drainBurst. a channel sent on drainRequests is closed once
+// everything collected so far has been sent, and one sent on stopRequests is
+// closed once the sender has stopped, leaving anything unsent behind.
+func startMetricsSender(flushRequests <-chan os.Signal, drainRequests, stopRequests <-chan chan struct{}) chan interface{} {
 	metrics := make(chan interface{})
 	go func() {
 		// setup state
-		timeout := time.After(time.Duration(conf.Librato.PeriodSeconds) * time.Second)
-		payload := new(libratoPayload)
+		period := func() time.Duration {
+			if sendPeriod != nil {
+				return sendPeriod.period()
+			}
+			return seconds(conf.Librato.PeriodSeconds)
+		}
+		next := period
+		if conf.Librato.ShardByHostname {
+			next = func() time.Duration {
+				return untilShard(time.Now(), hostname, period())
+			}
+		}
+		fast := newSendTier(next(), next)
+		var slow *sendTier
+		var slowTimer <-chan time.Time
+		if conf.Librato.SlowPeriodSeconds > 0 {
+			slowPeriod := func() time.Duration {
+				return seconds(conf.Librato.SlowPeriodSeconds)
+			}
+			slow = newSendTier(slowPeriod(), slowPeriod)
+			slowTimer = slow.timer.fired()
+		}
 		for {
-			// gather up as many payloads as we can in libratoDelay.
+			// gather up as many payloads as we can in the period.
 			select {
 			case metric := <-metrics:
-				// sweet. put this metric into the payload
-				if err := payload.addMetric(metric); err != nil {
-					fmt.Printf("Could not add metric: %s\n", err)
+				// sweet. put this metric into the payload for its backend,
+				// along with any others that come from it
+				tier := fast
+				if slow != nil && isSlow(metric) {
+					tier = slow
 				}
-			case <-timeout:
-				// pack up and send it out
-				go func(payload *libratoPayload) {
-					if err := sendPayload(payload); err != nil {
-						fmt.Printf("Could not send payload: %s\n", err)
-					}
-				}(payload)
-				timeout = time.After(time.Duration(conf.Librato.PeriodSeconds) * time.Second)
-				payload = new(libratoPayload)
+				payload := addToPayloads(tier.payloads, metric)
+				if conf.Librato.MaxBatchSize > 0 && payload.size() >= conf.Librato.MaxBatchSize {
+					tier.flush()
+				} else {
+					tier.drainBurst()
+				}
+			case <-fast.timer.fired():
+				fast.flush()
+			case <-slowTimer:
+				slow.flush()
+			case <-flushRequests:
+				fast.flush()
+				if slow != nil {
+					slow.flush()
+				}
+			case drained := <-drainRequests:
+				fast.flush()
+				if slow != nil {
+					slow.flush()
+				}
+				inFlight.Wait()
+				close(drained)
+			case stopped := <-stopRequests:
+				fast.timer.Stop()
+				if slow != nil {
+					slow.timer.Stop()
+				}
+				inFlight.Wait()
+				close(stopped)
+				return
 			}
 		}
 	}()
 	return metrics
 }
 
-func sendPayload(payload interface{}) error {
-	data, err := json.Marshal(payload)
+// addToPayloads puts a metric, along with any others that come from it, into
+// the payload for its backend, starting one if there isn't one yet. it
+// returns the payload the metric went into.
+func addToPayloads(payloads map[string]*libratoPayload, metric interface{}) *libratoPayload {
+	destination := ""
+	if routed, ok := metric.(routedMetric); ok {
+		destination, metric = routed.backend, routed.metric
+	}
+	payload, ok := payloads[destination]
+	if !ok {
+		payload = newLibratoPayload()
+		payloads[destination] = payload
+	}
+	for _, metric := range expandMetric(metric) {
+		if err := payload.addMetric(metric); err != nil {
+			fmt.Printf("Could not add metric: %s\n", err)
+		}
+	}
+	return payload
+}
+
+// routedMetric is a metric on its way to a backend other than the primary
+// one, or to the slow tier
+type routedMetric struct {
+	backend string
+	slow    bool
+	metric  interface{}
+}
+
+// expandMetric returns everything that should be sent for a collected metric:
+// the metric copied to every source in conf.Librato.Sources if there are any.
+// the sources of metrics with an emitContext are qualified by it.
+func expandMetric(metric interface{}) []interface{} {
+	sources := conf.Librato.sources
+	copies := make([]interface{}, 0, len(sources)+1)
+	switch m := metric.(type) {
+	case gauge:
+		if len(sources) == 0 {
+			m.Source = m.context.qualify(m.Source)
+			copies = append(copies, m)
+		}
+		for _, source := range sources {
+			m.Source = m.context.qualify(source)
+			copies = append(copies, m)
+		}
+	case counter:
+		if len(sources) == 0 {
+			m.Source = m.context.qualify(m.Source)
+			copies = append(copies, m)
+		}
+		for _, source := range sources {
+			m.Source = m.context.qualify(source)
+			copies = append(copies, m)
+		}
+	default:
+		copies = append(copies, m)
+	}
+	return copies
+}
+
+// flushPayload sends a payload to a backend unless it has become too old to be
+// worth sending
+func flushPayload(b backend, payload *libratoPayload) {
+	if payload.stale() {
+		fmt.Printf("Dropping payload of %d metrics created at %s\n", payload.size(), payload.created.Format(time.RFC3339))
+		return
+	}
+	payload.sanitize()
+	if payload.size() == 0 {
+		return
+	}
+	start := time.Now()
+	err := b.send(payload)
+	elapsed := time.Since(start)
+	if err == nil && (debugEnabled() || conf.Webhook.Url != "") {
+		// the size is of the JSON encoding whatever the backend, which is
+		// close enough to watch throughput by
+		var size int
+		if data, err := json.Marshal(payload); err == nil {
+			size = len(data)
+		}
+		debugf("Flushed %d gauges and %d counters (%d bytes) to %s in %s\n",
+			len(payload.Gauges), len(payload.Counters), size, b.name(), elapsed)
+		if conf.Webhook.Url != "" {
+			notifyWebhook(newFlushSummary(b, payload, size, elapsed))
+		}
+	}
+	if err == errCircuitOpen || err == errDestinationPaused {
+		// this was already logged when the circuit opened or the destination
+		// was paused. the payload wasn't even tried, and sending each one
+		// to the dead-letter url or the spool while the backend is down
+		// would only pile them up there.
+		debugf("Not sending payload to %s: %s\n", b.name(), err)
+		return
+	}
+	if err != nil {
+		limitedf("Could not send payload to %s: %s\n", b.name(), err)
+		if conf.Librato.DeadLetterUrl != "" {
+			if err := sendDeadLetter(b, payload, err); err != nil {
+				limitedf("Could not send payload to dead-letter url: %s\n", err)
+			}
+		}
+		if conf.Librato.SpoolDir != "" {
+			if err := spoolPayload(b, payload); err != nil {
+				limitedf("Could not spool payload: %s\n", err)
+			}
+		}
+	} else if conf.Librato.SpoolDir != "" {
+		// the backend is taking payloads, so send any it missed
+		drainSpool(b)
+	}
+}
+
+// checkCollectors returns an error if there are no collectors, unless
+// conf.AllowNoCollectors is set, since running without any would happily
+// send nothing forever. a collector routed to a backend that doesn't exist
+// is an error too.
+func checkCollectors(collectors []collector) error {
+	if len(collectors) == 0 {
+		if !conf.AllowNoCollectors {
+			return errors.New("No collectors are enabled, set AllowNoCollectors to run anyway")
+		}
+		fmt.Printf("Warning: no collectors are enabled\n")
+	}
+	for _, c := range collectors {
+		if _, ok := backends[c.backend()]; !ok {
+			return fmt.Errorf("The %s collector is routed to unknown backend %s", c.name(), c.backend())
+		}
+	}
+	return nil
+}
+
+// sendPayload posts a payload to Librato. only POSTs that Librato responded to
+// say how fast it is, so with conf.Librato.Adaptive those are the ones that
+// go into the send period, see adaptivePeriod.
+func sendPayload(b *libratoBackend, payload interface{}) error {
+	start := time.Now()
+	status, _, err := postPayload(b, payload)
 	if err != nil {
 		return err
 	}
+	if sendPeriod != nil {
+		sendPeriod.observe(time.Since(start))
+	}
+	if status >= 300 {
+		return fmt.Errorf("Librato responded with %d", status)
+	}
+	return nil
+}
+
+// postPayload posts a payload to Librato and returns the status and body of
+// the response
+func postPayload(b *libratoBackend, payload interface{}) (int, []byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, nil, err
+	}
 	body := bytes.NewReader(data)
-	req, err := http.NewRequest("POST", conf.Librato.Url, body)
+	req, err := http.NewRequest("POST", b.url, body)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
-	credentials := fmt.Sprintf("%s:%s", conf.Librato.Email, conf.Librato.Token)
+	credentials := fmt.Sprintf("%s:%s", b.email, b.token)
 	authorization := fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(credentials)))
 	req.Header.Add("Authorization", authorization)
 	req.Header.Add("Content-Type", "application/json")
+	start := time.Now()
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("Librato responded with %d", resp.StatusCode)
+	libratoClock.observe(resp, start)
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
 	}
-	return nil
+	return resp.StatusCode, respBody, nil
 }
 
 // atoi just is a proxy for strconv.Atoi, but it also returns a helpful error message
@@ -188,6 +1029,41 @@ func atoi(str string) (int, error) {
 	return value, nil
 }
 
+// parseInt64 is like atoi but for values that may not fit in an int, such as
+// byte counts
+func parseInt64(str string) (int64, error) {
+	value, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return value, fmt.Errorf("Could not parse %s to int64", str)
+	}
+	return value, nil
+}
+
+// readFileString reads a file and returns its contents without surrounding
+// whitespace. handy for the many single-value files in /proc and /sys
+func readFileString(loc string) (string, error) {
+	contents, err := ioutil.ReadFile(loc)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// readInt64File reads a file containing a single integer
+func readInt64File(loc string) (int64, error) {
+	str, err := readFileString(loc)
+	if err != nil {
+		return 0, err
+	}
+	return parseInt64(str)
+}
+
+// fileExists reports whether something exists at loc
+func fileExists(loc string) bool {
+	_, err := os.Stat(loc)
+	return err == nil
+}
+
 // split splits a str based on separators of one or more whitespace tokens
 var whitespaceRegexp = regexp.MustCompile("\\s+")
 
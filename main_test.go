@@ -0,0 +1,480 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestMain starts the tests off with the default config, which useConfig
+// puts back at the end of each test
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "grotto")
+	if err != nil {
+		panic(err)
+	}
+	loc := filepath.Join(dir, "grotto.conf")
+	if err := ioutil.WriteFile(loc, []byte(`{"Librato": {"Email": "e", "Token": "t", "Url": "http://127.0.0.1:1/v1/metrics"}}`), 0644); err != nil {
+		panic(err)
+	}
+	if conf, err = readConfig(loc); err != nil {
+		panic(err)
+	}
+	if backends, err = newBackends(); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeLibrato is a Librato endpoint that keeps the payloads posted to it,
+// failing each post with err when it is set
+type fakeLibrato struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []*libratoPayload
+	err      error
+}
+
+func newFakeLibrato(t *testing.T) *fakeLibrato {
+	t.Helper()
+	fake := new(fakeLibrato)
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		err := fake.err
+		fake.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		payload := new(libratoPayload)
+		if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fake.mu.Lock()
+		fake.payloads = append(fake.payloads, payload)
+		fake.mu.Unlock()
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (f *fakeLibrato) sent() []*libratoPayload {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*libratoPayload(nil), f.payloads...)
+}
+
+// fail makes every post to the fake fail with err
+func (f *fakeLibrato) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// gaugeNames returns the names of the gauges in the payloads
+func gaugeNames(payloads ...*libratoPayload) []string {
+	var names []string
+	for _, payload := range payloads {
+		for _, g := range payload.Gauges {
+			names = append(names, g.Name)
+		}
+	}
+	return names
+}
+
+// waitFor polls cond until it is true, failing the test if that takes
+// longer than timeout
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out after %s", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startTestSender starts a sender that is stopped when the test ends, see
+// startMetricsSender
+func startTestSender(t *testing.T, flushRequests <-chan os.Signal, drainRequests <-chan chan struct{}) chan interface{} {
+	stopRequests := make(chan chan struct{})
+	metrics := startMetricsSender(flushRequests, drainRequests, stopRequests)
+	t.Cleanup(func() {
+		stopped := make(chan struct{})
+		stopRequests <- stopped
+		<-stopped
+	})
+	return metrics
+}
+
+// useConfig reads config from JSON, with the defaults readConfig fills in,
+// and makes it the global config for the rest of the test. unless the JSON
+// gives Librato credentials of its own, metrics are sent to a fake Librato,
+// see primary.
+func useConfig(t *testing.T, data string) *config {
+	t.Helper()
+	values := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(data), &values); err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeLibrato(t)
+	librato, ok := values["Librato"].(map[string]interface{})
+	if !ok {
+		librato = make(map[string]interface{})
+		values["Librato"] = librato
+	}
+	for name, value := range map[string]string{"Email": "e", "Token": "t", "Url": fake.URL} {
+		if _, ok := librato[name]; !ok {
+			librato[name] = value
+		}
+	}
+	contents, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := filepath.Join(t.TempDir(), "grotto.conf")
+	if err := ioutil.WriteFile(loc, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := readConfig(loc)
+	if err != nil {
+		t.Fatalf("Could not read config %s: %s", data, err)
+	}
+	oldConf, oldHostname, oldBackends, oldFake := conf, hostname, backends, fakePrimary
+	t.Cleanup(func() {
+		conf, hostname, backends, fakePrimary = oldConf, oldHostname, oldBackends, oldFake
+	})
+	conf, hostname, fakePrimary = c, "test", fake
+	if backends, err = newBackends(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// readTestConfig reads config from JSON, with Librato credentials filled in
+func readTestConfig(t *testing.T, json string) (*config, error) {
+	t.Helper()
+	loc := filepath.Join(t.TempDir(), "grotto.conf")
+	json = `{"Librato": {"Email": "e", "Token": "t", "Url": "http://127.0.0.1:1/v1/metrics"}, ` + json[1:]
+	if err := ioutil.WriteFile(loc, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return readConfig(loc)
+}
+
+// the fake Librato installed by useConfig
+var fakePrimary *fakeLibrato
+
+// primary returns the fake Librato installed by useConfig
+func primary(t *testing.T) *fakeLibrato {
+	t.Helper()
+	if fakePrimary == nil {
+		t.Fatal("There is no fake Librato")
+	}
+	return fakePrimary
+}
+
+// fakeCollector returns readings from a function. with diffs set it is a
+// differencer, and with routed set its metrics go to that backend.
+type fakeCollector struct {
+	label    string
+	every    time.Duration
+	diffs    bool
+	routed   string
+	mu       sync.Mutex
+	readings int
+	read     func(n int) ([]interface{}, error)
+}
+
+func (c *fakeCollector) name() string          { return c.label }
+func (c *fakeCollector) period() time.Duration { return c.every }
+func (c *fakeCollector) backend() string       { return c.routed }
+func (c *fakeCollector) source() string        { return "fake" }
+func (c *fakeCollector) describe() []string    { return []string{c.label} }
+func (c *fakeCollector) differences() bool     { return c.diffs }
+
+func (c *fakeCollector) collect() ([]interface{}, error) {
+	c.mu.Lock()
+	c.readings++
+	n := c.readings
+	c.mu.Unlock()
+	return c.read(n)
+}
+
+func (c *fakeCollector) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readings
+}
+
+// testGauge returns a gauge from host "test"
+func testGauge(name string, value float64) gauge {
+	return gauge{Name: name, MeasureTime: 1, Value: value, Source: "test"}
+}
+
+// gaugeValues returns the values of the gauges in metrics by name
+func gaugeValues(metrics []interface{}) map[string]float64 {
+	values := make(map[string]float64)
+	for _, metric := range metrics {
+		if g, ok := metric.(gauge); ok {
+			values[g.Name] = g.Value
+		}
+	}
+	return values
+}
+
+// captureOutput returns what f logged to stdout
+func captureOutput(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	output := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		output <- string(b)
+	}()
+	f()
+	w.Close()
+	return <-output
+}
+
+func TestEmptyPayloadsAreNotSent(t *testing.T) {
+	useConfig(t, `{}`)
+	fake := primary(t)
+	timers := useFakeTimers(t)
+	metrics := startTestSender(t, make(chan os.Signal), nil)
+	timer := <-timers
+	// a period with nothing in it
+	timer.fire()
+	if sent := fake.sent(); len(sent) != 0 {
+		t.Fatalf("Expected nothing to be sent for an empty period, got %d payloads", len(sent))
+	}
+	metrics <- testGauge("load", 1)
+	timer.fire()
+	waitFor(t, 3*time.Second, func() bool { return len(fake.sent()) > 0 })
+	if sent := fake.sent(); len(sent) != 1 || len(sent[0].Gauges) != 1 {
+		t.Errorf("Expected one payload with one gauge, got %+v", sent)
+	}
+}
+
+func TestStartupDelay(t *testing.T) {
+	var slept []time.Duration
+	old := startupSleep
+	defer func() { startupSleep = old }()
+	startupSleep = func(d time.Duration) { slept = append(slept, d) }
+	started := 0
+	startCollectingAfter(5*time.Second, func() {
+		if len(slept) != 1 {
+			t.Error("Expected collecting to wait for the delay")
+		}
+		started++
+	})
+	if !reflect.DeepEqual(slept, []time.Duration{5 * time.Second}) || started != 1 {
+		t.Errorf("Expected collecting to start after a delay of 5s, slept %v and started %d times", slept, started)
+	}
+	// no delay means no waiting
+	slept = nil
+	startCollectingAfter(0, func() { started++ })
+	if len(slept) != 0 || started != 2 {
+		t.Errorf("Expected collecting to start right away without a delay, slept %v", slept)
+	}
+}
+
+func TestFixedPointFloats(t *testing.T) {
+	for _, fixed := range []bool{false, true} {
+		c := useConfig(t, `{}`)
+		c.Librato.FixedPointFloats = fixed
+		data, err := json.Marshal(testGauge("tiny", 0.0000001))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fixed && (strings.Contains(string(data), "e-") || !strings.Contains(string(data), `"value":0.0000001`)) {
+			t.Errorf("Expected no exponent with FixedPointFloats, got %s", data)
+		}
+		if !fixed && !strings.Contains(string(data), "1e-7") {
+			t.Errorf("Expected the usual encoding without FixedPointFloats, got %s", data)
+		}
+	}
+}
+
+func TestFixedPointFloatsRejectNonNumbers(t *testing.T) {
+	c := useConfig(t, `{}`)
+	c.Librato.FixedPointFloats = true
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if data, err := json.Marshal(testGauge("broken", v)); err == nil {
+			t.Errorf("Expected an error encoding %v, got %s", v, data)
+		}
+	}
+}
+
+func TestStalePayloadsAreDropped(t *testing.T) {
+	c := useConfig(t, `{"Librato": {"MaxPayloadAgeSeconds": 60}}`)
+	fake := primary(t)
+	old := newLibratoPayload()
+	old.addMetric(testGauge("load", 1))
+	old.created = time.Now().Add(-2 * time.Minute)
+	flushPayload(backends[""], old)
+	if sent := fake.sent(); len(sent) != 0 {
+		t.Fatalf("Expected the stale payload to be dropped, got %d sends", len(sent))
+	}
+	fresh := newLibratoPayload()
+	fresh.addMetric(testGauge("load", 1))
+	flushPayload(backends[""], fresh)
+	// without a maximum age nothing is too old
+	c.Librato.MaxPayloadAgeSeconds = 0
+	flushPayload(backends[""], old)
+	if sent := fake.sent(); len(sent) != 2 {
+		t.Errorf("Expected the fresh payload and the old one without a maximum age to be sent, got %d sends", len(sent))
+	}
+}
+
+func TestDedupeKeepsTheLatestGauge(t *testing.T) {
+	for _, dedupe := range []bool{false, true} {
+		c := useConfig(t, `{}`)
+		c.Librato.Dedupe = dedupe
+		payload := newLibratoPayload()
+		payload.addMetric(testGauge("cpu", 1))
+		payload.addMetric(testGauge("cpu", 2))
+		switch {
+		case dedupe && (len(payload.Gauges) != 1 || payload.Gauges[0].Value != 2):
+			t.Errorf("Expected only the latest gauge with Dedupe, got %v", payload.Gauges)
+		case !dedupe && len(payload.Gauges) != 2:
+			t.Errorf("Expected both gauges without Dedupe, got %v", payload.Gauges)
+		}
+	}
+}
+
+func TestSizeAndTimerFlushes(t *testing.T) {
+	useConfig(t, `{"Librato": {"PeriodSeconds": 1, "MaxBatchSize": 3}}`)
+	fake := primary(t)
+	timers := useFakeTimers(t)
+	metrics := startTestSender(t, make(chan os.Signal), nil)
+	timer := <-timers
+	for i := 0; i < 4; i++ {
+		metrics <- testGauge("load", float64(i))
+	}
+	// the first three are flushed for size right away, which starts the
+	// period over
+	waitFor(t, 5*time.Second, func() bool { return len(fake.sent()) > 0 })
+	if sent := fake.sent(); len(sent) != 1 || sent[0].size() != 3 {
+		t.Fatalf("Expected a single flush of 3 metrics for size, got %d payloads", len(sent))
+	}
+	if resets := timer.resets(); !reflect.DeepEqual(resets, []time.Duration{time.Second}) {
+		t.Errorf("Expected the period to be started over, got resets %v", resets)
+	}
+	// and the fourth once that period is up
+	timer.fire()
+	waitFor(t, 5*time.Second, func() bool { return len(fake.sent()) > 1 })
+	if sent := fake.sent(); len(sent) != 2 || sent[1].size() != 1 {
+		t.Fatalf("Expected a second flush of 1 metric on the timer, got %d payloads", len(sent))
+	}
+}
+
+func TestMillisecondTimestamps(t *testing.T) {
+	now := time.Now()
+	useConfig(t, `{}`)
+	inSeconds := measureTime(now)
+	useConfig(t, `{"TimestampUnit": "ms"}`)
+	inMillis := measureTime(now)
+	if inMillis/1000 != inSeconds || inMillis < inSeconds*1000 {
+		t.Errorf("Expected %d ms to be about 1000x %d s", inMillis, inSeconds)
+	}
+	// a cpu reading goes through measureTime too
+	reading := collectCpu(t, newCpuCollector(), statPath(t), "cpu  100 10 50 1000 0\n", "cpu  160 10 80 1150 0\n")[1]
+	if len(reading) == 0 {
+		t.Fatal("Expected cpu gauges from the second reading")
+	}
+	for _, metric := range reading {
+		if g := metric.(gauge); g.MeasureTime < inMillis {
+			t.Errorf("Expected %s to be measured in ms, got %d", g.Name, g.MeasureTime)
+		}
+	}
+}
+
+func TestFlushRequests(t *testing.T) {
+	useConfig(t, `{"Librato": {"PeriodSeconds": 3600}}`)
+	fake := primary(t)
+	flushRequests := make(chan os.Signal)
+	metrics := startTestSender(t, flushRequests, nil)
+	// flushing repeatedly sends each gauge on its own, long before the period
+	// is up
+	for i := 0; i < 3; i++ {
+		metrics <- testGauge("load", float64(i))
+		flushRequests <- syscall.SIGUSR1
+	}
+	waitFor(t, 5*time.Second, func() bool { return len(fake.sent()) >= 3 })
+	for _, payload := range fake.sent() {
+		if payload.size() != 1 {
+			t.Errorf("Expected each flush to send one gauge, got %d", payload.size())
+		}
+	}
+}
+
+func TestGaugesAreCopiedToEachSource(t *testing.T) {
+	c := useConfig(t, `{"Librato": {"Sources": ["$hostname", "role-web"]}}`)
+	// main fills these in once it knows the hostname
+	c.Librato.sources = []string{"test", "role-web"}
+	payloads := make(map[string]*libratoPayload)
+	addToPayloads(payloads, testGauge("load", 1))
+	payload := addToPayloads(payloads, counter{Name: "ctxt", MeasureTime: 1, Value: 7, Source: "test"})
+	if len(payload.Gauges) != 2 || payload.Gauges[0].Source != "test" || payload.Gauges[1].Source != "role-web" {
+		t.Errorf("Expected the gauge for each source, got %v", payload.Gauges)
+	}
+	if len(payload.Counters) != 2 || payload.Counters[1].Source != "role-web" {
+		t.Errorf("Expected the counter for each source, got %v", payload.Counters)
+	}
+}
+
+func TestNoCollectorsIsAnError(t *testing.T) {
+	c := useConfig(t, `{"Cpu": {"Disabled": true}}`)
+	collectors := enabledCollectors()
+	if len(collectors) != 0 {
+		t.Fatalf("Expected every collector to be disabled, got %d", len(collectors))
+	}
+	if err := checkCollectors(collectors); err == nil {
+		t.Error("Expected an error without any collectors")
+	}
+	c.AllowNoCollectors = true
+	if err := checkCollectors(collectors); err != nil {
+		t.Errorf("Expected AllowNoCollectors to allow it, got %s", err)
+	}
+}
+
+func TestDebugFlushSummary(t *testing.T) {
+	useConfig(t, `{"LogLevel": "debug"}`)
+	fake := new(fakeBackend)
+	payload := newLibratoPayload()
+	payload.addMetric(testGauge("load", 1))
+	payload.addMetric(testGauge("cpu", 2))
+	payload.addMetric(counter{Name: "ctxt", MeasureTime: 1, Value: 7, Source: "test"})
+	output := captureOutput(t, func() { flushPayload(fake, payload) })
+	if !strings.Contains(output, "Flushed 2 gauges and 1 counters (") || !strings.Contains(output, " bytes) to fake in ") {
+		t.Errorf("Expected a one-line flush summary, got %q", output)
+	}
+	if strings.Contains(output, `"name"`) {
+		t.Errorf("Expected the payload itself not to be logged, got %q", output)
+	}
+	conf.LogLevel = ""
+	if output := captureOutput(t, func() { flushPayload(fake, payload) }); output != "" {
+		t.Errorf("Expected nothing to be logged outside of debug, got %q", output)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// a measurement is how a metric is sent to Librato's tagged measurements
+// api, where the source becomes a host tag
+type measurement struct {
+	Name  string            `json:"name"`
+	Value json.RawMessage   `json:"value"`
+	Time  int64             `json:"time"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+// MarshalJSON writes the payload in the legacy {gauges:[...]} form, or as
+// {measurements:[...]} when conf.Librato.ApiVersion is "tags". the legacy
+// form has no tags, so conf.EnvironmentFile goes into the sources instead,
+// see environmentSource.
+func (p *libratoPayload) MarshalJSON() ([]byte, error) {
+	type plainPayload libratoPayload
+	if conf == nil || conf.Librato.ApiVersion != "tags" {
+		if len(environmentTags()) == 0 {
+			return json.Marshal((*plainPayload)(p))
+		}
+		legacy := plainPayload{
+			Gauges:   make([]gauge, len(p.Gauges)),
+			Counters: make([]counter, len(p.Counters)),
+		}
+		for i, g := range p.Gauges {
+			g.Source = environmentSource(g.Source)
+			legacy.Gauges[i] = g
+		}
+		for i, c := range p.Counters {
+			c.Source = environmentSource(c.Source)
+			legacy.Counters[i] = c
+		}
+		return json.Marshal(&legacy)
+	}
+	measurements := make([]measurement, 0, p.size())
+	for _, g := range p.Gauges {
+		value, err := json.Marshal(g.Value)
+		if err != nil {
+			return nil, err
+		}
+		if conf.Librato.FixedPointFloats {
+			value = fixedPointFloat(g.Value)
+		}
+		measurements = append(measurements, newMeasurement(g.Name, value, g.MeasureTime, g.Source, g.context))
+	}
+	for _, c := range p.Counters {
+		value, err := json.Marshal(c.Value)
+		if err != nil {
+			return nil, err
+		}
+		measurements = append(measurements, newMeasurement(c.Name, value, c.MeasureTime, c.Source, c.context))
+	}
+	return json.Marshal(struct {
+		Measurements []measurement `json:"measurements"`
+	}{measurements})
+}
+
+func newMeasurement(name string, value json.RawMessage, measureTime int64, source string, context *emitContext) measurement {
+	m := measurement{Name: name, Value: value, Time: measureTime}
+	addTags := func(tags map[string]string) {
+		for k, v := range tags {
+			if m.Tags == nil {
+				m.Tags = make(map[string]string)
+			}
+			m.Tags[k] = v
+		}
+	}
+	// the more specific tags win
+	addTags(environmentTags())
+	if source != "" {
+		addTags(map[string]string{"host": source})
+	}
+	if context != nil {
+		addTags(context.tags)
+	}
+	return m
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestPayloadSerializations(t *testing.T) {
+	for apiVersion, expected := range map[string]string{
+		"sd": `{"gauges":[{"name":"load","measure_time":1,"value":0.5,"source":"test"}],` +
+			`"counters":[{"name":"ctxt","measure_time":1,"value":7,"source":"test"}]}`,
+		"tags": `{"measurements":[{"name":"load","value":0.5,"time":1,"tags":{"host":"test"}},` +
+			`{"name":"ctxt","value":7,"time":1,"tags":{"host":"test"}}]}`,
+	} {
+		useConfig(t, fmt.Sprintf(`{"Librato": {"ApiVersion": %q}}`, apiVersion))
+		payload := newLibratoPayload()
+		payload.addMetric(testGauge("load", 0.5))
+		payload.addMetric(counter{Name: "ctxt", MeasureTime: 1, Value: 7, Source: "test"})
+		data, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("With ApiVersion %q expected %s, got %s", apiVersion, expected, data)
+		}
+	}
+}
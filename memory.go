@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// memStat holds memory usage in bytes, either for the whole host or for the
+// cgroup grotto is running in
+type memStat struct {
+	name  string
+	used  int64
+	total int64
+	epoch int64
+}
+
+func (s *memStat) usedPercentage() float64 {
+	return float64(s.used) / float64(s.total)
+}
+
+// metrics converts a memStat into a slice of gauges
+func (s *memStat) metrics() []gauge {
+	newGauge := func(name string, value float64) gauge {
+		return gauge{Name: fmt.Sprintf("%s-%s", s.name, name), MeasureTime: s.epoch, Value: value, Source: hostname}
+	}
+	return []gauge{
+		newGauge("used", float64(s.used)),
+		newGauge("total", float64(s.total)),
+		newGauge("used-percent", s.usedPercentage()),
+	}
+}
+
+// memoryCollector reports memory usage. when running inside a memory-limited
+// cgroup the gauges are named cgroup-mem-* and describe the cgroup, otherwise
+// they are named mem-* and describe the host.
+type memoryCollector struct {
+	from string
+}
+
+func (c *memoryCollector) name() string {
+	return "memory"
+}
+
+func (c *memoryCollector) period() time.Duration {
+	return seconds(conf.Memory.PeriodSeconds)
+}
+
+func (c *memoryCollector) backend() string {
+	return conf.Memory.Backend
+}
+
+func (c *memoryCollector) source() string {
+	return c.from
+}
+
+func (c *memoryCollector) collect() ([]interface{}, error) {
+	stat, err := readMemStat()
+	if err != nil {
+		return nil, err
+	}
+	c.from = "/proc/meminfo"
+	if stat.name == "cgroup-mem" {
+		c.from = conf.Memory.CgroupRoot
+	}
+	var metrics []interface{}
+	for _, metric := range stat.metrics() {
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+func (c *memoryCollector) describe() []string {
+	var described []string
+	for _, name := range []string{"mem", "cgroup-mem"} {
+		for _, suffix := range []string{"used", "total", "used-percent"} {
+			described = append(described, fmt.Sprintf("%s-%s", name, suffix))
+		}
+	}
+	return described
+}
+
+// readMemStat prefers the cgroup's view of memory and falls back to
+// /proc/meminfo when there is no cgroup limit in effect
+func readMemStat() (*memStat, error) {
+	host, err := readMeminfo()
+	if err != nil {
+		return nil, err
+	}
+	stat, err := readCgroupMemStat(conf.Memory.CgroupRoot)
+	if err != nil {
+		return nil, err
+	}
+	if stat == nil || stat.total <= 0 || stat.total >= host.total {
+		// no limit, or a limit the host could never hit anyway
+		return host, nil
+	}
+	return stat, nil
+}
+
+// readMeminfo reads the host's memory usage from /proc/meminfo
+func readMeminfo() (*memStat, error) {
+	values, err := readKeyValueFile("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	total, ok := values["MemTotal"]
+	if !ok {
+		return nil, fmt.Errorf("MemTotal missing from /proc/meminfo")
+	}
+	available, ok := values["MemAvailable"]
+	if !ok {
+		// older kernels don't report MemAvailable
+		available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+	return &memStat{
+		name:  "mem",
+		total: total * 1024,
+		used:  (total - available) * 1024,
+		epoch: measureTime(time.Now()),
+	}, nil
+}
+
+// readCgroupMemStat reads usage and limit for the cgroup rooted at root,
+// supporting both the v2 unified hierarchy and the v1 memory controller. it
+// returns nil without an error when there is no cgroup memory accounting.
+// page cache that the kernel could reclaim is not counted as used.
+func readCgroupMemStat(root string) (*memStat, error) {
+	var limitFile, usageFile, statFile, inactiveKey string
+	if fileExists(filepath.Join(root, "memory.max")) {
+		limitFile, usageFile, statFile = "memory.max", "memory.current", "memory.stat"
+		inactiveKey = "inactive_file"
+	} else if fileExists(filepath.Join(root, "memory", "memory.limit_in_bytes")) {
+		root = filepath.Join(root, "memory")
+		limitFile, usageFile, statFile = "memory.limit_in_bytes", "memory.usage_in_bytes", "memory.stat"
+		inactiveKey = "total_inactive_file"
+	} else {
+		return nil, nil
+	}
+	limitString, err := readFileString(filepath.Join(root, limitFile))
+	if err != nil {
+		return nil, err
+	}
+	if limitString == "max" {
+		return nil, nil
+	}
+	limit, err := parseInt64(limitString)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := readInt64File(filepath.Join(root, usageFile))
+	if err != nil {
+		return nil, err
+	}
+	if stats, err := readKeyValueFile(filepath.Join(root, statFile)); err == nil {
+		if inactive := stats[inactiveKey]; inactive < usage {
+			usage -= inactive
+		}
+	}
+	return &memStat{
+		name:  "cgroup-mem",
+		total: limit,
+		used:  usage,
+		epoch: measureTime(time.Now()),
+	}, nil
+}
+
+// readKeyValueFile reads files made up of "key value" or "key: value unit"
+// lines, like /proc/meminfo or a cgroup's memory.stat
+func readKeyValueFile(loc string) (map[string]int64, error) {
+	file, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		tokens := split(strings.TrimSpace(scanner.Text()))
+		if len(tokens) < 2 {
+			continue
+		}
+		value, err := parseInt64(tokens[1])
+		if err != nil {
+			return nil, err
+		}
+		values[strings.TrimSuffix(tokens[0], ":")] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
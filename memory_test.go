@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles writes each of files under root, creating directories as needed
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		loc := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(loc), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(loc, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCgroupMemoryWins(t *testing.T) {
+	for _, test := range []struct {
+		version string
+		files   map[string]string
+	}{
+		{"v2", map[string]string{
+			"memory.max":     "1048576\n",
+			"memory.current": "524288\n",
+			"memory.stat":    "anon 262144\ninactive_file 131072\n",
+		}},
+		{"v1", map[string]string{
+			"memory/memory.limit_in_bytes": "1048576\n",
+			"memory/memory.usage_in_bytes": "524288\n",
+			"memory/memory.stat":           "cache 262144\ntotal_inactive_file 131072\n",
+		}},
+	} {
+		root := t.TempDir()
+		writeFiles(t, root, test.files)
+		useConfig(t, `{"Memory": {"CgroupRoot": "`+root+`"}}`)
+		stat, err := readMemStat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.name != "cgroup-mem" || stat.total != 1048576 || stat.used != 524288-131072 {
+			t.Errorf("Expected the %s cgroup's memory, got %+v", test.version, stat)
+		}
+	}
+}
+
+func TestUnlimitedCgroupUsesHost(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"memory.max": "max\n", "memory.current": "524288\n"})
+	useConfig(t, `{"Memory": {"CgroupRoot": "`+root+`"}}`)
+	stat, err := readMemStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.name != "mem" {
+		t.Errorf("Expected the host's memory without a cgroup limit, got %s", stat.name)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// the longest metric name Librato accepts
+const maxMetricNameLength = 255
+
+// anything Librato doesn't allow in a metric name
+var disallowedNameChars = regexp.MustCompile(`[^A-Za-z0-9.:_-]`)
+
+// names that have already been logged as normalized, so each is only logged
+// once. payloads are flushed concurrently, hence the mutex.
+var (
+	normalizedMu    sync.Mutex
+	normalizedNames = make(map[string]bool)
+)
+
+// normalizeName makes a metric name acceptable to Librato, which otherwise
+// rejects the whole payload, by replacing characters it doesn't allow with
+// underscores and cutting it down to maxMetricNameLength. it is the
+// "normalize" transform.
+func normalizeName(name string) (string, bool) {
+	normalized := disallowedNameChars.ReplaceAllString(name, "_")
+	if len(normalized) > maxMetricNameLength {
+		normalized = normalized[:maxMetricNameLength]
+	}
+	if normalized == name {
+		return name, true
+	}
+	normalizedMu.Lock()
+	defer normalizedMu.Unlock()
+	if !normalizedNames[name] {
+		fmt.Printf("Sending metric %q as %q\n", name, normalized)
+		normalizedNames[name] = true
+	}
+	return normalized, true
+}
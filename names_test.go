@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	if name, _ := normalizeName("disk-/var/lib used"); name != "disk-_var_lib_used" {
+		t.Errorf("Expected slashes and spaces replaced, got %q", name)
+	}
+	if name, _ := normalizeName("cpu-total-usage"); name != "cpu-total-usage" {
+		t.Errorf("Expected an acceptable name untouched, got %q", name)
+	}
+	long := strings.Repeat("a", maxMetricNameLength+10)
+	if name, _ := normalizeName(long); len(name) != maxMetricNameLength {
+		t.Errorf("Expected the name cut to %d, got %d", maxMetricNameLength, len(name))
+	}
+	normalizedNames = make(map[string]bool)
+	output := captureOutput(t, func() {
+		normalizeName("once/logged")
+		normalizeName("once/logged")
+	})
+	if strings.Count(output, "Sending metric") != 1 {
+		t.Errorf("Expected the normalized name logged once, got %q", output)
+	}
+}
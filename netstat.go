@@ -0,0 +1,92 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// tcpCounters maps the gauges reported by the netstat collector to the
+// counters they come from, by file prefix and name
+var tcpCounters = []struct {
+	gauge  string
+	prefix string
+	name   string
+}{
+	{"tcp-retrans-segs-per-sec", "Tcp", "RetransSegs"},
+	{"tcp-out-segs-per-sec", "Tcp", "OutSegs"},
+	{"tcp-in-errs-per-sec", "Tcp", "InErrs"},
+	{"tcp-out-rsts-per-sec", "Tcp", "OutRsts"},
+	{"tcp-lost-retransmit-per-sec", "TcpExt", "TCPLostRetransmit"},
+	{"tcp-timeouts-per-sec", "TcpExt", "TCPTimeouts"},
+}
+
+// netstatCollector reports TCP retransmit and error rates from the counters
+// in /proc/net/snmp and /proc/net/netstat
+type netstatCollector struct {
+	rates counterRates
+}
+
+// the rates need a reading to compare against, see differencer
+func (c *netstatCollector) differences() bool {
+	return true
+}
+
+func (c *netstatCollector) name() string {
+	return "netstat"
+}
+
+func (c *netstatCollector) period() time.Duration {
+	return seconds(conf.NetStat.PeriodSeconds)
+}
+
+func (c *netstatCollector) backend() string {
+	return conf.NetStat.Backend
+}
+
+func (c *netstatCollector) source() string {
+	return "/proc/net/snmp and /proc/net/netstat"
+}
+
+func (c *netstatCollector) collect() ([]interface{}, error) {
+	snmp, err := readProcNetPairs(filepath.Join(procRoot, "net/snmp"), true)
+	if err != nil {
+		return nil, err
+	}
+	netstat, err := readProcNetPairs(filepath.Join(procRoot, "net/netstat"), true)
+	if err != nil {
+		return nil, err
+	}
+	// the prefixes in the two files don't overlap
+	for prefix, values := range netstat {
+		snmp[prefix] = values
+	}
+	counters := make(map[string]int64)
+	for _, counter := range tcpCounters {
+		if value, ok := snmp[counter.prefix][counter.name]; ok {
+			counters[counter.gauge] = value
+		}
+	}
+	now := time.Now()
+	rates := c.rates.update(counters, now)
+	newGauge := func(name string, value float64) gauge {
+		return gauge{Name: name, MeasureTime: measureTime(now), Value: value, Source: hostname}
+	}
+	var metrics []interface{}
+	for _, counter := range tcpCounters {
+		if rate, ok := rates[counter.gauge]; ok {
+			metrics = append(metrics, newGauge(counter.gauge, rate))
+		}
+	}
+	if out := rates["tcp-out-segs-per-sec"]; out > 0 {
+		metrics = append(metrics, newGauge("tcp-retrans-percent", rates["tcp-retrans-segs-per-sec"]/out))
+	}
+	return metrics, nil
+}
+
+func (c *netstatCollector) describe() []string {
+	var described []string
+	for _, counter := range tcpCounters {
+		described = append(described, counter.gauge)
+	}
+	return append(described, "tcp-retrans-percent")
+}
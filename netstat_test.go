@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeNetstat writes /proc/net/snmp and /proc/net/netstat with the given
+// segments sent and retransmitted
+func writeNetstat(t *testing.T, root string, outSegs, retransSegs int) {
+	writeFiles(t, filepath.Join(root, "net"), map[string]string{
+		"snmp": "Ip: Forwarding DefaultTTL\nIp: 1 64\n" +
+			"Tcp: RtoAlgorithm InSegs OutSegs RetransSegs InErrs OutRsts\n" +
+			fmt.Sprintf("Tcp: 1 5000 %d %d 0 3\n", outSegs, retransSegs),
+		"netstat": "TcpExt: SyncookiesSent TCPLostRetransmit TCPTimeouts\nTcpExt: 0 1 2\n",
+	})
+}
+
+func TestRetransmitRate(t *testing.T) {
+	root := useFakeProc(t)
+	useConfig(t, `{}`)
+	c := new(netstatCollector)
+	writeNetstat(t, root, 10000, 100)
+	if _, err := c.collect(); err != nil {
+		t.Fatal(err)
+	}
+	// pretend the first reading was ten seconds ago
+	c.rates.previousTime = c.rates.previousTime.Add(-10 * time.Second)
+	writeNetstat(t, root, 11000, 150)
+	metrics, err := c.collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := gaugeValues(metrics)
+	for name, expected := range map[string]float64{
+		"tcp-retrans-segs-per-sec": 5,
+		"tcp-out-segs-per-sec":     100,
+		"tcp-retrans-percent":      0.05,
+	} {
+		if value, ok := values[name]; !ok || math.Abs(value-expected) > expected/100 {
+			t.Errorf("Expected %s to be %v, got %v", name, expected, value)
+		}
+	}
+}
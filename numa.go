@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// numaCollector reports memory usage and allocation hit/miss rates for each
+// NUMA node. hosts with a single node have nothing to balance, so nothing is
+// reported for them.
+type numaCollector struct {
+	rates counterRates
+}
+
+// the rates need a reading to compare against, see differencer
+func (c *numaCollector) differences() bool {
+	return true
+}
+
+func (c *numaCollector) name() string {
+	return "numa"
+}
+
+func (c *numaCollector) period() time.Duration {
+	return seconds(conf.Numa.PeriodSeconds)
+}
+
+func (c *numaCollector) backend() string {
+	return conf.Numa.Backend
+}
+
+func (c *numaCollector) source() string {
+	return filepath.Join(conf.Numa.SysfsRoot, "devices/system/node")
+}
+
+func (c *numaCollector) collect() ([]interface{}, error) {
+	nodes, err := filepath.Glob(filepath.Join(conf.Numa.SysfsRoot, "devices/system/node/node[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) < 2 {
+		return nil, nil
+	}
+	sort.Strings(nodes)
+	now := time.Now()
+	newGauge := func(name string, value float64) gauge {
+		return gauge{Name: name, MeasureTime: measureTime(now), Value: value, Source: hostname}
+	}
+	var metrics []interface{}
+	counters := make(map[string]int64)
+	for _, dir := range nodes {
+		node := filepath.Base(dir)
+		meminfo, err := readNodeMeminfo(filepath.Join(dir, "meminfo"))
+		if err != nil {
+			return nil, err
+		}
+		free := meminfo["MemFree"] * 1024
+		used := (meminfo["MemTotal"] - meminfo["MemFree"]) * 1024
+		metrics = append(metrics,
+			newGauge(fmt.Sprintf("numa-%s-mem-free-bytes", node), float64(free)),
+			newGauge(fmt.Sprintf("numa-%s-mem-used-bytes", node), float64(used)),
+		)
+		numastat, err := readKeyValueFile(filepath.Join(dir, "numastat"))
+		if err != nil {
+			return nil, err
+		}
+		counters[fmt.Sprintf("numa-%s-hit-per-sec", node)] = numastat["numa_hit"]
+		counters[fmt.Sprintf("numa-%s-miss-per-sec", node)] = numastat["numa_miss"]
+	}
+	for name, rate := range c.rates.update(counters, now) {
+		metrics = append(metrics, newGauge(name, rate))
+	}
+	return metrics, nil
+}
+
+func (c *numaCollector) describe() []string {
+	return []string{
+		"numa-node<N>-mem-free-bytes",
+		"numa-node<N>-mem-used-bytes",
+		"numa-node<N>-hit-per-sec",
+		"numa-node<N>-miss-per-sec",
+	}
+}
+
+// readNodeMeminfo reads a node's meminfo, whose lines look like
+// "Node 0 MemTotal:  16384 kB", returning values in kB
+func readNodeMeminfo(loc string) (map[string]int64, error) {
+	file, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		tokens := strings.Fields(scanner.Text())
+		if len(tokens) < 4 || tokens[0] != "Node" {
+			continue
+		}
+		value, err := parseInt64(tokens[3])
+		if err != nil {
+			return nil, err
+		}
+		values[strings.TrimSuffix(tokens[2], ":")] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
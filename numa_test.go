@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// writeNumaNode writes the meminfo and numastat of a fake NUMA node
+func writeNumaNode(t *testing.T, sysfs string, node int, totalKb, freeKb int) {
+	writeFiles(t, filepath.Join(sysfs, "devices/system/node", fmt.Sprintf("node%d", node)), map[string]string{
+		"meminfo":  fmt.Sprintf("Node %d MemTotal:  %d kB\nNode %d MemFree:   %d kB\n", node, totalKb, node, freeKb),
+		"numastat": "numa_hit 1000\nnuma_miss 10\n",
+	})
+}
+
+func TestNumaNodeGauges(t *testing.T) {
+	sysfs := t.TempDir()
+	useConfig(t, `{"Numa": {"SysfsRoot": "`+sysfs+`"}}`)
+	c := new(numaCollector)
+	writeNumaNode(t, sysfs, 0, 1000, 400)
+	metrics, err := c.collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 0 {
+		t.Errorf("Expected nothing for a single node, got %v", metrics)
+	}
+	writeNumaNode(t, sysfs, 1, 2000, 1500)
+	if metrics, err = c.collect(); err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, metric := range metrics {
+		g := metric.(gauge)
+		values[g.Name] = g.Value
+	}
+	for name, expected := range map[string]float64{
+		"numa-node0-mem-free-bytes": 400 * 1024,
+		"numa-node0-mem-used-bytes": 600 * 1024,
+		"numa-node1-mem-free-bytes": 1500 * 1024,
+		"numa-node1-mem-used-bytes": 500 * 1024,
+	} {
+		if values[name] != expected {
+			t.Errorf("Expected %s to be %v, got %v", name, expected, values[name])
+		}
+	}
+}
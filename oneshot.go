@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// runOneshot takes a single reading from each collector, sends it and
+// returns, for running grotto from cron. collectors that report differences
+// get a second reading a period later, see differencer, and only that one is
+// sent. everything is sent in one payload for each backend.
+func runOneshot(collectors []collector) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var metrics []interface{}
+	for _, c := range collectors {
+		wg.Add(1)
+		go func(c collector) {
+			defer wg.Done()
+			values, err := c.collect()
+			if err == nil && needsSecondReading(c) {
+				time.Sleep(c.period())
+				values, err = c.collect()
+			}
+			if err != nil {
+				fmt.Printf("Could not get %s stats: %v\n", c.name(), err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			metrics = append(metrics, outgoing(c, values)...)
+		}(c)
+	}
+	wg.Wait()
+	payloads := make(map[string]*libratoPayload)
+	for _, metric := range metrics {
+		addToPayloads(payloads, metric)
+	}
+	for name, payload := range payloads {
+		flushPayload(backends[name], payload)
+	}
+	stopBackends()
+}
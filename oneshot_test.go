@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOneshotSendsOnce(t *testing.T) {
+	useConfig(t, `{}`)
+	// like the cpu collector with cpu-count on, the first reading isn't
+	// empty but the usage only comes with the second
+	diffs := &fakeCollector{label: "diffs", every: 10 * time.Millisecond, diffs: true, read: func(n int) ([]interface{}, error) {
+		metrics := []interface{}{testGauge("count", 2)}
+		if n > 1 {
+			metrics = append(metrics, testGauge("usage", 0.5))
+		}
+		return metrics, nil
+	}}
+	plain := &fakeCollector{label: "plain", every: time.Hour, read: func(n int) ([]interface{}, error) {
+		return []interface{}{testGauge("plain", 1)}, nil
+	}}
+	runOneshot([]collector{diffs, plain})
+	sent := primary(t).sent()
+	if len(sent) != 1 {
+		t.Fatalf("Expected one send, got %d", len(sent))
+	}
+	names := map[string]int{}
+	for _, name := range gaugeNames(sent...) {
+		names[name]++
+	}
+	if names["usage"] != 1 || names["count"] != 1 || names["plain"] != 1 {
+		t.Errorf("Expected usage, count and plain once each, got %v", names)
+	}
+	if diffs.count() != 2 || plain.count() != 1 {
+		t.Errorf("Expected 2 readings of diffs and 1 of plain, got %d and %d", diffs.count(), plain.count())
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// the fewest sends in a window that a success rate is judged on
+const minSuccessSamples = 3
+
+var errDestinationPaused = errors.New("destination is paused, not sending")
+
+// successGate pauses sending to a destination that mostly fails, so that it
+// doesn't hold up or flood the logs of the others. it keeps the outcome of
+// every send over the last conf.Librato.SuccessWindowSeconds, and once a whole
+// window has gone by with fewer than conf.Librato.MinSuccessRate of them
+// succeeding, nothing is sent for conf.Librato.PauseSeconds. after that a
+// single payload is let through as a probe, which resumes sending if it
+// succeeds and pauses again if it doesn't. where a circuitBreaker reacts to a
+// run of failures, this catches a destination that fails too often.
+type successGate struct {
+	backend
+	minRate float64
+	window  time.Duration
+	pause   time.Duration
+
+	mu       sync.Mutex
+	outcomes []sendOutcome // oldest first, none older than window
+	since    time.Time     // when the window started filling
+	paused   bool
+	pausedAt time.Time
+	probing  bool
+}
+
+type sendOutcome struct {
+	at time.Time
+	ok bool
+}
+
+func newSuccessGate(b backend) *successGate {
+	return &successGate{
+		backend: b,
+		minRate: conf.Librato.MinSuccessRate,
+		window:  seconds(conf.Librato.SuccessWindowSeconds),
+		pause:   seconds(conf.Librato.PauseSeconds),
+		since:   time.Now(),
+	}
+}
+
+func (g *successGate) send(payload *libratoPayload) error {
+	if !g.allow() {
+		return errDestinationPaused
+	}
+	err := g.backend.send(payload)
+	g.record(err)
+	return err
+}
+
+// allow reports whether a payload may be sent, letting this payload be the
+// probe once a pause is over
+func (g *successGate) allow() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return true
+	}
+	if g.probing || time.Since(g.pausedAt) < g.pause {
+		return false
+	}
+	g.probing = true
+	return true
+}
+
+func (g *successGate) record(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	if g.probing {
+		g.probing = false
+		if err != nil {
+			g.pausedAt = now
+			return
+		}
+		fmt.Printf("Resuming sends to %s\n", g.name())
+		g.paused, g.outcomes, g.since = false, nil, now
+		return
+	}
+	if err == errCircuitOpen {
+		// nothing was sent, so there's nothing to learn
+		return
+	}
+	g.outcomes = append(g.outcomes, sendOutcome{at: now, ok: err == nil})
+	for len(g.outcomes) > 0 && now.Sub(g.outcomes[0].at) > g.window {
+		g.outcomes = g.outcomes[1:]
+	}
+	if now.Sub(g.since) < g.window || len(g.outcomes) < minSuccessSamples {
+		return
+	}
+	if rate := g.rate(); rate < g.minRate {
+		fmt.Printf("Pausing sends to %s for %s, only %.0f%% succeeded in the last %s\n", g.name(), g.pause, rate*100, g.window)
+		g.paused, g.pausedAt = true, now
+	}
+}
+
+// rate returns the share of sends in the window that succeeded, which is 1
+// when there haven't been any. g.mu must be held.
+func (g *successGate) rate() float64 {
+	if len(g.outcomes) == 0 {
+		return 1
+	}
+	ok := 0
+	for _, outcome := range g.outcomes {
+		if outcome.ok {
+			ok++
+		}
+	}
+	return float64(ok) / float64(len(g.outcomes))
+}
+
+// current returns whether the destination is paused and its success rate
+func (g *successGate) current() (bool, float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused, g.rate()
+}
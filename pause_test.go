@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailingDestinationIsPaused(t *testing.T) {
+	useConfig(t, `{"Librato": {"MinSuccessRate": 0.5, "SuccessWindowSeconds": 60, "PauseSeconds": 60}}`)
+	healthy := &attemptCounter{fakeBackend: new(fakeBackend)}
+	failing := &attemptCounter{fakeBackend: &fakeBackend{err: errors.New("unavailable")}}
+	gates := []*successGate{newSuccessGate(healthy), newSuccessGate(failing)}
+	payload := newLibratoPayload()
+	for _, gate := range gates {
+		// a whole window has gone by
+		gate.since = gate.since.Add(-time.Minute)
+		for i := 0; i < minSuccessSamples; i++ {
+			gate.send(payload)
+		}
+	}
+	if paused, rate := gates[0].current(); paused || rate != 1 {
+		t.Errorf("Expected the healthy destination to keep sending, got paused %v at %v", paused, rate)
+	}
+	if paused, rate := gates[1].current(); !paused || rate != 0 {
+		t.Fatalf("Expected the failing destination to be paused, got paused %v at %v", paused, rate)
+	}
+	if err := gates[1].send(payload); err != errDestinationPaused || failing.attempts != minSuccessSamples {
+		t.Errorf("Expected nothing sent while paused, got %v after %d attempts", err, failing.attempts)
+	}
+	// once the pause is up a probe goes through, and resumes sending when it
+	// succeeds
+	gates[1].pausedAt = gates[1].pausedAt.Add(-time.Minute)
+	failing.err = nil
+	if err := gates[1].send(payload); err != nil {
+		t.Fatalf("Expected the probe to be sent, got %v", err)
+	}
+	if paused, _ := gates[1].current(); paused {
+		t.Error("Expected sending to resume after a successful probe")
+	}
+}
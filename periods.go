@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// checkPeriods warns about periods shorter than conf.MinPeriodSeconds,
+// negative ones, and a send period shorter than every collector's, or with
+// conf.StrictPeriods returns them as an error. raw has the periods as they
+// were given, before readConfig replaced negative ones with defaults in c.
+func checkPeriods(raw, c *config) error {
+	var problems []string
+	shortest := flexInt(0)
+	note := func(given, period flexInt, name string) {
+		switch {
+		case given < 0 && period > 0:
+			problems = append(problems, fmt.Sprintf("%s is negative, using %d instead", name, period))
+		case given < 0:
+			problems = append(problems, fmt.Sprintf("%s is negative, which turns the collector off", name))
+		case period > 0 && period < c.MinPeriodSeconds:
+			problems = append(problems, fmt.Sprintf("%s is %d, below conf.MinPeriodSeconds of %d", name, period, c.MinPeriodSeconds))
+		}
+		if period > 0 && (shortest == 0 || period < shortest) {
+			shortest = period
+		}
+	}
+	if raw.Librato.PeriodSeconds < 0 {
+		problems = append(problems, fmt.Sprintf("conf.Librato.PeriodSeconds is negative, using %d instead", c.Librato.PeriodSeconds))
+	}
+	// the collector sections are found by their PeriodSeconds fields, so
+	// new ones are checked without being listed here
+	rawValue, value := reflect.ValueOf(raw).Elem(), reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		section := value.Type().Field(i).Name
+		field := value.Field(i)
+		if section == "Librato" || field.Kind() != reflect.Struct {
+			continue
+		}
+		period := field.FieldByName("PeriodSeconds")
+		if !period.IsValid() || period.Type() != reflect.TypeOf(flexInt(0)) {
+			continue
+		}
+		if section == "Cpu" && c.Cpu.Disabled {
+			continue
+		}
+		given := rawValue.Field(i).FieldByName("PeriodSeconds")
+		note(flexInt(given.Int()), flexInt(period.Int()), fmt.Sprintf("conf.%s.PeriodSeconds", section))
+	}
+	// some collectors run on the send period, unless they have their own
+	onSendPeriod := len(c.StaticGauges) > 0 || c.CollectorLiveness || c.Librato.FailureThreshold > 0 ||
+		(len(c.PortMonitors) > 0 && c.Ports.PeriodSeconds == 0)
+	for _, stats := range c.UnixStats {
+		note(stats.PeriodSeconds, stats.PeriodSeconds, fmt.Sprintf("conf.UnixStats %s PeriodSeconds", stats.NamePrefix))
+		onSendPeriod = onSendPeriod || stats.PeriodSeconds == 0
+	}
+	for _, monitor := range c.LogMonitors {
+		note(monitor.PeriodSeconds, monitor.PeriodSeconds, fmt.Sprintf("conf.LogMonitors %s PeriodSeconds", monitor.Name))
+		onSendPeriod = onSendPeriod || monitor.PeriodSeconds == 0
+	}
+	if shortest > c.Librato.PeriodSeconds && !onSendPeriod {
+		problems = append(problems, fmt.Sprintf("conf.Librato.PeriodSeconds of %d is shorter than every collector's period, so some sends will have nothing in them", c.Librato.PeriodSeconds))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if c.StrictPeriods {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	for _, problem := range problems {
+		fmt.Printf("Warning: %s\n", problem)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPeriods(t *testing.T) {
+	_, err := readTestConfig(t, `{"StrictPeriods": true, "MinPeriodSeconds": 5,
+		"Cpu": {"PeriodSeconds": -3}, "Memory": {"PeriodSeconds": 2}, "Disk": {"PeriodSeconds": -1}, "Procs": {"PeriodSeconds": 10}}`)
+	if err == nil {
+		t.Fatal("Expected the periods to be rejected")
+	}
+	for _, problem := range []string{
+		"conf.Cpu.PeriodSeconds is negative, using 1 instead",
+		"conf.Memory.PeriodSeconds is 2, below conf.MinPeriodSeconds of 5",
+		"conf.Disk.PeriodSeconds is negative, which turns the collector off",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q in %q", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "conf.Procs") {
+		t.Errorf("Expected conf.Procs.PeriodSeconds to be fine, got %q", err)
+	}
+
+	// a zero period is the default, and without StrictPeriods the rest are
+	// only warned about
+	c, err := readTestConfig(t, `{"Cpu": {"PeriodSeconds": 0}, "Memory": {"PeriodSeconds": -5}}`)
+	if err != nil {
+		t.Fatalf("Expected only warnings, got %s", err)
+	}
+	if c.Cpu.PeriodSeconds != 1 {
+		t.Errorf("Expected conf.Cpu.PeriodSeconds to default to 1, got %d", c.Cpu.PeriodSeconds)
+	}
+	if _, err := readTestConfig(t, `{"StrictPeriods": true, "Cpu": {"PeriodSeconds": 0}}`); err != nil {
+		t.Errorf("Expected a zero period to be allowed, got %s", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// portsCollector counts the established connections to each local port in
+// conf.PortMonitors, such as how many clients are connected to 443
+type portsCollector struct{}
+
+func (c *portsCollector) name() string {
+	return "ports"
+}
+
+func (c *portsCollector) period() time.Duration {
+	if conf.Ports.PeriodSeconds > 0 {
+		return seconds(conf.Ports.PeriodSeconds)
+	}
+	return seconds(conf.Librato.PeriodSeconds)
+}
+
+func (c *portsCollector) backend() string {
+	return conf.Ports.Backend
+}
+
+func (c *portsCollector) source() string {
+	return "/proc/net/tcp and /proc/net/tcp6"
+}
+
+func (c *portsCollector) collect() ([]interface{}, error) {
+	sockets, err := readTcpSockets()
+	if err != nil {
+		return nil, err
+	}
+	established := make(map[int]int)
+	for _, socket := range sockets {
+		if socket.state == tcpEstablished {
+			established[socket.localPort]++
+		}
+	}
+	epoch := measureTime(time.Now())
+	var metrics []interface{}
+	for _, port := range conf.PortMonitors {
+		count := established[port]
+		if count == 0 && !conf.Ports.EmitZero {
+			continue
+		}
+		metrics = append(metrics, gauge{Name: fmt.Sprintf("tcp-port-%d-established", port), MeasureTime: epoch, Value: float64(count), Source: hostname})
+	}
+	return metrics, nil
+}
+
+func (c *portsCollector) describe() []string {
+	var names []string
+	for _, port := range conf.PortMonitors {
+		names = append(names, fmt.Sprintf("tcp-port-%d-established", port))
+	}
+	return names
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// the header of /proc/net/tcp and tcp6
+const tcpHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+// tcpLine formats a /proc/net/tcp line
+func tcpLine(local, remote, state, inode string) string {
+	return "   0: " + local + " " + remote + " " + state + " 00000000:00000000 00:00000000 00000000     0        0 " + inode + " 1 0000000000000000 100 0 0 10 0\n"
+}
+
+func TestEstablishedPerPort(t *testing.T) {
+	root := useFakeProc(t)
+	writeFiles(t, filepath.Join(root, "net"), map[string]string{
+		"tcp": tcpHeader +
+			// listening on 443, two clients connected to it and one to 80
+			tcpLine("00000000:01BB", "00000000:0000", "0A", "100") +
+			tcpLine("0100007F:01BB", "0200000A:C350", "01", "101") +
+			tcpLine("0100007F:01BB", "0300000A:C351", "01", "102") +
+			tcpLine("0100007F:0050", "0300000A:C352", "01", "103"),
+		"tcp6": tcpHeader +
+			tcpLine("00000000000000000000000001000000:01BB", "00000000000000000000000001000000:D431", "01", "104"),
+	})
+	useConfig(t, `{"PortMonitors": [443, 80, 22]}`)
+	metrics, err := new(portsCollector).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{"tcp-port-443-established": 3, "tcp-port-80-established": 1}
+	if values := gaugeValues(metrics); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+	conf.Ports.EmitZero = true
+	if metrics, err = new(portsCollector).collect(); err != nil {
+		t.Fatal(err)
+	}
+	if count, ok := gaugeValues(metrics)["tcp-port-22-established"]; !ok || count != 0 {
+		t.Errorf("Expected a zero for port 22 with EmitZero, got %v", count)
+	}
+}
+
+func TestDecodeSocketAddr(t *testing.T) {
+	ip, port, err := decodeSocketAddr("0100007F:1F90")
+	if err != nil || ip.String() != "127.0.0.1" || port != 8080 {
+		t.Errorf("Expected 127.0.0.1:8080, got %s:%d (%v)", ip, port, err)
+	}
+}
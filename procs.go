@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// where /proc is read from. a variable so that tests can point it at a fake
+// /proc
+var procRoot = "/proc"
+
+// procStat holds the fields we care about from /proc/<pid>/stat
+type procStat struct {
+	pid   int
+	comm  string
+	state string
+	ppid  int
+	utime int
+	stime int
+}
+
+// procsCollector reports how many processes are in states that usually mean
+// something is wrong: zombies that nobody reaped, and processes stuck in
+// uninterruptible sleep waiting on I/O. it also counts the sockets held by
+// the processes named in conf.Procs.Sockets, to catch leaks.
+type procsCollector struct {
+	// the processes whose fds we weren't allowed to read, so that it is
+	// only logged once for each
+	denied map[string]bool
+}
+
+func (c *procsCollector) name() string {
+	return "procs"
+}
+
+func (c *procsCollector) period() time.Duration {
+	return seconds(conf.Procs.PeriodSeconds)
+}
+
+func (c *procsCollector) backend() string {
+	return conf.Procs.Backend
+}
+
+func (c *procsCollector) source() string {
+	return "/proc/<pid>/stat"
+}
+
+func (c *procsCollector) collect() ([]interface{}, error) {
+	procs, err := readProcStats()
+	if err != nil {
+		return nil, err
+	}
+	epoch := measureTime(time.Now())
+	var zombie, uninterruptible int
+	for _, proc := range procs {
+		switch proc.state {
+		case "Z":
+			zombie++
+		case "D":
+			uninterruptible++
+		}
+	}
+	metrics := []interface{}{
+		gauge{Name: "procs-zombie", MeasureTime: epoch, Value: float64(zombie), Source: hostname},
+		gauge{Name: "procs-uninterruptible", MeasureTime: epoch, Value: float64(uninterruptible), Source: hostname},
+	}
+	for _, metric := range c.socketCounts(procs, epoch) {
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// socketCounts returns the number of sockets held across all processes with
+// each name in conf.Procs.Sockets. a name is left out when none of its
+// processes could be looked at, since grotto may not be running as root.
+func (c *procsCollector) socketCounts(procs []procStat, epoch int64) []gauge {
+	if len(conf.Procs.Sockets) == 0 {
+		return nil
+	}
+	wanted := make(map[string]bool)
+	for _, name := range conf.Procs.Sockets {
+		wanted[name] = true
+	}
+	// the processes are looked at concurrently, see scanAll, and mu guards
+	// counts and c.denied
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	scanAll(len(procs), func(i int) {
+		proc := procs[i]
+		if !wanted[proc.comm] {
+			return
+		}
+		count, err := countSockets(proc.pid)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if os.IsPermission(err) {
+				if c.denied == nil {
+					c.denied = make(map[string]bool)
+				}
+				if !c.denied[proc.comm] {
+					fmt.Printf("Warning: not allowed to read the fds of %s, its sockets won't be counted\n", proc.comm)
+					c.denied[proc.comm] = true
+				}
+			}
+			return
+		}
+		counts[proc.comm] += count
+	})
+	var gauges []gauge
+	for _, name := range conf.Procs.Sockets {
+		if count, ok := counts[name]; ok {
+			gauges = append(gauges, gauge{Name: fmt.Sprintf("process-%s-sockets", name), MeasureTime: epoch, Value: float64(count), Source: hostname})
+		}
+	}
+	return gauges
+}
+
+func (c *procsCollector) describe() []string {
+	names := []string{"procs-zombie", "procs-uninterruptible"}
+	for _, name := range conf.Procs.Sockets {
+		names = append(names, fmt.Sprintf("process-%s-sockets", name))
+	}
+	return names
+}
+
+// countSockets counts the entries in /proc/<pid>/fd that are links to sockets,
+// which look like socket:[12345]
+func countSockets(pid int) (int, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid), "fd")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			// the fd was closed since the directory was read
+			continue
+		}
+		if strings.HasPrefix(target, "socket:[") {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// readProcStats reads /proc/<pid>/stat for every process on the host, see
+// scanAll. processes that exit while we are looking at them are skipped, see
+// processGone.
+func readProcStats() ([]procStat, error) {
+	pids, err := listPids()
+	if err != nil {
+		return nil, err
+	}
+	// each pid's stat goes in its own slot, so only the error is shared
+	stats := make([]procStat, len(pids))
+	found := make([]bool, len(pids))
+	var mu sync.Mutex
+	var scanErr error
+	scanAll(len(pids), func(i int) {
+		proc, err := readProcStat(pids[i])
+		if err != nil {
+			if !processGone(err) {
+				mu.Lock()
+				scanErr = err
+				mu.Unlock()
+			}
+			return
+		}
+		stats[i], found[i] = proc, true
+	})
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	procs := make([]procStat, 0, len(pids))
+	for i, proc := range stats {
+		if found[i] {
+			procs = append(procs, proc)
+		}
+	}
+	return procs, nil
+}
+
+// processGone reports whether err came from reading the /proc files of a
+// process that has exited. depending on how far along the exit is the kernel
+// says the file doesn't exist or, once the task is being reaped, ESRCH.
+func processGone(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, syscall.ESRCH)
+}
+
+// listPids returns the pids of the numbered directories in procRoot
+func listPids() ([]int, error) {
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// readProcStat parses /proc/<pid>/stat. the comm field is wrapped in parens
+// and may itself contain spaces and parens, so everything after it is found
+// by looking for the last closing paren.
+func readProcStat(pid int) (procStat, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, err
+	}
+	text := string(contents)
+	start, end := strings.IndexByte(text, '('), strings.LastIndexByte(text, ')')
+	if start < 0 || end < start {
+		return procStat{}, fmt.Errorf("Could not parse stat for pid %d", pid)
+	}
+	// fields after comm start at state, which is field 3 in proc(5)
+	fields := strings.Fields(text[end+1:])
+	if len(fields) < 13 {
+		return procStat{}, fmt.Errorf("Could not parse stat for pid %d", pid)
+	}
+	proc := procStat{pid: pid, comm: text[start+1 : end], state: fields[0]}
+	if proc.ppid, err = atoi(fields[1]); err != nil {
+		return procStat{}, err
+	}
+	if proc.utime, err = atoi(fields[11]); err != nil {
+		return procStat{}, err
+	}
+	if proc.stime, err = atoi(fields[12]); err != nil {
+		return procStat{}, err
+	}
+	return proc, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// useFakeProc points procRoot at an empty directory for the rest of the test
+// and returns it
+func useFakeProc(t *testing.T) string {
+	t.Helper()
+	old := procRoot
+	t.Cleanup(func() { procRoot = old })
+	procRoot = t.TempDir()
+	return procRoot
+}
+
+// writeFakeProcess writes a /proc/<pid>/stat for a process under root
+func writeFakeProcess(t *testing.T, root string, pid int, comm, state string, utime, stime int) {
+	t.Helper()
+	writeFakeChild(t, root, pid, 1, comm, state, utime, stime)
+}
+
+// writeFakeChild is writeFakeProcess for a process whose parent isn't init
+func writeFakeChild(t *testing.T, root string, pid, ppid int, comm, state string, utime, stime int) {
+	t.Helper()
+	stat := fmt.Sprintf("%d (%s) %s %d %d %d 0 -1 4194560 100 0 0 0 %d %d 0 0 20 0 1 0 100 1000 10\n", pid, comm, state, ppid, pid, pid, utime, stime)
+	writeFiles(t, filepath.Join(root, strconv.Itoa(pid)), map[string]string{"stat": stat})
+}
+
+func TestProcessGone(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		gone bool
+	}{
+		{&os.PathError{Op: "open", Path: "/proc/1/stat", Err: syscall.ENOENT}, true},
+		{&os.PathError{Op: "read", Path: "/proc/1/stat", Err: syscall.ESRCH}, true},
+		{&os.PathError{Op: "open", Path: "/proc/1/stat", Err: syscall.EACCES}, false},
+		{errors.New("Could not parse stat for pid 1"), false},
+	} {
+		if gone := processGone(test.err); gone != test.gone {
+			t.Errorf("Expected processGone(%v) to be %v", test.err, test.gone)
+		}
+	}
+}
+
+func TestReadProcStats(t *testing.T) {
+	useConfig(t, `{}`)
+	procs, err := readProcStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, proc := range procs {
+		if proc.pid == os.Getpid() {
+			return
+		}
+	}
+	t.Errorf("Expected to find this process among %d", len(procs))
+}
+
+func TestZombieAndUninterruptibleCounts(t *testing.T) {
+	useConfig(t, `{}`)
+	root := useFakeProc(t)
+	writeFakeProcess(t, root, 1, "init", "S", 10, 5)
+	writeFakeProcess(t, root, 200, "defunct worker", "Z", 0, 0)
+	writeFakeProcess(t, root, 300, "nfs (stuck)", "D", 1, 1)
+	writeFakeProcess(t, root, 400, "bash", "R", 3, 2)
+	metrics, err := new(procsCollector).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, metric := range metrics {
+		g := metric.(gauge)
+		values[g.Name] = g.Value
+	}
+	if values["procs-zombie"] != 1 || values["procs-uninterruptible"] != 1 {
+		t.Errorf("Expected one zombie and one uninterruptible process, got %v", values)
+	}
+}
+
+// writeFakeFds links fds for a fake process to each of targets
+func writeFakeFds(t *testing.T, root string, pid int, targets ...string) {
+	t.Helper()
+	dir := filepath.Join(root, strconv.Itoa(pid), "fd")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for fd, target := range targets {
+		if err := os.Symlink(target, filepath.Join(dir, strconv.Itoa(fd))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProcessSocketCounts(t *testing.T) {
+	useConfig(t, `{"Procs": {"Sockets": ["nginx", "sshd"]}}`)
+	root := useFakeProc(t)
+	writeFakeProcess(t, root, 100, "nginx", "S", 1, 1)
+	writeFakeFds(t, root, 100, "/dev/null", "socket:[1001]", "socket:[1002]", "pipe:[5]")
+	writeFakeProcess(t, root, 101, "nginx", "S", 1, 1)
+	writeFakeFds(t, root, 101, "socket:[1003]", "anon_inode:[eventpoll]")
+	writeFakeProcess(t, root, 102, "bash", "S", 1, 1)
+	writeFakeFds(t, root, 102, "socket:[1004]")
+	// sshd's fds can't be looked at
+	writeFakeProcess(t, root, 103, "sshd", "S", 1, 1)
+	metrics, err := new(procsCollector).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := gaugeValues(metrics)
+	if values["process-nginx-sockets"] != 3 {
+		t.Errorf("Expected nginx to hold 3 sockets, got %v", values["process-nginx-sockets"])
+	}
+	if _, ok := values["process-sshd-sockets"]; ok {
+		t.Error("Expected no socket count for sshd, whose fds couldn't be read")
+	}
+}
+
+func TestConcurrentScanMatchesSerial(t *testing.T) {
+	root := useFakeProc(t)
+	states := []string{"S", "Z", "D", "R"}
+	for pid := 1; pid <= 200; pid++ {
+		writeFakeProcess(t, root, pid, "worker", states[pid%len(states)], pid, pid)
+		writeFakeFds(t, root, pid, "socket:[1]", "/dev/null", "socket:[2]")
+	}
+	var readings []map[string]float64
+	for _, concurrency := range []string{"1", "8"} {
+		useConfig(t, `{"ScanConcurrency": `+concurrency+`, "Procs": {"Sockets": ["worker"]}}`)
+		metrics, err := new(procsCollector).collect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		readings = append(readings, gaugeValues(metrics))
+	}
+	expected := map[string]float64{"procs-zombie": 50, "procs-uninterruptible": 50, "process-worker-sockets": 400}
+	for i, values := range readings {
+		if !reflect.DeepEqual(values, expected) {
+			t.Errorf("Expected reading %d to be %v, got %v", i+1, expected, values)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// derivedRates turns the cumulative values of the metrics matching
+// conf.Rates into <name>-per-sec gauges. collectors run on their own
+// goroutines, so the previous values are guarded by a mutex.
+type derivedRates struct {
+	mu       sync.Mutex
+	previous map[metricKey]rateSample
+}
+
+// rateSample is a value and when it was seen. metricKey is reused with a
+// zero measureTime to key these by name and source.
+type rateSample struct {
+	value float64
+	time  time.Time
+}
+
+var metricRates = &derivedRates{previous: make(map[metricKey]rateSample)}
+
+// rate returns the <name>-per-sec gauge for a gauge or counter matching
+// conf.Rates, worked out from the change in its value since it was last seen.
+// nothing is returned the first time a metric is seen, or when its value
+// went down because it was reset.
+func (r *derivedRates) rate(metric interface{}, now time.Time) (gauge, bool) {
+	var name, source string
+	var value float64
+	var epoch int64
+	var context *emitContext
+	switch m := metric.(type) {
+	case gauge:
+		name, source, value, epoch, context = m.Name, m.Source, m.Value, m.MeasureTime, m.context
+	case counter:
+		name, source, value, epoch, context = m.Name, m.Source, float64(m.Value), m.MeasureTime, m.context
+	default:
+		return gauge{}, false
+	}
+	if !matchesAny(conf.rates, name) {
+		return gauge{}, false
+	}
+	key := metricKey{name: name, source: context.qualify(source)}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last, ok := r.previous[key]
+	r.previous[key] = rateSample{value, now}
+	elapsed := now.Sub(last.time).Seconds()
+	if !ok || elapsed <= 0 || value < last.value {
+		return gauge{}, false
+	}
+	return gauge{Name: name + "-per-sec", MeasureTime: epoch, Value: (value - last.value) / elapsed, Source: source, context: context}, true
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDerivedRates(t *testing.T) {
+	useConfig(t, `{"Rates": ["^daemon-requests$"]}`)
+	r := &derivedRates{previous: make(map[metricKey]rateSample)}
+	start := time.Unix(1700000000, 0)
+	var rates []float64
+	// ten seconds apart, with a reset before the last reading
+	for i, value := range []float64{100, 150, 250, 250, 20, 70} {
+		if g, ok := r.rate(testGauge("daemon-requests", value), start.Add(time.Duration(i)*10*time.Second)); ok {
+			if g.Name != "daemon-requests-per-sec" {
+				t.Errorf("Expected daemon-requests-per-sec, got %s", g.Name)
+			}
+			rates = append(rates, g.Value)
+		}
+		if _, ok := r.rate(testGauge("daemon-connections", value), start.Add(time.Duration(i)*10*time.Second)); ok {
+			t.Error("Expected no rate for a metric that isn't in conf.Rates")
+		}
+	}
+	if expected := []float64{5, 10, 0, 5}; !reflect.DeepEqual(rates, expected) {
+		t.Errorf("Expected rates of %v, got %v", expected, rates)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// releaseCollector reports the kernel and distro versions for keeping track
+// of what the fleet is running. versions are strings, so each gauge has its
+// version as a number where that makes sense, as in 6.08 for kernel 6.8, and
+// the whole string in its description.
+type releaseCollector struct{}
+
+func (c *releaseCollector) name() string {
+	return "release"
+}
+
+func (c *releaseCollector) period() time.Duration {
+	return seconds(conf.Release.PeriodSeconds)
+}
+
+func (c *releaseCollector) backend() string {
+	return conf.Release.Backend
+}
+
+// versions hardly ever change, so they can go in the slow tier
+func (c *releaseCollector) slow() bool {
+	return true
+}
+
+func (c *releaseCollector) source() string {
+	return "/proc/sys/kernel/osrelease and /etc/os-release"
+}
+
+func (c *releaseCollector) collect() ([]interface{}, error) {
+	kernel, err := readFileString(filepath.Join(procRoot, "sys/kernel/osrelease"))
+	if err != nil {
+		return nil, err
+	}
+	epoch := measureTime(time.Now())
+	var metrics []interface{}
+	if version, ok := kernelVersion(kernel); ok {
+		metrics = append(metrics, gauge{Name: "kernel-version", Description: kernel, MeasureTime: epoch, Value: version, Source: hostname})
+	}
+	osRelease, err := readOsRelease("/etc/os-release")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if version, err := strconv.ParseFloat(osRelease["VERSION_ID"], 64); err == nil {
+		description := osRelease["PRETTY_NAME"]
+		if description == "" {
+			description = fmt.Sprintf("%s %s", osRelease["ID"], osRelease["VERSION_ID"])
+		}
+		metrics = append(metrics, gauge{Name: "os-version", Description: description, MeasureTime: epoch, Value: version, Source: hostname})
+	}
+	return metrics, nil
+}
+
+func (c *releaseCollector) describe() []string {
+	return []string{"kernel-version", "os-version"}
+}
+
+// kernelVersion turns a release like 6.8.0-45-generic into major.minor with
+// the minor version as hundredths, so 6.8 is 6.08 and sorts below 6.10
+func kernelVersion(release string) (float64, bool) {
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	minor, err := strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil || minor >= 100 {
+		return 0, false
+	}
+	return float64(major) + float64(minor)/100, true
+}
+
+// readOsRelease reads the KEY=value lines of an os-release file, with any
+// quotes around the values taken off
+func readOsRelease(loc string) (map[string]string, error) {
+	file, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		sep := strings.IndexByte(line, '=')
+		if sep <= 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		value := line[sep+1:]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `"'`)
+		}
+		values[line[:sep]] = value
+	}
+	return values, scanner.Err()
+}
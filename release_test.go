@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestKernelVersionGauge(t *testing.T) {
+	root := useFakeProc(t)
+	writeFiles(t, filepath.Join(root, "sys/kernel"), map[string]string{"osrelease": "6.8.0-45-generic\n"})
+	useConfig(t, `{}`)
+	metrics, err := new(releaseCollector).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kernel gauge
+	for _, metric := range metrics {
+		if g := metric.(gauge); g.Name == "kernel-version" {
+			kernel = g
+		}
+	}
+	if kernel.Value != 6.08 || kernel.Description != "6.8.0-45-generic" {
+		t.Errorf("Expected kernel-version 6.08 described as 6.8.0-45-generic, got %v %q", kernel.Value, kernel.Description)
+	}
+	if version, ok := kernelVersion("6.10.3"); !ok || version <= 6.08 {
+		t.Errorf("Expected 6.10 to sort above 6.8, got %v", version)
+	}
+}
+
+func TestReadOsRelease(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"os-release": "# comment\nNAME=\"Ubuntu\"\nVERSION_ID=\"24.04\"\nPRETTY_NAME='Ubuntu 24.04 LTS'\nID=ubuntu\n"})
+	values, err := readOsRelease(filepath.Join(dir, "os-release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["VERSION_ID"] != "24.04" || values["PRETTY_NAME"] != "Ubuntu 24.04 LTS" || values["ID"] != "ubuntu" {
+		t.Errorf("Unexpected os-release values %v", values)
+	}
+}
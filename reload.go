@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// set in the environment of a grotto that was started by a reload
+const reloadedEnv = "GROTTO_RELOADED"
+
+// debounceReloads waits for reload requests and sends on the returned channel
+// once the config in locs should be reloaded. config management tools can
+// write a file in several steps and signal after each, so a burst of requests
+// only counts once nothing has arrived and the files haven't changed for
+// conf.ReloadDebounceMs. the config has to read cleanly before it is reloaded,
+// so that grotto never restarts with one that is broken or half written.
+func debounceReloads(requests <-chan os.Signal, locs string) <-chan struct{} {
+	reloads := make(chan struct{})
+	go func() {
+		quiet := time.Duration(conf.ReloadDebounceMs) * time.Millisecond
+		for range requests {
+			if hasStdinConfig(locs) {
+				fmt.Printf("Not reloading, the config was read from stdin\n")
+				continue
+			}
+			for settled := false; !settled; {
+				before := configModTimes(locs)
+				select {
+				case <-requests:
+				case <-time.After(quiet):
+					settled = configModTimes(locs) == before
+				}
+			}
+			if _, err := readConfig(locs); err != nil {
+				fmt.Printf("Not reloading, could not read config: %s\n", err)
+				continue
+			}
+			reloads <- struct{}{}
+			return
+		}
+	}()
+	return reloads
+}
+
+// hasStdinConfig reports whether one of locs is "-"
+func hasStdinConfig(locs string) bool {
+	for _, loc := range strings.Split(locs, ",") {
+		if strings.TrimSpace(loc) == "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// configModTimes describes when each file in locs was last changed, for
+// telling whether they are still being written
+func configModTimes(locs string) string {
+	var times []string
+	for _, loc := range strings.Split(locs, ",") {
+		info, err := os.Stat(strings.TrimSpace(loc))
+		if err != nil {
+			times = append(times, err.Error())
+			continue
+		}
+		times = append(times, fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size()))
+	}
+	return strings.Join(times, ",")
+}
+
+// reexec replaces grotto with a fresh copy of itself, which is how the config
+// is reloaded. starting over means nothing has to be torn down and rebuilt
+// while collectors and the sender are running.
+func reexec() error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, os.Args, reloadEnviron(os.Environ()))
+}
+
+// reloadEnviron returns environ with reloadedEnv set, replacing it if an
+// earlier reload already set it so that it isn't added again on every reload
+func reloadEnviron(environ []string) []string {
+	env := make([]string, 0, len(environ)+1)
+	for _, entry := range environ {
+		if !strings.HasPrefix(entry, reloadedEnv+"=") {
+			env = append(env, entry)
+		}
+	}
+	return append(env, reloadedEnv+"=1")
+}
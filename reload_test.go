@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadEnvironReplaces(t *testing.T) {
+	env := reloadEnviron([]string{"HOME=/root", reloadedEnv + "=1", "TERM=xterm"})
+	env = reloadEnviron(env)
+	expected := []string{"HOME=/root", "TERM=xterm", reloadedEnv + "=1"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected %v, got %v", expected, env)
+	}
+}
+
+func TestDrainSendsPendingMetrics(t *testing.T) {
+	useConfig(t, `{"Librato": {"PeriodSeconds": 3600}}`)
+	fake := primary(t)
+	drainRequests := make(chan chan struct{})
+	metrics := startTestSender(t, make(chan os.Signal), drainRequests)
+	metrics <- testGauge("pending", 1)
+	drained := make(chan struct{})
+	drainRequests <- drained
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The sender was not drained")
+	}
+	if names := gaugeNames(fake.sent()...); !reflect.DeepEqual(names, []string{"pending"}) {
+		t.Errorf("Expected the pending gauge to have been sent, got %v", names)
+	}
+}
+
+func TestRapidReloadsCountOnce(t *testing.T) {
+	useConfig(t, `{"ReloadDebounceMs": 50}`)
+	loc := filepath.Join(t.TempDir(), "grotto.conf")
+	if err := ioutil.WriteFile(loc, []byte(`{"Librato": {"Email": "e", "Token": "t", "Url": "http://127.0.0.1:1/v1/metrics"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	requests := make(chan os.Signal)
+	reloads := debounceReloads(requests, loc)
+	for i := 0; i < 5; i++ {
+		requests <- syscall.SIGHUP
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a reload once the requests settled")
+	}
+	select {
+	case <-reloads:
+		t.Fatal("Expected only one reload")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+// how many gauges have been dropped for having values that can't be sent
+var droppedGauges uint64
+
+// sanitize is the last line of defense before a payload is sent. gauges that
+// are NaN or infinite can't be encoded as JSON and would fail the whole
+// payload, so they are dropped and counted.
+func (p *libratoPayload) sanitize() {
+	kept := p.Gauges[:0]
+	dropped := 0
+	for _, g := range p.Gauges {
+		if math.IsNaN(g.Value) || math.IsInf(g.Value, 0) {
+			debugf("Dropping gauge %s with value %v\n", g.Name, g.Value)
+			dropped++
+			continue
+		}
+		kept = append(kept, g)
+	}
+	p.Gauges = kept
+	if dropped > 0 {
+		total := atomic.AddUint64(&droppedGauges, uint64(dropped))
+		fmt.Printf("Warning: dropped %d gauges with NaN or infinite values (%d in total)\n", dropped, total)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNaNGaugesAreDroppedAndCounted(t *testing.T) {
+	useConfig(t, `{}`)
+	fake := primary(t)
+	before := atomic.LoadUint64(&droppedGauges)
+	payload := newLibratoPayload()
+	payload.addMetric(testGauge("load", 1))
+	payload.addMetric(testGauge("ratio", math.NaN()))
+	payload.addMetric(testGauge("rate", math.Inf(1)))
+	flushPayload(backends[""], payload)
+	if names := gaugeNames(fake.sent()...); !reflect.DeepEqual(names, []string{"load"}) {
+		t.Errorf("Expected only the finite gauge to be sent, got %v", names)
+	}
+	if dropped := atomic.LoadUint64(&droppedGauges) - before; dropped != 2 {
+		t.Errorf("Expected 2 dropped gauges to be counted, got %d", dropped)
+	}
+}
+
+func TestClampKeepsGaugesInRange(t *testing.T) {
+	useConfig(t, `{"Librato": {"ClampMin": 0, "ClampMax": 100}}`)
+	out := outgoing(&fakeCollector{label: "fake"}, []interface{}{testGauge("low", -5), testGauge("high", 250), testGauge("ok", 50)})
+	if values := gaugeValues(out); values["low"] != 0 || values["high"] != 100 || values["ok"] != 50 {
+		t.Errorf("Expected the gauges to be clamped to [0,100], got %v", values)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+)
+
+// scanAll calls scan with each index from 0 to n-1, spreading the calls over
+// up to conf.ScanConcurrency goroutines, and returns once they are all done.
+// it is for collectors that look at many processes or mounts one at a time,
+// which on a busy host can take longer than their period. scan may be called
+// concurrently, so anything it shares has to be guarded.
+func scanAll(n int, scan func(i int)) {
+	workers := conf.ScanConcurrency
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			scan(i)
+		}
+		return
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				scan(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the oldest /proc/schedstat layout we understand. from 15 on, the last
+// three fields of each cpu line are the time spent running, the time spent
+// waiting to run, and the number of timeslices.
+const minSchedstatVersion = 15
+
+// schedstatCollector reports how much time tasks spend waiting for a cpu
+// once they are ready to run, summed across cpus. this grows well before
+// cpu usage looks alarming and is what latency-sensitive services feel.
+type schedstatCollector struct {
+	rates counterRates
+}
+
+// the rates need a reading to compare against, see differencer
+func (c *schedstatCollector) differences() bool {
+	return true
+}
+
+func (c *schedstatCollector) name() string {
+	return "schedstat"
+}
+
+func (c *schedstatCollector) period() time.Duration {
+	return seconds(conf.SchedStat.PeriodSeconds)
+}
+
+func (c *schedstatCollector) backend() string {
+	return conf.SchedStat.Backend
+}
+
+func (c *schedstatCollector) source() string {
+	return "/proc/schedstat"
+}
+
+func (c *schedstatCollector) collect() ([]interface{}, error) {
+	totals, err := readSchedstat(filepath.Join(procRoot, "schedstat"))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	rates := c.rates.update(totals, now)
+	if rates == nil {
+		return nil, nil
+	}
+	newGauge := func(name string, value float64) gauge {
+		return gauge{Name: name, MeasureTime: measureTime(now), Value: value, Source: hostname}
+	}
+	var metrics []interface{}
+	running, okRunning := rates["running"]
+	waiting, okWaiting := rates["waiting"]
+	if okRunning {
+		metrics = append(metrics, newGauge("sched-run-seconds-per-sec", running/float64(time.Second)))
+	}
+	if okWaiting {
+		metrics = append(metrics, newGauge("sched-wait-seconds-per-sec", waiting/float64(time.Second)))
+	}
+	if timeslices, ok := rates["timeslices"]; ok && okWaiting && timeslices > 0 {
+		metrics = append(metrics, newGauge("sched-wait-ms-per-timeslice", waiting/timeslices/float64(time.Millisecond)))
+	}
+	return metrics, nil
+}
+
+func (c *schedstatCollector) describe() []string {
+	return []string{"sched-run-seconds-per-sec", "sched-wait-seconds-per-sec", "sched-wait-ms-per-timeslice"}
+}
+
+// readSchedstat returns the nanoseconds spent running and waiting and the
+// number of timeslices, summed over the cpu lines of a schedstat file
+func readSchedstat(loc string) (map[string]int64, error) {
+	file, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	totals := map[string]int64{"running": 0, "waiting": 0, "timeslices": 0}
+	version := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[0] == "version" {
+			if version, err = atoi(fields[1]); err != nil {
+				return nil, err
+			}
+			if version < minSchedstatVersion {
+				return nil, fmt.Errorf("Unsupported schedstat version %d", version)
+			}
+			continue
+		}
+		if !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if version == 0 {
+			return nil, fmt.Errorf("No version line before the cpus in %s", loc)
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("Could not parse %s line in %s", fields[0], loc)
+		}
+		last := fields[len(fields)-3:]
+		for i, key := range []string{"running", "waiting", "timeslices"} {
+			value, err := parseInt64(last[i])
+			if err != nil {
+				return nil, err
+			}
+			totals[key] += value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writeSchedstat writes a version 15 schedstat with two cpus that have each
+// run for running ns, waited for waiting ns over timeslices timeslices
+func writeSchedstat(t *testing.T, root string, running, waiting, timeslices int64) {
+	t.Helper()
+	cpu := " 0 0 0 0 0 0 " + strconv.FormatInt(running, 10) + " " + strconv.FormatInt(waiting, 10) + " " + strconv.FormatInt(timeslices, 10) + "\n"
+	writeFiles(t, root, map[string]string{"schedstat": "version 15\ntimestamp 4295000000\ncpu0" + cpu + "domain0 3 0 0 0\ncpu1" + cpu})
+}
+
+func TestSchedstat(t *testing.T) {
+	useConfig(t, `{}`)
+	root := useFakeProc(t)
+	c := &schedstatCollector{}
+	writeSchedstat(t, root, 0, 0, 0)
+	if metrics, err := c.collect(); err != nil || len(metrics) != 0 {
+		t.Fatalf("Expected nothing from the first reading, got %v, %v", metrics, err)
+	}
+	c.rates.previousTime = c.rates.previousTime.Add(-10 * time.Second)
+	// each cpu ran for 5s and waited 1s over 1000 timeslices
+	writeSchedstat(t, root, 5e9, 1e9, 1000)
+	metrics, err := c.collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := gaugeValues(metrics)
+	expected := map[string]float64{
+		"sched-run-seconds-per-sec":   1,
+		"sched-wait-seconds-per-sec":  .2,
+		"sched-wait-ms-per-timeslice": 1,
+	}
+	for name, value := range expected {
+		if got, ok := values[name]; !ok || math.Abs(got-value) > 1e-3 {
+			t.Errorf("Expected %s of %v, got %v", name, value, got)
+		}
+	}
+}
+
+func TestOldSchedstatVersion(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"schedstat": "version 14\ncpu0 1 2 3\n"})
+	if _, err := readSchedstat(filepath.Join(root, "schedstat")); err == nil {
+		t.Error("Expected an error for an unsupported version")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// collectSlots limits how many collectors read at once when
+// conf.CollectorConcurrency is set
+var collectSlots chan struct{}
+
+// startCollectors runs the collectors, with all of those that share a period
+// run off a single ticker so that their readings are taken together rather
+// than drifting apart. the metrics they produce are sent to a channel. the
+// collectors run until the returned scheduler is stopped.
+func startCollectors(collectors []collector, metrics chan interface{}) *scheduler {
+	if conf.CollectorConcurrency > 0 {
+		collectSlots = make(chan struct{}, conf.CollectorConcurrency)
+	}
+	sched := &scheduler{done: make(chan struct{})}
+	groups := make(map[time.Duration][]*scheduledCollector)
+	for _, c := range collectors {
+		s := &scheduledCollector{collector: c, sched: sched, metrics: metrics, first: true}
+		if conf.CollectorLiveness {
+			collectorLiveness.start(c.name(), time.Now())
+		}
+		groups[c.period()] = append(groups[c.period()], s)
+	}
+	for period, group := range groups {
+		sched.running.Add(1)
+		go sched.runCollectors(period, group)
+	}
+	return sched
+}
+
+// a scheduler runs collectors off their tickers until it is stopped. running
+// counts the goroutines it has going, readings included.
+type scheduler struct {
+	done    chan struct{}
+	running sync.WaitGroup
+}
+
+// stop stops the tickers and waits for readings under way to finish
+func (sched *scheduler) stop() {
+	close(sched.done)
+	sched.running.Wait()
+}
+
+// after calls f once d has passed, unless the scheduler is stopped first
+func (sched *scheduler) after(d time.Duration, f func()) {
+	sched.running.Add(1)
+	go func() {
+		defer sched.running.Done()
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			f()
+		case <-sched.done:
+		}
+	}()
+}
+
+// runCollectors starts each collector in group right away and then on every
+// tick of period. a collector that is still busy with its last reading when
+// the next tick comes sits that tick out.
+func (sched *scheduler) runCollectors(period time.Duration, group []*scheduledCollector) {
+	defer sched.running.Done()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		for _, s := range group {
+			sched.running.Add(1)
+			go func(s *scheduledCollector) {
+				defer sched.running.Done()
+				s.tryRun()
+			}(s)
+		}
+		select {
+		case <-ticker.C:
+		case <-sched.done:
+			return
+		}
+	}
+}
+
+// scheduledCollector is a collector along with what the scheduler keeps
+// track of for it. running guards the rest, which only the run holding it
+// may touch.
+type scheduledCollector struct {
+	collector
+	sched    *scheduler
+	metrics  chan interface{}
+	running  int32
+	first    bool
+	failures int
+	skip     int // ticks left to sit out while backing off
+	disabled bool
+}
+
+// tryRun takes a reading unless one is already under way, the collector is
+// disabled, or it is backing off
+func (s *scheduledCollector) tryRun() {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&s.running, 0)
+	if s.disabled {
+		return
+	}
+	if s.skip > 0 {
+		s.skip--
+		return
+	}
+	if collectSlots != nil {
+		collectSlots <- struct{}{}
+		defer func() { <-collectSlots }()
+	}
+	s.run()
+}
+
+// run takes a reading and sends along what it produced. with
+// conf.WarmupImmediate, the first reading of a collector that reports
+// differences, see differencer, is followed quickly by a second so its first
+// metrics don't take two whole periods to show up. a collector that keeps
+// failing, panics included, waits twice as long after each failure, up to
+// maxBackoff, until it succeeds again, and with conf.CollectorLiveness each
+// success is recorded, see liveness. a collector whose first reading fails
+// because what it reads is missing or off limits is disabled, see
+// permanentError.
+func (s *scheduledCollector) run() {
+	c := s.collector
+	values, err := safeCollect(c)
+	first := s.first
+	s.first = false
+	if first && permanentError(err) {
+		// no point trying this again every period, as in containers
+		// where parts of /proc aren't there or can't be read
+		fmt.Printf("Disabling the %s collector: %v\n", c.name(), err)
+		s.disabled = true
+		return
+	}
+	if err != nil {
+		s.failures++
+		fmt.Printf("Could not get %s stats: %v\n", c.name(), err)
+		s.skip = int(backoff(c.period(), s.failures)/c.period()) - 1
+	} else if s.failures > 0 {
+		fmt.Printf("Collecting %s stats again after %d failures\n", c.name(), s.failures)
+		s.failures = 0
+	}
+	if err == nil && conf.CollectorLiveness {
+		collectorLiveness.record(c.name(), time.Now())
+	}
+	for _, metric := range outgoing(c, values) {
+		s.metrics <- metric
+	}
+	if first && conf.WarmupImmediate && err == nil && needsSecondReading(c) && warmupInterval < c.period() {
+		s.sched.after(warmupInterval, s.tryRun)
+	}
+}
+
+// safeCollect takes a reading from c, turning a panic, as from a proc file
+// in a shape the parsing didn't expect, into an error so that the collector
+// backs off and tries again rather than taking grotto down with it
+func safeCollect(c collector) (values []interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("The %s collector panicked: %v\n%s", c.name(), r, debug.Stack())
+			values, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.collect()
+}
+
+// outgoing returns what should be sent for the metrics from a reading of c,
+// which is what is left of them after going through conf.transforms, see
+// newPipeline. metrics for a backend other than the primary one, or for the
+// slow tier, are wrapped in a routedMetric.
+func outgoing(c collector, values []interface{}) []interface{} {
+	out := conf.transforms.run(c, values)
+	slow := false
+	if s, ok := c.(slowCollector); ok && conf.Librato.SlowPeriodSeconds > 0 {
+		slow = s.slow()
+	}
+	if c.backend() != "" || slow {
+		for i, metric := range out {
+			out[i] = routedMetric{backend: c.backend(), slow: slow, metric: metric}
+		}
+	}
+	return out
+}
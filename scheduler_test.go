@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSamePeriodCollectorsFireTogether(t *testing.T) {
+	useConfig(t, `{}`)
+	var mu sync.Mutex
+	fired := make(map[string][]time.Time)
+	reader := func(label string) func(n int) ([]interface{}, error) {
+		return func(n int) ([]interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			fired[label] = append(fired[label], time.Now())
+			return nil, nil
+		}
+	}
+	// the second collector is started later in the list, after one with a
+	// different period
+	collectors := []collector{
+		&fakeCollector{label: "a", every: 100 * time.Millisecond, read: reader("a")},
+		&fakeCollector{label: "other", every: 70 * time.Millisecond, read: reader("other")},
+		&fakeCollector{label: "b", every: 100 * time.Millisecond, read: reader("b")},
+	}
+	sched := startCollectors(collectors, make(chan interface{}, 100))
+	time.Sleep(350 * time.Millisecond)
+	sched.stop()
+	mu.Lock()
+	defer mu.Unlock()
+	a, b := fired["a"], fired["b"]
+	if len(a) < 3 || len(b) < 3 {
+		t.Fatalf("Expected at least 3 readings from each, got %d and %d", len(a), len(b))
+	}
+	for i := 0; i < 3; i++ {
+		if apart := a[i].Sub(b[i]); apart > 20*time.Millisecond || apart < -20*time.Millisecond {
+			t.Errorf("Expected reading %d of both to be taken together, they were %s apart", i+1, apart)
+		}
+	}
+}
+
+func TestStoppedSchedulerStopsReading(t *testing.T) {
+	useConfig(t, `{}`)
+	c := &fakeCollector{label: "ticking", every: 10 * time.Millisecond, read: func(n int) ([]interface{}, error) {
+		return nil, nil
+	}}
+	sched := startCollectors([]collector{c}, make(chan interface{}, 100))
+	waitFor(t, 5*time.Second, func() bool { return c.count() >= 2 })
+	sched.stop()
+	stopped := c.count()
+	time.Sleep(50 * time.Millisecond)
+	if c.count() != stopped {
+		t.Errorf("Expected no readings once stopped, got %d more", c.count()-stopped)
+	}
+}
+
+func TestUnreadableProcDisablesCollector(t *testing.T) {
+	useConfig(t, `{}`)
+	old := conf.Cpu.StatPath
+	defer func() { conf.Cpu.StatPath = old }()
+	conf.Cpu.StatPath = filepath.Join(t.TempDir(), "missing", "stat")
+	missing := &scheduledCollector{collector: newCpuCollector(), metrics: make(chan interface{}, 10), first: true}
+	output := captureOutput(t, func() {
+		missing.tryRun()
+		missing.tryRun()
+	})
+	if !missing.disabled {
+		t.Error("Expected a collector whose proc file is missing to disable itself")
+	}
+	if strings.Count(output, "Disabling the cpu collector") != 1 {
+		t.Errorf("Expected the collector to say once that it was disabled, got %q", output)
+	}
+	denied := &scheduledCollector{collector: &fakeCollector{label: "denied", every: time.Second, read: func(n int) ([]interface{}, error) {
+		return nil, &os.PathError{Op: "open", Path: "/proc/stat", Err: syscall.EACCES}
+	}}, metrics: make(chan interface{}, 10), first: true}
+	denied.run()
+	if !denied.disabled {
+		t.Error("Expected a collector that isn't allowed to read its proc file to disable itself")
+	}
+	// anything else is retried
+	flaky := &scheduledCollector{collector: &fakeCollector{label: "flaky", every: time.Second, read: func(n int) ([]interface{}, error) {
+		return nil, errors.New("Could not parse /proc/stat")
+	}}, metrics: make(chan interface{}, 10), first: true}
+	flaky.run()
+	if flaky.disabled {
+		t.Error("Expected a collector with a transient error to keep trying")
+	}
+}
+
+func TestPanicsBecomeFailures(t *testing.T) {
+	useConfig(t, `{}`)
+	c := &fakeCollector{label: "fragile", every: 10 * time.Millisecond, read: func(n int) ([]interface{}, error) {
+		if n == 1 {
+			var fields []string
+			return []interface{}{testGauge(fields[3], 1)}, nil
+		}
+		return []interface{}{testGauge("count", 1)}, nil
+	}}
+	metrics := make(chan interface{}, 100)
+	output := captureOutput(t, func() {
+		sched := startCollectors([]collector{c}, metrics)
+		defer sched.stop()
+		waitFor(t, 5*time.Second, func() bool { return len(metrics) > 0 })
+	})
+	if !strings.Contains(output, "The fragile collector panicked: runtime error: index out of range") {
+		t.Errorf("Expected the panic to be logged, got %q", output)
+	}
+	if !strings.Contains(output, "Collecting fragile stats again after 1 failures") {
+		t.Errorf("Expected the collector to read again after the panic, got %q", output)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// selfCollector reports on grotto itself: the state of each circuit breaker,
+// see circuitBreaker, as grotto-circuit-<backend>-state, which is 0 when
+// closed, 1 when open and 2 when half open, whether each destination is
+// paused for failing too often along with its success rate, see successGate,
+// with conf.TimeSkewWarnSeconds how far the local clock is off once Librato
+// has said, see clockSkew, and with conf.CollectorLiveness whether each
+// collector is still reading, see liveness.
+type selfCollector struct{}
+
+func (c *selfCollector) name() string {
+	return "self"
+}
+
+func (c *selfCollector) period() time.Duration {
+	return seconds(conf.Librato.PeriodSeconds)
+}
+
+// reports about grotto go to the primary backend
+func (c *selfCollector) backend() string {
+	return ""
+}
+
+func (c *selfCollector) source() string {
+	return "grotto"
+}
+
+func (c *selfCollector) collect() ([]interface{}, error) {
+	epoch := measureTime(time.Now())
+	var metrics []interface{}
+	for _, name := range breakerNames() {
+		b := unwrapBreaker(backends[name])
+		metrics = append(metrics, gauge{Name: fmt.Sprintf("grotto-circuit-%s-state", b.name()), MeasureTime: epoch, Value: float64(b.current()), Source: hostname})
+	}
+	for _, name := range gateNames() {
+		g := backends[name].(*successGate)
+		paused, rate := g.current()
+		state := 0.0
+		if paused {
+			state = 1
+		}
+		metrics = append(metrics,
+			gauge{Name: fmt.Sprintf("grotto-destination-%s-paused", g.name()), MeasureTime: epoch, Value: state, Source: hostname},
+			gauge{Name: fmt.Sprintf("grotto-destination-%s-success-rate", g.name()), MeasureTime: epoch, Value: rate, Source: hostname},
+		)
+	}
+	if skew, ok := libratoClock.current(); ok && conf.TimeSkewWarnSeconds > 0 {
+		metrics = append(metrics, gauge{Name: "clock-skew-seconds", MeasureTime: epoch, Value: skew, Source: hostname})
+	}
+	if conf.CollectorLiveness {
+		metrics = append(metrics, collectorLiveness.metrics(time.Now())...)
+	}
+	return metrics, nil
+}
+
+func (c *selfCollector) describe() []string {
+	return []string{
+		"grotto-circuit-<backend>-state",
+		"grotto-destination-<backend>-paused",
+		"grotto-destination-<backend>-success-rate",
+		"clock-skew-seconds",
+		"<collector>-collections-total",
+		"<collector>-last-collection-age-seconds",
+	}
+}
+
+// breakerNames returns the names in backends of those with a circuit breaker
+func breakerNames() []string {
+	var names []string
+	for name, b := range backends {
+		if unwrapBreaker(b) != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unwrapBreaker returns the circuit breaker of a backend, which may be inside
+// a successGate, or nil if it doesn't have one
+func unwrapBreaker(b backend) *circuitBreaker {
+	if g, ok := b.(*successGate); ok {
+		b = g.backend
+	}
+	breaker, _ := b.(*circuitBreaker)
+	return breaker
+}
+
+// gateNames returns the names in backends of those with a successGate
+func gateNames() []string {
+	var names []string
+	for name, b := range backends {
+		if _, ok := b.(*successGate); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
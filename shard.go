@@ -0,0 +1,34 @@
+package main
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// shardOffset returns where in each period a host sends when
+// conf.Librato.ShardByHostname is set. it comes from a hash of the hostname,
+// so unlike a random delay it is spread across the fleet but stays the same
+// for a host across restarts.
+func shardOffset(host string, period time.Duration) time.Duration {
+	if period <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(host))
+	return time.Duration(h.Sum64() % uint64(period))
+}
+
+// untilShard returns how long from now until host's next place in the period,
+// which is shardOffset past a multiple of period since the epoch. every send
+// is lined up with it, so that sends flushed early for size or a signal don't
+// move the host off its place.
+func untilShard(now time.Time, host string, period time.Duration) time.Duration {
+	if period <= 0 {
+		return 0
+	}
+	wait := shardOffset(host, period) - time.Duration(now.UnixNano()%int64(period))
+	if wait <= 0 {
+		wait += period
+	}
+	return wait
+}
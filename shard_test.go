@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestShardOffsets(t *testing.T) {
+	period := 10 * time.Second
+	web1, web2 := shardOffset("web1", period), shardOffset("web2", period)
+	if web1 == web2 {
+		t.Errorf("Expected different hosts to get different offsets, both got %s", web1)
+	}
+	for _, offset := range []time.Duration{web1, web2} {
+		if offset < 0 || offset >= period {
+			t.Errorf("Expected an offset within the period, got %s", offset)
+		}
+	}
+	if again := shardOffset("web1", period); again != web1 {
+		t.Errorf("Expected the same offset for the same host, got %s and %s", web1, again)
+	}
+}
+
+func TestUntilShard(t *testing.T) {
+	period := 10 * time.Second
+	offset := shardOffset("web1", period)
+	base := time.Unix(1700000000, 0)
+	for _, since := range []time.Duration{0, offset - time.Millisecond, offset, offset + time.Millisecond, period - time.Millisecond} {
+		now := base.Add(since)
+		wait := untilShard(now, "web1", period)
+		if wait <= 0 || wait > period {
+			t.Errorf("Expected a wait within the period at %s, got %s", since, wait)
+		}
+		if at := time.Duration(now.Add(wait).UnixNano() % int64(period)); at != offset {
+			t.Errorf("Expected to wait until %s into the period from %s, got %s", offset, since, at)
+		}
+	}
+}
+
+func TestShardedFlushesStayAligned(t *testing.T) {
+	useConfig(t, `{"Librato": {"PeriodSeconds": 10, "MaxBatchSize": 1, "ShardByHostname": true}}`)
+	fake := primary(t)
+	timers := useFakeTimers(t)
+	metrics := startTestSender(t, make(chan os.Signal), nil)
+	timer := <-timers
+	// flushing for size lines the next flush up with the shard all the same
+	for i := 0; i < 3; i++ {
+		metrics <- testGauge("load", float64(i))
+	}
+	waitFor(t, 5*time.Second, func() bool { return len(fake.sent()) == 3 })
+	period := 10 * time.Second
+	offset := shardOffset(hostname, period)
+	resets := timer.resets()
+	if len(resets) != 3 {
+		t.Fatalf("Expected the timer to be reset for each flush, got %v", resets)
+	}
+	for _, wait := range resets {
+		at := time.Duration(time.Now().Add(wait).UnixNano() % int64(period))
+		// allowing for the time the test took, either side of the start of
+		// a period
+		if apart := (at - offset + period) % period; apart > time.Second && apart < period-time.Second {
+			t.Errorf("Expected each flush to be reset to %s into the period, got %s", offset, at)
+		}
+	}
+}
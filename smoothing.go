@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// smoother applies an exponential moving average to the gauges named in
+// conf.Smoothing. collectors run on their own goroutines, so the previous
+// values are guarded by a mutex.
+type smoother struct {
+	mu       sync.Mutex
+	previous map[metricKey]float64
+}
+
+var gaugeSmoother = &smoother{previous: make(map[metricKey]float64)}
+
+// Apply returns g with its value replaced by alpha*value + (1-alpha)*previous,
+// where alpha is the one configured for its name. the first value seen for a
+// name is passed through as is, and so is any gauge without an alpha. it is
+// the "smooth" transform.
+func (s *smoother) Apply(g gauge) (gauge, bool) {
+	alpha, ok := conf.Smoothing[g.Name]
+	if !ok || math.IsNaN(g.Value) || math.IsInf(g.Value, 0) {
+		return g, true
+	}
+	// gauges with the same name from different mounts and the like are
+	// smoothed separately
+	key := metricKey{name: g.Name, source: g.context.qualify(g.Source)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if previous, ok := s.previous[key]; ok {
+		g.Value = alpha*g.Value + (1-alpha)*previous
+	}
+	s.previous[key] = g.Value
+	return g, true
+}
+
+// checkSmoothing makes sure each alpha in conf.Smoothing is usable
+func checkSmoothing(smoothing map[string]float64) error {
+	for name, alpha := range smoothing {
+		if alpha <= 0 || alpha > 1 {
+			return fmt.Errorf("conf.Smoothing for %s must be greater than 0 and at most 1, got %v", name, alpha)
+		}
+	}
+	return nil
+}
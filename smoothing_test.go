@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSmoothingStepInput(t *testing.T) {
+	useConfig(t, `{"Smoothing": {"cpu-total-usage": 0.5}}`)
+	s := &smoother{previous: make(map[metricKey]float64)}
+	var smoothed, untouched []float64
+	for _, value := range []float64{0, 0, 1, 1, 1, 1} {
+		g, _ := s.Apply(testGauge("cpu-total-usage", value))
+		smoothed = append(smoothed, g.Value)
+		g, _ = s.Apply(testGauge("load", value))
+		untouched = append(untouched, g.Value)
+	}
+	if expected := []float64{0, 0, 0.5, 0.75, 0.875, 0.9375}; !reflect.DeepEqual(smoothed, expected) {
+		t.Errorf("Expected %v, got %v", expected, smoothed)
+	}
+	if expected := []float64{0, 0, 1, 1, 1, 1}; !reflect.DeepEqual(untouched, expected) {
+		t.Errorf("Expected gauges without an alpha to be left alone, got %v", untouched)
+	}
+}
+
+func TestSmoothingAlphaMustBeAFraction(t *testing.T) {
+	if _, err := readTestConfig(t, `{"Smoothing": {"load": 1.5}}`); err == nil {
+		t.Error("Expected an error for an alpha over 1")
+	}
+}
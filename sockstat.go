@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sockstatCollector reports socket usage from /proc/net/sockstat along with
+// the rate at which listen queues overflow and drop connections, which are
+// counted in /proc/net/netstat
+type sockstatCollector struct {
+	drops counterRates
+}
+
+// the rates need a reading to compare against, see differencer
+func (c *sockstatCollector) differences() bool {
+	return true
+}
+
+func (c *sockstatCollector) name() string {
+	return "sockstat"
+}
+
+func (c *sockstatCollector) period() time.Duration {
+	return seconds(conf.SockStat.PeriodSeconds)
+}
+
+func (c *sockstatCollector) backend() string {
+	return conf.SockStat.Backend
+}
+
+func (c *sockstatCollector) source() string {
+	return "/proc/net/sockstat and /proc/net/netstat"
+}
+
+func (c *sockstatCollector) collect() ([]interface{}, error) {
+	sockstat, err := readProcNetPairs(filepath.Join(procRoot, "net/sockstat"), false)
+	if err != nil {
+		return nil, err
+	}
+	netstat, err := readProcNetPairs(filepath.Join(procRoot, "net/netstat"), true)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	newGauge := func(name string, value float64) gauge {
+		return gauge{Name: name, MeasureTime: measureTime(now), Value: value, Source: hostname}
+	}
+	metrics := []interface{}{
+		newGauge("sockstat-tcp-inuse", float64(sockstat["TCP"]["inuse"])),
+		newGauge("sockstat-tcp-orphan", float64(sockstat["TCP"]["orphan"])),
+		newGauge("sockstat-tcp-tw", float64(sockstat["TCP"]["tw"])),
+		newGauge("sockstat-tcp-mem-pages", float64(sockstat["TCP"]["mem"])),
+		newGauge("sockstat-udp-inuse", float64(sockstat["UDP"]["inuse"])),
+	}
+	rates := c.drops.update(map[string]int64{
+		"tcp-listen-overflows-per-sec": netstat["TcpExt"]["ListenOverflows"],
+		"tcp-listen-drops-per-sec":     netstat["TcpExt"]["ListenDrops"],
+	}, now)
+	for name, rate := range rates {
+		metrics = append(metrics, newGauge(name, rate))
+	}
+	return metrics, nil
+}
+
+func (c *sockstatCollector) describe() []string {
+	return []string{
+		"sockstat-tcp-inuse",
+		"sockstat-tcp-orphan",
+		"sockstat-tcp-tw",
+		"sockstat-tcp-mem-pages",
+		"sockstat-udp-inuse",
+		"tcp-listen-overflows-per-sec",
+		"tcp-listen-drops-per-sec",
+	}
+}
+
+// readProcNetPairs reads the files in /proc/net that group values by a
+// "Prefix:" at the start of each line, returning values keyed by prefix and
+// then by name. in sockstat each line holds alternating names and values
+// ("TCP: inuse 5 orphan 0"). in netstat and snmp a line of names is followed
+// by a line of values with the same prefix, which is what headerLines is for.
+func readProcNetPairs(loc string, headerLines bool) (map[string]map[string]int64, error) {
+	file, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	result := make(map[string]map[string]int64)
+	headers := make(map[string][]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		tokens := strings.Fields(scanner.Text())
+		if len(tokens) < 2 {
+			continue
+		}
+		prefix := strings.TrimSuffix(tokens[0], ":")
+		fields := tokens[1:]
+		values := result[prefix]
+		if values == nil {
+			values = make(map[string]int64)
+			result[prefix] = values
+		}
+		if !headerLines {
+			for i := 0; i+1 < len(fields); i += 2 {
+				if value, err := parseInt64(fields[i+1]); err == nil {
+					values[fields[i]] = value
+				}
+			}
+			continue
+		}
+		names, ok := headers[prefix]
+		if !ok {
+			headers[prefix] = fields
+			continue
+		}
+		delete(headers, prefix)
+		if len(names) != len(fields) {
+			return nil, fmt.Errorf("Mismatched %s lines in %s", prefix, loc)
+		}
+		for i, name := range names {
+			value, err := parseInt64(fields[i])
+			if err != nil {
+				return nil, err
+			}
+			values[name] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func writeSockstat(t *testing.T, root string, overflows, drops int) {
+	writeFiles(t, filepath.Join(root, "net"), map[string]string{
+		"sockstat": "sockets: used 300\nTCP: inuse 42 orphan 1 tw 7 alloc 50 mem 12\nUDP: inuse 3 mem 1\n",
+		"netstat": "TcpExt: SyncookiesSent ListenOverflows ListenDrops\n" +
+			"TcpExt: 0 " + strconv.Itoa(overflows) + " " + strconv.Itoa(drops) + "\n" +
+			"IpExt: InNoRoutes\nIpExt: 5\n",
+	})
+}
+
+func TestSockstatInuseAndDropRate(t *testing.T) {
+	root := useFakeProc(t)
+	useConfig(t, `{}`)
+	c := new(sockstatCollector)
+	writeSockstat(t, root, 100, 200)
+	if _, err := c.collect(); err != nil {
+		t.Fatal(err)
+	}
+	// pretend the first reading was ten seconds ago
+	c.drops.previousTime = c.drops.previousTime.Add(-10 * time.Second)
+	writeSockstat(t, root, 100, 250)
+	metrics, err := c.collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, metric := range metrics {
+		g := metric.(gauge)
+		values[g.Name] = g.Value
+	}
+	if values["sockstat-tcp-inuse"] != 42 {
+		t.Errorf("Expected 42 TCP sockets in use, got %v", values["sockstat-tcp-inuse"])
+	}
+	if rate := values["tcp-listen-drops-per-sec"]; math.Abs(rate-5) > 0.1 {
+		t.Errorf("Expected 5 drops per second, got %v", rate)
+	}
+	if rate, ok := values["tcp-listen-overflows-per-sec"]; !ok || rate != 0 {
+		t.Errorf("Expected no overflows, got %v", rate)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// softirqsCollector reports how often each kind of softirq is handled, summed
+// across all cpus
+type softirqsCollector struct {
+	rates counterRates
+}
+
+// the rates need a reading to compare against, see differencer
+func (c *softirqsCollector) differences() bool {
+	return true
+}
+
+func (c *softirqsCollector) name() string {
+	return "softirqs"
+}
+
+func (c *softirqsCollector) period() time.Duration {
+	return seconds(conf.Softirqs.PeriodSeconds)
+}
+
+func (c *softirqsCollector) backend() string {
+	return conf.Softirqs.Backend
+}
+
+func (c *softirqsCollector) source() string {
+	return "/proc/softirqs"
+}
+
+func (c *softirqsCollector) collect() ([]interface{}, error) {
+	totals, err := readSoftirqs()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var metrics []interface{}
+	for kind, rate := range c.rates.update(totals, now) {
+		metrics = append(metrics, gauge{
+			Name:        fmt.Sprintf("softirq-%s-per-sec", strings.ToLower(kind)),
+			MeasureTime: measureTime(now),
+			Value:       rate,
+			Source:      hostname,
+		})
+	}
+	return metrics, nil
+}
+
+func (c *softirqsCollector) describe() []string {
+	return []string{"softirq-<type>-per-sec"}
+}
+
+// readSoftirqs reads /proc/softirqs, which has a header line naming the cpus
+// followed by a line per softirq type like "NET_RX: 10 20", and returns the
+// total for each type across all cpus
+func readSoftirqs() (map[string]int64, error) {
+	file, err := os.Open(filepath.Join(procRoot, "softirqs"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	totals := make(map[string]int64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		tokens := strings.Fields(scanner.Text())
+		if len(tokens) < 2 || !strings.HasSuffix(tokens[0], ":") {
+			// the header line
+			continue
+		}
+		var total int64
+		for _, token := range tokens[1:] {
+			value, err := parseInt64(token)
+			if err != nil {
+				return nil, err
+			}
+			total += value
+		}
+		totals[strings.TrimSuffix(tokens[0], ":")] = total
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
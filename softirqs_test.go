@@ -0,0 +1,35 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNetRxRate(t *testing.T) {
+	root := useFakeProc(t)
+	useConfig(t, `{}`)
+	c := new(softirqsCollector)
+	var metrics []interface{}
+	for i, snapshot := range []string{
+		"                    CPU0       CPU1\n          HI:          1          0\n      NET_RX:       1000       2000\n",
+		"                    CPU0       CPU1\n          HI:          1          0\n      NET_RX:       1100       2100\n",
+	} {
+		writeFiles(t, root, map[string]string{"softirqs": snapshot})
+		var err error
+		if metrics, err = c.collect(); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			// pretend the first reading was ten seconds ago
+			c.rates.previousTime = c.rates.previousTime.Add(-10 * time.Second)
+		}
+	}
+	values := gaugeValues(metrics)
+	if rate, ok := values["softirq-net_rx-per-sec"]; !ok || math.Abs(rate-20) > 0.1 {
+		t.Errorf("Expected 20 NET_RX softirqs per second, got %v", rate)
+	}
+	if rate := values["softirq-hi-per-sec"]; rate != 0 {
+		t.Errorf("Expected no HI softirqs, got %v", rate)
+	}
+}
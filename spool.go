@@ -0,0 +1,208 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the spool keeps payloads that could not be sent in conf.Librato.SpoolDir,
+// and sends them once their backend is taking payloads again. only one drain
+// runs at a time.
+var (
+	spoolMu  sync.Mutex
+	spoolSeq uint64
+)
+
+// spooledPayload is how a payload is written to the spool. the metrics are
+// kept in the legacy form whatever conf.Librato.ApiVersion is, so that they
+// can be read back in.
+type spooledPayload struct {
+	Gauges   []gauge   `json:"gauges"`
+	Counters []counter `json:"counters,omitempty"`
+}
+
+// spoolPayload writes a payload for the backend b to the spool, gzipped
+// when conf.Librato.SpoolCompress is set, and then trims the spool down to
+// conf.Librato.SpoolMaxBytes
+func spoolPayload(b backend, payload *libratoPayload) error {
+	data, err := json.Marshal(spooledPayload{payload.Gauges, payload.Counters})
+	if err != nil {
+		return err
+	}
+	// names sort oldest first and say which backend the payload is for
+	name := fmt.Sprintf("%020d-%06d-%s.json", time.Now().UnixNano(), atomic.AddUint64(&spoolSeq, 1)%1000000, hex.EncodeToString([]byte(backendKey(b))))
+	if conf.Librato.SpoolCompress {
+		name += ".gz"
+	}
+	spoolMu.Lock()
+	defer spoolMu.Unlock()
+	if err := os.MkdirAll(conf.Librato.SpoolDir, 0755); err != nil {
+		return err
+	}
+	loc := filepath.Join(conf.Librato.SpoolDir, name)
+	tmp := loc + ".tmp"
+	if err := writeSpoolFile(tmp, data, conf.Librato.SpoolCompress); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, loc); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return trimSpool()
+}
+
+// writeSpoolFile writes data to loc, gzipped with compress. the file isn't
+// complete until the gzip stream and the file are closed, so failing to
+// close either is an error too.
+func writeSpoolFile(loc string, data []byte, compress bool) error {
+	file, err := os.Create(loc)
+	if err != nil {
+		return err
+	}
+	if compress {
+		gz := gzip.NewWriter(file)
+		_, err = gz.Write(data)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+	} else {
+		_, err = file.Write(data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// trimSpool removes the oldest payloads until the files in the spool, which
+// are compressed if conf.Librato.SpoolCompress is set, take up no more than
+// conf.Librato.SpoolMaxBytes
+func trimSpool() error {
+	if conf.Librato.SpoolMaxBytes <= 0 {
+		return nil
+	}
+	files, err := spoolFiles()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, file := range files {
+		total += file.Size()
+	}
+	for _, file := range files {
+		if total <= conf.Librato.SpoolMaxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(conf.Librato.SpoolDir, file.Name())); err != nil {
+			return err
+		}
+		fmt.Printf("Dropping spooled payload %s to keep the spool under %d bytes\n", file.Name(), conf.Librato.SpoolMaxBytes)
+		total -= file.Size()
+	}
+	return nil
+}
+
+// drainSpool sends the spooled payloads for b, oldest first, stopping at the
+// first one that fails. payloads that became stale while they were spooled
+// are dropped.
+func drainSpool(b backend) {
+	spoolMu.Lock()
+	defer spoolMu.Unlock()
+	files, err := spoolFiles()
+	if err != nil {
+		fmt.Printf("Could not read spool: %s\n", err)
+		return
+	}
+	suffix := "-" + hex.EncodeToString([]byte(backendKey(b))) + ".json"
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".gz")
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		loc := filepath.Join(conf.Librato.SpoolDir, file.Name())
+		payload, err := readSpoolFile(loc)
+		if err != nil {
+			fmt.Printf("Dropping unreadable spooled payload %s: %s\n", file.Name(), err)
+			os.Remove(loc)
+			continue
+		}
+		payload.created = file.ModTime()
+		if payload.stale() {
+			fmt.Printf("Dropping spooled payload %s created at %s\n", file.Name(), payload.created.Format(time.RFC3339))
+			os.Remove(loc)
+			continue
+		}
+		if err := b.send(payload); err != nil {
+			debugf("Could not send spooled payload %s to %s: %s\n", file.Name(), b.name(), err)
+			return
+		}
+		os.Remove(loc)
+	}
+}
+
+// readSpoolFile reads a spooled payload back in, decompressing it if its
+// name ends in .gz whatever conf.Librato.SpoolCompress is now
+func readSpoolFile(loc string) (*libratoPayload, error) {
+	file, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if strings.HasSuffix(loc, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var spooled spooledPayload
+	if err := json.NewDecoder(r).Decode(&spooled); err != nil {
+		return nil, err
+	}
+	payload := newLibratoPayload()
+	payload.Gauges, payload.Counters = spooled.Gauges, spooled.Counters
+	return payload, nil
+}
+
+// spoolFiles returns the payloads in the spool, oldest first
+func spoolFiles() ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(conf.Librato.SpoolDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") || strings.HasSuffix(entry.Name(), ".json.gz") {
+			files = append(files, entry)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files, nil
+}
+
+// backendKey returns the name b goes by in backends
+func backendKey(b backend) string {
+	for name, candidate := range backends {
+		if candidate == b {
+			return name
+		}
+	}
+	return b.name()
+}
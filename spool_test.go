@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompressedSpoolDrains(t *testing.T) {
+	c := useConfig(t, `{"Librato": {"SpoolCompress": true}}`)
+	c.Librato.SpoolDir = t.TempDir()
+	fake := new(fakeBackend)
+	payload := newLibratoPayload()
+	payload.addMetric(testGauge("load", 1))
+	if err := spoolPayload(fake, payload); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(c.Librato.SpoolDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), ".json.gz") {
+		t.Fatalf("Expected one gzipped payload in the spool, got %v", entries)
+	}
+	// payloads spooled compressed are still read back once compression is off
+	c.Librato.SpoolCompress = false
+	drainSpool(fake)
+	if names := gaugeNames(fake.sent()...); !reflect.DeepEqual(names, []string{"load"}) {
+		t.Errorf("Expected the spooled gauge to be sent, got %v", names)
+	}
+	if files, _ := spoolFiles(); len(files) != 0 {
+		t.Errorf("Expected the spool to be empty after draining, got %d files", len(files))
+	}
+}
+
+func TestSpoolFileCloseErrors(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("No /dev/full to write to")
+	}
+	// the gzip stream is buffered, so writing to a full disk only fails
+	// once it is closed
+	if err := writeSpoolFile("/dev/full", []byte(`{"gauges": []}`), true); err == nil {
+		t.Error("Expected an error from closing a compressed spool file on a full disk")
+	}
+}
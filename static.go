@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// staticCollector sends the values in conf.StaticGauges as they are, once
+// every send period, which is handy for stamping hosts with things like a
+// deploy version
+type staticCollector struct{}
+
+func (c *staticCollector) name() string {
+	return "static"
+}
+
+func (c *staticCollector) period() time.Duration {
+	return seconds(conf.Librato.PeriodSeconds)
+}
+
+// static gauges always go to the primary backend
+func (c *staticCollector) backend() string {
+	return ""
+}
+
+func (c *staticCollector) source() string {
+	return "the config"
+}
+
+func (c *staticCollector) collect() ([]interface{}, error) {
+	epoch := measureTime(time.Now())
+	var metrics []interface{}
+	for _, name := range c.describe() {
+		metrics = append(metrics, gauge{Name: name, MeasureTime: epoch, Value: conf.StaticGauges[name], Source: hostname})
+	}
+	return metrics, nil
+}
+
+func (c *staticCollector) describe() []string {
+	names := make([]string, 0, len(conf.StaticGauges))
+	for name := range conf.StaticGauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
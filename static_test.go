@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStaticGaugesEveryPeriod(t *testing.T) {
+	useConfig(t, `{"StaticGauges": {"deploy-version": 42}}`)
+	var static collector
+	for _, c := range enabledCollectors() {
+		if c.name() == "static" {
+			static = c
+		}
+	}
+	if static == nil {
+		t.Fatal("Expected the static collector to be enabled by conf.StaticGauges")
+	}
+	for i := 0; i < 2; i++ {
+		metrics, err := static.collect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(metrics) != 1 || metrics[0].(gauge).Source != "test" || gaugeValues(metrics)["deploy-version"] != 42 {
+			t.Errorf("Expected deploy-version 42 for the host from reading %d, got %v", i+1, metrics)
+		}
+	}
+}
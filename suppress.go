@@ -0,0 +1,45 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// changeSuppressor leaves out gauges that have moved no more than
+// conf.ChangeThreshold since they were last sent, to save sending flat
+// metrics over and over. a gauge is sent anyway after conf.MaxSuppressPeriods
+// readings in a row were left out, so dashboards don't show a gap. collectors
+// run on their own goroutines, so the state is guarded by a mutex.
+type changeSuppressor struct {
+	mu   sync.Mutex
+	sent map[metricKey]*suppressState
+}
+
+type suppressState struct {
+	value      float64
+	suppressed int
+}
+
+var gaugeSuppressor = &changeSuppressor{sent: make(map[metricKey]*suppressState)}
+
+// Apply reports whether g should be sent, remembering its value if so. it is
+// the "suppress" transform.
+func (s *changeSuppressor) Apply(g gauge) (gauge, bool) {
+	if conf.ChangeThreshold == nil {
+		return g, true
+	}
+	key := metricKey{name: g.Name, source: g.context.qualify(g.Source)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.sent[key]
+	if !ok {
+		s.sent[key] = &suppressState{value: g.Value}
+		return g, true
+	}
+	if math.Abs(g.Value-state.value) <= *conf.ChangeThreshold && state.suppressed < conf.MaxSuppressPeriods {
+		state.suppressed++
+		return g, false
+	}
+	state.value, state.suppressed = g.Value, 0
+	return g, true
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuppressionWithForcedSend(t *testing.T) {
+	useConfig(t, `{"ChangeThreshold": 0.5, "MaxSuppressPeriods": 3}`)
+	s := &changeSuppressor{sent: make(map[metricKey]*suppressState)}
+	var sent []float64
+	// flat for a while, then a jump
+	for _, value := range []float64{10, 10.1, 9.9, 10.2, 10.3, 10.1, 12, 12.2} {
+		if g, ok := s.Apply(testGauge("load", value)); ok {
+			sent = append(sent, g.Value)
+		}
+	}
+	// 10.3 is sent after 3 readings were left out, then 12 is a change
+	if expected := []float64{10, 10.3, 12}; !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %v to be sent, got %v", expected, sent)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// swapDevice is a line from /proc/swaps
+type swapDevice struct {
+	name string
+	size int64 // bytes
+	used int64 // bytes
+}
+
+// swapCollector reports how much of each swap device or file is in use, along
+// with the totals across all of them. it runs with the memory collector when
+// conf.Memory.SwapDevices is set. hosts without swap report nothing.
+type swapCollector struct{}
+
+func (c *swapCollector) name() string {
+	return "swap"
+}
+
+func (c *swapCollector) period() time.Duration {
+	return seconds(conf.Memory.PeriodSeconds)
+}
+
+func (c *swapCollector) backend() string {
+	return conf.Memory.Backend
+}
+
+func (c *swapCollector) source() string {
+	return "/proc/swaps"
+}
+
+func (c *swapCollector) collect() ([]interface{}, error) {
+	devices, err := readSwaps(filepath.Join(procRoot, "swaps"))
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, nil
+	}
+	epoch := measureTime(time.Now())
+	newGauge := func(name string, value float64) gauge {
+		return gauge{Name: name, MeasureTime: epoch, Value: value, Source: hostname}
+	}
+	var metrics []interface{}
+	var size, used int64
+	for _, device := range devices {
+		// devices are named by their last path element, so /dev/sda2 is
+		// swap-sda2, since Librato doesn't allow slashes
+		prefix := "swap-" + filepath.Base(device.name)
+		metrics = append(metrics, newGauge(prefix+"-used-bytes", float64(device.used)))
+		if device.size > 0 {
+			metrics = append(metrics, newGauge(prefix+"-used-percent", float64(device.used)/float64(device.size)))
+		}
+		size += device.size
+		used += device.used
+	}
+	metrics = append(metrics,
+		newGauge("swap-total-bytes", float64(size)),
+		newGauge("swap-used-bytes", float64(used)),
+	)
+	if size > 0 {
+		metrics = append(metrics, newGauge("swap-used-percent", float64(used)/float64(size)))
+	}
+	return metrics, nil
+}
+
+func (c *swapCollector) describe() []string {
+	return []string{
+		"swap-<device>-used-bytes",
+		"swap-<device>-used-percent",
+		"swap-total-bytes",
+		"swap-used-bytes",
+		"swap-used-percent",
+	}
+}
+
+// readSwaps parses a swaps file, whose sizes are in kilobytes. names with
+// spaces in them are escaped like in /proc/mounts.
+func readSwaps(loc string) ([]swapDevice, error) {
+	file, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var devices []swapDevice
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // the header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		size, err := parseInt64(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse size of %s in %s", fields[0], loc)
+		}
+		used, err := parseInt64(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse usage of %s in %s", fields[0], loc)
+		}
+		devices = append(devices, swapDevice{name: unescapeMountField(fields[0]), size: size * 1024, used: used * 1024})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSwapDevices(t *testing.T) {
+	useConfig(t, `{}`)
+	root := useFakeProc(t)
+	writeFiles(t, root, map[string]string{"swaps": `Filename				Type		Size		Used		Priority
+/dev/sda2                               partition	1000		250		-2
+/swap\040file                           file		3000		750		-3
+`})
+	metrics, err := (&swapCollector{}).collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := gaugeValues(metrics)
+	expected := map[string]float64{
+		"swap-sda2-used-bytes":        250 * 1024,
+		"swap-sda2-used-percent":      .25,
+		"swap-swap file-used-bytes":   750 * 1024,
+		"swap-swap file-used-percent": .25,
+		"swap-total-bytes":            4000 * 1024,
+		"swap-used-bytes":             1000 * 1024,
+		"swap-used-percent":           .25,
+	}
+	if len(values) != len(expected) {
+		t.Errorf("Expected %d gauges, got %v", len(expected), values)
+	}
+	for name, value := range expected {
+		if got, ok := values[name]; !ok || math.Abs(got-value) > 1e-9 {
+			t.Errorf("Expected %s of %v, got %v", name, value, got)
+		}
+	}
+}
+
+func TestNoSwap(t *testing.T) {
+	useConfig(t, `{}`)
+	root := useFakeProc(t)
+	writeFiles(t, root, map[string]string{"swaps": "Filename\tType\tSize\tUsed\tPriority\n"})
+	if metrics, err := (&swapCollector{}).collect(); err != nil || len(metrics) != 0 {
+		t.Errorf("Expected nothing without swap, got %v, %v", metrics, err)
+	}
+}
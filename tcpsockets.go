@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the st column of /proc/net/tcp for an established connection
+const tcpEstablished = 0x01
+
+// tcpSocket is a line from /proc/net/tcp or /proc/net/tcp6
+type tcpSocket struct {
+	localAddr  net.IP
+	localPort  int
+	remoteAddr net.IP
+	remotePort int
+	state      int
+	inode      string
+}
+
+// readTcpSockets returns the sockets in both /proc/net/tcp and /proc/net/tcp6.
+// a kernel without ipv6 has no tcp6, which isn't an error.
+func readTcpSockets() ([]tcpSocket, error) {
+	sockets, err := readTcpSocketFile(filepath.Join(procRoot, "net/tcp"))
+	if err != nil {
+		return nil, err
+	}
+	sockets6, err := readTcpSocketFile(filepath.Join(procRoot, "net/tcp6"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return append(sockets, sockets6...), nil
+}
+
+func readTcpSocketFile(loc string) ([]tcpSocket, error) {
+	file, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var sockets []tcpSocket
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // the header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		var socket tcpSocket
+		if socket.localAddr, socket.localPort, err = decodeSocketAddr(fields[1]); err != nil {
+			return nil, err
+		}
+		if socket.remoteAddr, socket.remotePort, err = decodeSocketAddr(fields[2]); err != nil {
+			return nil, err
+		}
+		state, err := strconv.ParseInt(fields[3], 16, 0)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse socket state %s in %s", fields[3], loc)
+		}
+		socket.state = int(state)
+		socket.inode = fields[9]
+		sockets = append(sockets, socket)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sockets, nil
+}
+
+// decodeSocketAddr decodes an address like 0100007F:1F90. the port is plain
+// hex, but the address is written as 32 bit words in the kernel's byte order,
+// which is little endian on the hosts we run on, so 0100007F is 127.0.0.1.
+func decodeSocketAddr(field string) (net.IP, int, error) {
+	sep := strings.IndexByte(field, ':')
+	if sep < 0 {
+		return nil, 0, fmt.Errorf("Could not parse socket address %s", field)
+	}
+	raw, err := hex.DecodeString(field[:sep])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("Could not parse socket address %s", field)
+	}
+	port, err := strconv.ParseUint(field[sep+1:], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Could not parse socket port %s", field)
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(raw[i:]))
+	}
+	return ip, int(port), nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// testSend sends a single grotto-test gauge through the primary backend and
+// reports how it went. for Librato that includes what Librato said about it.
+// it returns whether the gauge was accepted.
+func testSend() bool {
+	// added directly so that conf.Filter can't leave it out
+	payload := newLibratoPayload()
+	payload.Gauges = []gauge{{Name: "grotto-test", MeasureTime: measureTime(time.Now()), Value: 1, Source: hostname}}
+	b := newBackend()
+	if s, ok := b.(stopper); ok {
+		defer s.stop()
+	}
+	if _, ok := b.(*libratoBackend); !ok {
+		fmt.Printf("Sending grotto-test to %s\n", b.name())
+		if err := b.send(payload); err != nil {
+			fmt.Printf("Could not send grotto-test: %s\n", err)
+			return false
+		}
+		fmt.Printf("grotto-test was sent\n")
+		return true
+	}
+	fmt.Printf("Sending grotto-test to %s\n", conf.Librato.Url)
+	status, body, err := postPayload(newLibratoBackend("librato", conf.Librato.Url, conf.Librato.Email, conf.Librato.Token), payload)
+	if err != nil {
+		fmt.Printf("Could not send grotto-test: %s\n", err)
+		return false
+	}
+	fmt.Printf("Librato responded with %d: %s\n", status, body)
+	if status >= 300 {
+		fmt.Printf("grotto-test was rejected\n")
+		return false
+	}
+	fmt.Printf("grotto-test was accepted\n")
+	return true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTestSendUsesPrimaryBackend(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	useConfig(t, fmt.Sprintf(`{"Dogstatsd": {"Addr": %q}}`, listener.LocalAddr().String()))
+	if !testSend() {
+		t.Fatal("Expected grotto-test to be sent to DogStatsD")
+	}
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, dogstatsdMaxPacketSize)
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(buf[:n]), "grotto-test:1|g") {
+		t.Errorf("Expected grotto-test in the packet, got %q", buf[:n])
+	}
+}
+
+func TestTestSendFlushesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	useConfig(t, fmt.Sprintf(`{"FileOutput": {"Path": %q}}`, path))
+	if !testSend() {
+		t.Fatal("Expected grotto-test to be sent to the file")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"name":"grotto-test"`) {
+		t.Errorf("Expected grotto-test in the file, got %s", data)
+	}
+}
+
+func TestTestSendToLibrato(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	useConfig(t, fmt.Sprintf(`{"Librato": {"Url": %q}}`, server.URL))
+	if !testSend() {
+		t.Error("Expected grotto-test to be accepted")
+	}
+	status = http.StatusBadRequest
+	if testSend() {
+		t.Error("Expected grotto-test to be rejected")
+	}
+}
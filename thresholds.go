@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// a threshold sets off an alert for metrics whose names match its pattern
+// when their value compares to Value using Comparison, which is one of >,
+// >=, < or <= and defaults to >
+type threshold struct {
+	Value      float64
+	Comparison string
+	pattern    *regexp.Regexp
+}
+
+// compileThresholds checks conf.Thresholds and compiles their patterns,
+// returning them in a stable order
+func compileThresholds(thresholds map[string]*threshold) ([]*threshold, error) {
+	patterns := make([]string, 0, len(thresholds))
+	for pattern := range thresholds {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	compiled := make([]*threshold, 0, len(patterns))
+	for _, pattern := range patterns {
+		t := thresholds[pattern]
+		if t == nil {
+			return nil, fmt.Errorf("Missing threshold for %q", pattern)
+		}
+		switch t.Comparison {
+		case "":
+			t.Comparison = ">"
+		case ">", ">=", "<", "<=":
+		default:
+			return nil, fmt.Errorf("Unknown comparison %q for threshold %q", t.Comparison, pattern)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid threshold pattern %q: %s", pattern, err)
+		}
+		t.pattern = re
+		compiled = append(compiled, t)
+	}
+	return compiled, nil
+}
+
+func (t *threshold) exceeded(value float64) bool {
+	switch t.Comparison {
+	case ">=":
+		return value >= t.Value
+	case "<":
+		return value < t.Value
+	case "<=":
+		return value <= t.Value
+	}
+	return value > t.Value
+}
+
+// thresholdAlerts returns a <name>-alert gauge for a metric that has
+// thresholds, with a value of 1 if any of them were exceeded and 0 otherwise
+func thresholdAlerts(metric interface{}) []interface{} {
+	g, ok := metric.(gauge)
+	if !ok {
+		return nil
+	}
+	matched, exceeded := false, false
+	for _, t := range conf.thresholds {
+		if t.pattern.MatchString(g.Name) {
+			matched = true
+			exceeded = exceeded || t.exceeded(g.Value)
+		}
+	}
+	if !matched {
+		return nil
+	}
+	alert := g
+	alert.Name = g.Name + "-alert"
+	alert.Value = 0
+	if exceeded {
+		alert.Value = 1
+	}
+	return []interface{}{alert}
+}
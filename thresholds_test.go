@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestThresholdAlert(t *testing.T) {
+	useConfig(t, `{"Thresholds": {"cpu.*-usage$": {"Value": 0.9}}}`)
+	reading := []interface{}{testGauge("cpu-total-usage", 0.95), testGauge("cpu0-usage", 0.5), testGauge("load", 4)}
+	out := outgoing(&fakeCollector{label: "cpu"}, reading)
+	expected := []string{"cpu-total-usage", "cpu-total-usage-alert", "cpu0-usage", "cpu0-usage-alert", "load"}
+	if names := metricNames(out); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	values := gaugeValues(out)
+	if values["cpu-total-usage-alert"] != 1 || values["cpu0-usage-alert"] != 0 {
+		t.Errorf("Expected only cpu-total-usage to be alerting, got %v", values)
+	}
+}
+
+func TestThresholdComparison(t *testing.T) {
+	if _, err := readTestConfig(t, `{"Thresholds": {"load": {"Value": 1, "Comparison": "=="}}}`); err == nil {
+		t.Error("Expected an error for an unknown comparison")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// a sendTier is a payload for each backend that are all flushed together on
+// their own timer. normally there is just the one, but with
+// conf.Librato.SlowPeriodSeconds metrics from slow collectors go in a second
+// tier that is flushed less often, see slowCollector.
+type sendTier struct {
+	payloads map[string]*libratoPayload
+	timer    tierTimer
+	period   func() time.Duration
+}
+
+// newSendTier starts a tier whose first flush is after first, and each one
+// after that comes period from the last
+func newSendTier(first time.Duration, period func() time.Duration) *sendTier {
+	return &sendTier{
+		payloads: make(map[string]*libratoPayload),
+		timer:    newTierTimer(first),
+		period:   period,
+	}
+}
+
+// a tierTimer is what a sendTier is flushed on. outside of tests it is a
+// time.Timer.
+type tierTimer interface {
+	fired() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// wallTimer is a tierTimer that goes off on its own
+type wallTimer struct {
+	*time.Timer
+}
+
+func (t wallTimer) fired() <-chan time.Time {
+	return t.C
+}
+
+// newTierTimer makes the timer for each new tier
+var newTierTimer = func(d time.Duration) tierTimer {
+	return wallTimer{time.NewTimer(d)}
+}
+
+// the payloads being flushed, so that a reload can wait for them to be sent
+var inFlight sync.WaitGroup
+
+// flush sends each payload out, unless there is nothing to send, and starts
+// the period over
+func (t *sendTier) flush() {
+	sendPayloads(t.payloads)
+	t.payloads = make(map[string]*libratoPayload)
+	// if the timer fired while we were flushing for size, throw that
+	// away so that it doesn't cause a second, nearly empty flush
+	if !t.timer.Stop() {
+		select {
+		case <-t.timer.fired():
+		default:
+		}
+	}
+	t.timer.Reset(t.period())
+}
+
+// sendPayloads sends each payload that has anything in it to its backend
+func sendPayloads(payloads map[string]*libratoPayload) {
+	for name, payload := range payloads {
+		if payload.size() > 0 {
+			inFlight.Add(1)
+			go func(b backend, payload *libratoPayload) {
+				defer inFlight.Done()
+				flushPayload(b, payload)
+			}(backends[name], payload)
+		}
+	}
+}
+
+// drainBurst sends a burst of metrics as it comes in rather than waiting for
+// the end of the period. once conf.Librato.HighWatermark metrics are waiting
+// they are sent conf.Librato.LowWatermark at a time until no more than that
+// are left, which keeps each send to the backend down to a steady size
+// without sending every time a single threshold is crossed.
+func (t *sendTier) drainBurst() {
+	if conf.Librato.HighWatermark <= 0 || t.size() < conf.Librato.HighWatermark {
+		return
+	}
+	debugf("Draining a burst of %d metrics\n", t.size())
+	for t.size() > conf.Librato.LowWatermark {
+		chunk := make(map[string]*libratoPayload)
+		left := conf.Librato.LowWatermark
+		for name, payload := range t.payloads {
+			if left == 0 {
+				break
+			}
+			chunk[name] = payload.take(left)
+			left -= chunk[name].size()
+		}
+		sendPayloads(chunk)
+	}
+}
+
+// size is how many metrics are waiting across the tier's payloads
+func (t *sendTier) size() int {
+	size := 0
+	for _, payload := range t.payloads {
+		size += payload.size()
+	}
+	return size
+}
+
+// a slowCollector is a collector whose metrics don't need to be sent every
+// period, such as disk usage, which go in the slow tier when there is one
+type slowCollector interface {
+	slow() bool
+}
+
+// isSlow reports whether a metric came from a slow collector
+func isSlow(metric interface{}) bool {
+	routed, ok := metric.(routedMetric)
+	return ok && routed.slow
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTimer is a tierTimer that only goes off when the test fires it
+type fakeTimer struct {
+	c     chan time.Time
+	mu    sync.Mutex
+	reset []time.Duration
+}
+
+func (t *fakeTimer) fired() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	return true
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reset = append(t.reset, d)
+	return true
+}
+
+// fire waits for the sender to take the tick
+func (t *fakeTimer) fire() {
+	t.c <- time.Now()
+}
+
+// resets returns what the timer was reset to each time
+func (t *fakeTimer) resets() []time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]time.Duration(nil), t.reset...)
+}
+
+// useFakeTimers makes the timers of tiers made during the test fakeTimers,
+// which are sent along in the order the tiers are made
+func useFakeTimers(t *testing.T) <-chan *fakeTimer {
+	timers := make(chan *fakeTimer, 2)
+	old := newTierTimer
+	t.Cleanup(func() { newTierTimer = old })
+	newTierTimer = func(time.Duration) tierTimer {
+		timer := &fakeTimer{c: make(chan time.Time)}
+		timers <- timer
+		return timer
+	}
+	return timers
+}
+
+// slowFake is a fakeCollector in the slow tier
+type slowFake struct {
+	*fakeCollector
+}
+
+func (slowFake) slow() bool {
+	return true
+}
+
+func TestSlowTier(t *testing.T) {
+	useConfig(t, `{"Librato": {"PeriodSeconds": 1, "SlowPeriodSeconds": 2}}`)
+	fake := primary(t)
+	timers := useFakeTimers(t)
+	metrics := startTestSender(t, make(chan os.Signal), nil)
+	fast, slow := <-timers, <-timers
+	for _, metric := range outgoing(slowFake{&fakeCollector{label: "disk"}}, []interface{}{testGauge("disk-used-bytes", 1)}) {
+		metrics <- metric
+	}
+	for _, metric := range outgoing(&fakeCollector{label: "cpu"}, []interface{}{testGauge("cpu-total-usage", 1)}) {
+		metrics <- metric
+	}
+	// the fast tier goes out after a period, without the slow gauge
+	fast.fire()
+	waitFor(t, 5*time.Second, func() bool { return len(fake.sent()) > 0 })
+	if names := gaugeNames(fake.sent()...); !reflect.DeepEqual(names, []string{"cpu-total-usage"}) {
+		t.Fatalf("Expected only the fast gauge after a period, got %v", names)
+	}
+	slow.fire()
+	waitFor(t, 5*time.Second, func() bool { return len(fake.sent()) > 1 })
+	if names := gaugeNames(fake.sent()...); !reflect.DeepEqual(names, []string{"cpu-total-usage", "disk-used-bytes"}) {
+		t.Errorf("Expected the slow gauge after the slow period, got %v", names)
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	useConfig(t, `{"Librato": {"HighWatermark": 10, "LowWatermark": 4}}`)
+	fake := primary(t)
+	tier := newSendTier(time.Hour, func() time.Duration { return time.Hour })
+	add := func(n int) {
+		for i := 0; i < n; i++ {
+			addToPayloads(tier.payloads, testGauge(fmt.Sprintf("burst-%d", i), 1))
+			tier.drainBurst()
+		}
+		inFlight.Wait()
+	}
+	// payloads are flushed concurrently, so they may be sent in any order
+	sizes := func() []int {
+		var sizes []int
+		for _, payload := range fake.sent() {
+			sizes = append(sizes, payload.size())
+		}
+		sort.Ints(sizes)
+		return sizes
+	}
+	// nothing goes out until the high watermark, and then the burst is sent
+	// in chunks of the low watermark until no more than that is left
+	add(9)
+	if sent := sizes(); len(sent) != 0 {
+		t.Fatalf("Expected nothing sent below the high watermark, got %v", sent)
+	}
+	add(1)
+	if sent := sizes(); !reflect.DeepEqual(sent, []int{4, 4}) {
+		t.Fatalf("Expected two chunks of 4, got %v", sent)
+	}
+	if left := tier.size(); left != 2 {
+		t.Fatalf("Expected 2 metrics left for the end of the period, got %d", left)
+	}
+	// a burst added all at once is drained the same way
+	for i := 0; i < 15; i++ {
+		addToPayloads(tier.payloads, testGauge(fmt.Sprintf("expanded-%d", i), 1))
+	}
+	tier.drainBurst()
+	inFlight.Wait()
+	if sent := sizes(); !reflect.DeepEqual(sent, []int{4, 4, 4, 4, 4, 4}) {
+		t.Errorf("Expected the rest in chunks of 4, got %v", sent)
+	}
+	if left := tier.size(); left != 1 {
+		t.Errorf("Expected 1 metric left, got %d", left)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Transform is a step each gauge goes through on its way from a collector to
+// the backends. Apply returns the gauge to pass along, which may have been
+// changed, or false if the gauge should be dropped.
+type Transform interface {
+	Apply(g gauge) (gauge, bool)
+}
+
+// a stage is one step of a pipeline. it sees all of the metrics from a
+// reading of a collector at once, so that it can add metrics, merge them or
+// change counters as well as gauges.
+type stage interface {
+	applyReading(c collector, metrics []interface{}) []interface{}
+}
+
+// eachGauge is the stage for a Transform. it applies it to each gauge in a
+// reading and passes everything else along as is.
+type eachGauge struct {
+	Transform
+}
+
+func (e eachGauge) applyReading(c collector, metrics []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(metrics))
+	for _, metric := range metrics {
+		if g, ok := metric.(gauge); ok {
+			if g, ok = e.Apply(g); !ok {
+				continue
+			}
+			metric = g
+		}
+		out = append(out, metric)
+	}
+	return out
+}
+
+// pipeline runs the metrics from a reading through its stages in order
+type pipeline []stage
+
+func (p pipeline) run(c collector, metrics []interface{}) []interface{} {
+	for _, s := range p {
+		if len(metrics) == 0 {
+			break
+		}
+		metrics = s.applyReading(c, metrics)
+	}
+	return metrics
+}
+
+// the stages that can be named in conf.Transforms, in the order they run when
+// it is empty. configured reports whether the setting that a stage is for has
+// been given, in which case the stage has to be in the pipeline.
+var stages = []struct {
+	name       string
+	stage      stage
+	setting    string
+	configured func(c *config) bool
+}{
+	{"rates", rateStage{}, "conf.Rates", func(c *config) bool { return len(c.rates) > 0 }},
+	{"smooth", eachGauge{gaugeSmoother}, "conf.Smoothing", func(c *config) bool { return len(c.Smoothing) > 0 }},
+	{"suppress", eachGauge{gaugeSuppressor}, "conf.ChangeThreshold", func(c *config) bool { return c.ChangeThreshold != nil }},
+	{"thresholds", thresholdStage{}, "conf.Thresholds", func(c *config) bool { return len(c.thresholds) > 0 }},
+	{"filter", renamer(filterName), "conf.Filter", func(c *config) bool { return len(c.Filter.Include)+len(c.Filter.Exclude) > 0 }},
+	{"clamp", eachGauge{clampTransform{}}, "conf.Librato.ClampMin and ClampMax", func(c *config) bool {
+		return c.Librato.ClampMin != nil || c.Librato.ClampMax != nil
+	}},
+	{"prefix", renamer(prefixName), "conf.MetricPrefix", func(c *config) bool { return c.MetricPrefix != "" }},
+	{"normalize", renamer(normalizeName), "conf.Librato.NormalizeNames", func(c *config) bool { return c.Librato.NormalizeNames }},
+	{"provenance", provenanceStage{}, "conf.Librato.IncludeProvenance", func(c *config) bool { return c.Librato.IncludeProvenance }},
+	{"compact", compactStage{}, "conf.CompactEmit", func(c *config) bool { return len(c.CompactEmit) > 0 }},
+}
+
+// newPipeline builds a pipeline out of the stages named in c.Transforms. with
+// no names it is made of every stage whose setting has been given. a setting
+// whose stage was left out of the names is an error rather than being
+// ignored, and so is naming a stage twice, which would have stages like
+// smooth count each reading twice.
+func newPipeline(c *config) (pipeline, error) {
+	names := c.Transforms
+	named := make(map[string]bool, len(names))
+	for _, name := range names {
+		if named[name] {
+			return nil, fmt.Errorf("Transform %q is in conf.Transforms more than once", name)
+		}
+		named[name] = true
+	}
+	var p pipeline
+	for _, s := range stages {
+		if len(names) == 0 && s.configured(c) {
+			p = append(p, s.stage)
+		} else if len(names) > 0 && s.configured(c) && !named[s.name] {
+			return nil, fmt.Errorf("%s is set but conf.Transforms doesn't include %q", s.setting, s.name)
+		}
+	}
+	for _, name := range names {
+		found := false
+		for _, s := range stages {
+			if s.name == name {
+				p = append(p, s.stage)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown transform %q in conf.Transforms", name)
+		}
+	}
+	return p, nil
+}
+
+// renamer is a stage and a Transform that renames or drops metrics by their
+// names, and so applies to counters as well as gauges. it returns the new
+// name, or false if the metric should be dropped.
+type renamer func(name string) (string, bool)
+
+func (r renamer) Apply(g gauge) (gauge, bool) {
+	var ok bool
+	g.Name, ok = r(g.Name)
+	return g, ok
+}
+
+func (r renamer) applyReading(c collector, metrics []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(metrics))
+	for _, metric := range metrics {
+		var ok bool
+		switch m := metric.(type) {
+		case gauge:
+			m.Name, ok = r(m.Name)
+			metric = m
+		case counter:
+			m.Name, ok = r(m.Name)
+			metric = m
+		default:
+			ok = true
+		}
+		if ok {
+			out = append(out, metric)
+		}
+	}
+	return out
+}
+
+// filterName drops metrics that conf.Filter doesn't allow. it is the "filter"
+// transform.
+func filterName(name string) (string, bool) {
+	return name, conf.Filter.allows(name)
+}
+
+// prefixName puts conf.MetricPrefix in front of metric names. it is the
+// "prefix" transform.
+func prefixName(name string) (string, bool) {
+	return conf.MetricPrefix + name, true
+}
+
+// clampTransform keeps gauges within conf.Librato.ClampMin and ClampMax. NaN
+// and infinite values are left for sanitize to drop. it is the "clamp"
+// transform.
+type clampTransform struct{}
+
+func (clampTransform) Apply(g gauge) (gauge, bool) {
+	if math.IsNaN(g.Value) || math.IsInf(g.Value, 0) {
+		return g, true
+	}
+	if low := conf.Librato.ClampMin; low != nil && g.Value < *low {
+		g.Value = *low
+	}
+	if high := conf.Librato.ClampMax; high != nil && g.Value > *high {
+		g.Value = *high
+	}
+	return g, true
+}
+
+// rateStage adds the rates of the metrics matching conf.Rates, see
+// derivedRates. it is the "rates" transform.
+type rateStage struct{}
+
+func (rateStage) applyReading(c collector, metrics []interface{}) []interface{} {
+	now := time.Now()
+	out := append([]interface{}{}, metrics...)
+	for _, metric := range metrics {
+		if rate, ok := metricRates.rate(metric, now); ok {
+			out = append(out, rate)
+		}
+	}
+	return out
+}
+
+// thresholdStage adds the alerts set off by conf.Thresholds after the metrics
+// that set them off, see thresholdAlerts. it is the "thresholds" transform.
+type thresholdStage struct{}
+
+func (thresholdStage) applyReading(c collector, metrics []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(metrics))
+	for _, metric := range metrics {
+		out = append(out, metric)
+		out = append(out, thresholdAlerts(metric)...)
+	}
+	return out
+}
+
+// provenanceStage describes gauges without a description by the collector and
+// source they came from. it is the "provenance" transform.
+type provenanceStage struct{}
+
+func (provenanceStage) applyReading(c collector, metrics []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(metrics))
+	for _, metric := range metrics {
+		if g, ok := metric.(gauge); ok && g.Description == "" {
+			g.Description = fmt.Sprintf("%s collector from %s", c.name(), c.source())
+			metric = g
+		}
+		out = append(out, metric)
+	}
+	return out
+}
+
+// compactStage packs the reading of a collector named in conf.CompactEmit into
+// a single gauge, see compactMetrics. it is the "compact" transform.
+type compactStage struct{}
+
+func (compactStage) applyReading(c collector, metrics []interface{}) []interface{} {
+	if !compacted(c.name()) {
+		return metrics
+	}
+	return []interface{}{compactMetrics(c.name(), metrics)}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dropper drops gauges whose names start with drop
+type dropper struct {
+	drop string
+}
+
+func (d dropper) Apply(g gauge) (gauge, bool) {
+	return g, !strings.HasPrefix(g.Name, d.drop)
+}
+
+func TestPrefixerAndDropper(t *testing.T) {
+	c := useConfig(t, `{"MetricPrefix": "web."}`)
+	reading := []interface{}{testGauge("cpu", 1), testGauge("debug-cpu", 2), testGauge("web.cpu", 3)}
+	collector := &fakeCollector{label: "fake"}
+	// dropping after prefixing sees the prefixed names, so only the gauge
+	// that was already prefixed matches
+	prefixFirst := pipeline{renamer(prefixName), eachGauge{dropper{"web.web."}}}
+	if names := metricNames(prefixFirst.run(collector, reading)); !reflect.DeepEqual(names, []string{"web.cpu", "web.debug-cpu"}) {
+		t.Errorf("Prefixing then dropping gave %v", names)
+	}
+	// dropping first sees the names the collector gave
+	dropFirst := pipeline{eachGauge{dropper{"debug-"}}, renamer(prefixName)}
+	if names := metricNames(dropFirst.run(collector, reading)); !reflect.DeepEqual(names, []string{"web.cpu", "web.web.cpu"}) {
+		t.Errorf("Dropping then prefixing gave %v", names)
+	}
+	// the configured pipeline prefixes the gauges that are sent
+	if names := metricNames(c.transforms.run(collector, reading[:1])); !reflect.DeepEqual(names, []string{"web.cpu"}) {
+		t.Errorf("The configured pipeline gave %v", names)
+	}
+}
+
+func TestTransformsMustIncludeConfiguredStages(t *testing.T) {
+	c := &config{Transforms: []string{"suppress"}, Smoothing: map[string]float64{"load": 0.5}}
+	if _, err := newPipeline(c); err == nil {
+		t.Error("Expected an error for conf.Smoothing without the smooth transform")
+	}
+	c.Transforms = []string{"suppress", "smooth"}
+	if _, err := newPipeline(c); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	c.Transforms = []string{"sharpen"}
+	if _, err := newPipeline(c); err == nil {
+		t.Error("Expected an error for an unknown transform")
+	}
+	c.Transforms = []string{"suppress", "smooth", "suppress"}
+	if _, err := newPipeline(c); err == nil {
+		t.Error("Expected an error for a transform named twice")
+	}
+}
+
+func TestFilterOnlyRunsInPipeline(t *testing.T) {
+	useConfig(t, `{"Filter": {"Exclude": ["^secret"]}, "Transforms": ["prefix", "filter"], "MetricPrefix": "secret-"}`)
+	// the filter runs after the prefix, so it drops everything, but metrics
+	// added to a payload directly are no longer filtered again
+	collector := &fakeCollector{label: "fake"}
+	if out := outgoing(collector, []interface{}{testGauge("cpu", 1)}); len(out) != 0 {
+		t.Errorf("Expected the filter to drop the prefixed gauge, got %v", out)
+	}
+	payload := newLibratoPayload()
+	payload.addMetric(testGauge("secret-cpu", 1))
+	if payload.size() != 1 {
+		t.Errorf("Expected addMetric to keep the gauge, got %d metrics", payload.size())
+	}
+}
+
+// metricNames returns the names of the gauges and counters in metrics
+func metricNames(metrics []interface{}) []string {
+	var names []string
+	for _, metric := range metrics {
+		switch m := metric.(type) {
+		case gauge:
+			names = append(names, m.Name)
+		case counter:
+			names = append(names, m.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long a daemon gets to answer a command before the reading is given up
+const unixStatsTimeout = 2 * time.Second
+
+// unixStatsConfig is an entry in conf.UnixStats
+type unixStatsConfig struct {
+	NamePrefix    string
+	SocketPath    string
+	Command       string
+	PeriodSeconds flexInt // defaults to conf.Librato.PeriodSeconds
+	Backend       string
+}
+
+// unixStatsCollector asks a daemon for its stats over a unix socket. it
+// connects, writes the command, and turns each "key value" line of the
+// response into a gauge named <prefix>-<key>.
+type unixStatsCollector struct {
+	conf *unixStatsConfig
+}
+
+func (c *unixStatsCollector) name() string {
+	return "unixstats-" + c.conf.NamePrefix
+}
+
+func (c *unixStatsCollector) period() time.Duration {
+	if c.conf.PeriodSeconds > 0 {
+		return seconds(c.conf.PeriodSeconds)
+	}
+	return seconds(conf.Librato.PeriodSeconds)
+}
+
+func (c *unixStatsCollector) backend() string {
+	return c.conf.Backend
+}
+
+func (c *unixStatsCollector) source() string {
+	return c.conf.SocketPath
+}
+
+func (c *unixStatsCollector) collect() ([]interface{}, error) {
+	conn, err := net.DialTimeout("unix", c.conf.SocketPath, unixStatsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(unixStatsTimeout))
+	command := c.conf.Command
+	if !strings.HasSuffix(command, "\n") {
+		command += "\n"
+	}
+	if _, err := conn.Write([]byte(command)); err != nil {
+		return nil, err
+	}
+	epoch := measureTime(time.Now())
+	var metrics []interface{}
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		name := fmt.Sprintf("%s-%s", c.conf.NamePrefix, fields[0])
+		metrics = append(metrics, gauge{Name: name, MeasureTime: epoch, Value: value, Source: hostname})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+func (c *unixStatsCollector) describe() []string {
+	return []string{c.conf.NamePrefix + "-<key>"}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUnixStatsGauges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	commands := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		command, _ := bufio.NewReader(conn).ReadString('\n')
+		commands <- command
+		conn.Write([]byte("connections 12\nqueue-depth 3.5\nversion v1.2\nnot a stat line\n"))
+	}()
+	useConfig(t, `{}`)
+	c := &unixStatsCollector{conf: &unixStatsConfig{NamePrefix: "daemon", SocketPath: path, Command: "stats"}}
+	metrics, err := c.collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command := <-commands; command != "stats\n" {
+		t.Errorf("Expected the daemon to get the stats command, got %q", command)
+	}
+	expected := map[string]float64{"daemon-connections": 12, "daemon-queue-depth": 3.5}
+	if values := gaugeValues(metrics); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+	listener.Close()
+	if _, err := c.collect(); err == nil {
+		t.Error("Expected an error once the daemon has gone away")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// vmstatCollector reports how often pages fault in, and how many of those
+// faults had to go to disk. a climbing major fault rate is a sign of
+// thrashing rather than healthy use of the page cache.
+type vmstatCollector struct {
+	rates counterRates
+}
+
+// the /proc/vmstat counters to report, and the gauge each becomes
+var vmstatFaults = map[string]string{
+	"pgfault":    "page-faults-per-sec",
+	"pgmajfault": "major-page-faults-per-sec",
+}
+
+// the rates need a reading to compare against, see differencer
+func (c *vmstatCollector) differences() bool {
+	return true
+}
+
+func (c *vmstatCollector) name() string {
+	return "vmstat"
+}
+
+func (c *vmstatCollector) period() time.Duration {
+	return seconds(conf.Vmstat.PeriodSeconds)
+}
+
+func (c *vmstatCollector) backend() string {
+	return conf.Vmstat.Backend
+}
+
+func (c *vmstatCollector) source() string {
+	return "/proc/vmstat"
+}
+
+func (c *vmstatCollector) collect() ([]interface{}, error) {
+	counters, err := readVmstat()
+	if err != nil {
+		return nil, err
+	}
+	faults := make(map[string]int64)
+	for key := range vmstatFaults {
+		if value, ok := counters[key]; ok {
+			faults[key] = value
+		}
+	}
+	now := time.Now()
+	var metrics []interface{}
+	for key, rate := range c.rates.update(faults, now) {
+		metrics = append(metrics, gauge{
+			Name:        vmstatFaults[key],
+			MeasureTime: measureTime(now),
+			Value:       rate,
+			Source:      hostname,
+		})
+	}
+	return metrics, nil
+}
+
+func (c *vmstatCollector) describe() []string {
+	return []string{"page-faults-per-sec", "major-page-faults-per-sec"}
+}
+
+// readVmstat reads /proc/vmstat, which has a "name value" line per counter.
+// it is read once per collection and anything else that wants vmstat
+// counters should take them from here.
+func readVmstat() (map[string]int64, error) {
+	file, err := os.Open(filepath.Join(procRoot, "vmstat"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	counters := make(map[string]int64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		tokens := strings.Fields(scanner.Text())
+		if len(tokens) != 2 {
+			continue
+		}
+		value, err := parseInt64(tokens[1])
+		if err != nil {
+			return nil, err
+		}
+		counters[tokens[0]] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return counters, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestVmstatFaultRates(t *testing.T) {
+	useConfig(t, `{}`)
+	root := useFakeProc(t)
+	c := &vmstatCollector{}
+	writeFiles(t, root, map[string]string{"vmstat": "nr_free_pages 1000\npgfault 5000\npgmajfault 10\n"})
+	if metrics, err := c.collect(); err != nil || len(metrics) != 0 {
+		t.Fatalf("Expected nothing from the first reading, got %v, %v", metrics, err)
+	}
+	c.rates.previousTime = c.rates.previousTime.Add(-10 * time.Second)
+	writeFiles(t, root, map[string]string{"vmstat": "nr_free_pages 900\npgfault 6000\npgmajfault 30\n"})
+	metrics, err := c.collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := gaugeValues(metrics)
+	expected := map[string]float64{"page-faults-per-sec": 100, "major-page-faults-per-sec": 2}
+	if len(values) != len(expected) {
+		t.Errorf("Expected %d gauges, got %v", len(expected), values)
+	}
+	for name, value := range expected {
+		if got, ok := values[name]; !ok || math.Abs(got-value) > 1e-3*value {
+			t.Errorf("Expected %s of %v, got %v", name, value, got)
+		}
+	}
+}
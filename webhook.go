@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// flushSummary is what gets posted to conf.Webhook.Url after each payload
+// that was sent successfully
+type flushSummary struct {
+	Timestamp   int64   `json:"timestamp"`
+	Host        string  `json:"host"`
+	Backend     string  `json:"backend"`
+	Metrics     int     `json:"metrics"`
+	Bytes       int     `json:"bytes"`
+	DurationSec float64 `json:"duration_seconds"`
+}
+
+// summaries waiting to be posted to the webhook by postWebhooks. the queue is
+// short and summaries that don't fit are dropped, so a slow or broken webhook
+// costs a few summaries rather than a goroutine for every flush.
+var (
+	webhookQueue  = make(chan webhookPost, 8)
+	webhookWorker sync.Once
+)
+
+// webhookPost is a summary along with where to post it
+type webhookPost struct {
+	url     string
+	summary flushSummary
+}
+
+// notifyWebhook queues a summary of a successful flush for the webhook. it is
+// posted in the background so that the webhook can't hold up sending
+// metrics, and failures are only logged, see limitedf.
+func notifyWebhook(summary flushSummary) {
+	webhookWorker.Do(func() { go postWebhooks(webhookQueue) })
+	select {
+	case webhookQueue <- webhookPost{conf.Webhook.Url, summary}:
+	default:
+		limitedf("Dropping flush summaries, the webhook is falling behind\n")
+	}
+}
+
+// postWebhooks posts each queued summary in turn
+func postWebhooks(posts <-chan webhookPost) {
+	for post := range posts {
+		if err := postWebhook(post.url, post.summary); err != nil {
+			limitedf("Could not notify webhook: %s\n", err)
+		}
+	}
+}
+
+func postWebhook(url string, summary flushSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// newFlushSummary summarizes a payload that was sent to b in elapsed
+func newFlushSummary(b backend, payload *libratoPayload, size int, elapsed time.Duration) flushSummary {
+	return flushSummary{
+		Timestamp:   time.Now().Unix(),
+		Host:        hostname,
+		Backend:     b.name(),
+		Metrics:     payload.size(),
+		Bytes:       size,
+		DurationSec: elapsed.Seconds(),
+	}
+}
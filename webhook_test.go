@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookAfterFlush(t *testing.T) {
+	summaries := make(chan flushSummary, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var summary flushSummary
+		if err := json.NewDecoder(r.Body).Decode(&summary); err != nil {
+			t.Errorf("Could not parse flush summary: %s", err)
+		}
+		summaries <- summary
+	}))
+	defer server.Close()
+	c := useConfig(t, `{}`)
+	c.Webhook.Url = server.URL
+	fake := new(fakeBackend)
+	payload := newLibratoPayload()
+	payload.addMetric(testGauge("load", 1))
+	payload.addMetric(testGauge("cpu", 2))
+	flushPayload(fake, payload)
+	select {
+	case summary := <-summaries:
+		if summary.Host != "test" || summary.Backend != "fake" || summary.Metrics != 2 || summary.Bytes == 0 {
+			t.Errorf("Unexpected flush summary %+v", summary)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The webhook was not notified")
+	}
+	// failed flushes aren't reported
+	fake.err = errors.New("unavailable")
+	payload = newLibratoPayload()
+	payload.addMetric(testGauge("load", 1))
+	flushPayload(fake, payload)
+	select {
+	case summary := <-summaries:
+		t.Errorf("Expected no webhook for a failed flush, got %+v", summary)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSlowWebhookDropsSummaries(t *testing.T) {
+	received := make(chan struct{}, 100)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer server.Close()
+	c := useConfig(t, `{}`)
+	c.Webhook.Url = server.URL
+	limitedMu.Lock()
+	limitedMessages = make(map[string]*limitedMessage)
+	limitedMu.Unlock()
+	summary := flushSummary{Host: "test", Backend: "fake", Metrics: 1}
+	// the first summary holds up the worker, and the queue behind it fills
+	notifyWebhook(summary)
+	<-received
+	output := captureOutput(t, func() {
+		for i := 0; i < cap(webhookQueue)+5; i++ {
+			notifyWebhook(summary)
+		}
+	})
+	if !strings.Contains(output, "Dropping flush summaries") {
+		t.Errorf("Expected summaries to be dropped, got %q", output)
+	}
+	close(release)
+	for i := 0; i < cap(webhookQueue); i++ {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the %d queued summaries to be posted, got %d", cap(webhookQueue), i)
+		}
+	}
+	select {
+	case <-received:
+		t.Error("Expected the summaries that didn't fit to be dropped")
+	case <-time.After(100 * time.Millisecond):
+	}
+}